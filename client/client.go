@@ -5,7 +5,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	neturl "net/url"
+	"strconv"
 
+	"github.com/PanGan21/crypto-exchange-poc/decimal"
 	"github.com/PanGan21/crypto-exchange-poc/orderbook"
 	"github.com/PanGan21/crypto-exchange-poc/server"
 )
@@ -14,6 +17,8 @@ const url = "http://localhost:3000"
 
 type Client struct {
 	*http.Client
+	// AdminKey is sent with the requests to the admin API
+	AdminKey string
 }
 
 func NewClient() *Client {
@@ -24,13 +29,65 @@ func NewClient() *Client {
 
 type PlaceOrderParams struct {
 	UserId int64
+	Market server.Market
 	Bid    bool
 	// Price only needed for placing LIMIT orders
-	Price float64
-	Size  float64
+	Price decimal.Decimal
+	Size  decimal.Decimal
+	// TimeInForce defaults to GTC for LIMIT orders and IOC for MARKET orders
+	TimeInForce orderbook.TimeInForce
+	// ExpiresAt in unix nanoseconds, only needed for GTD orders
+	ExpiresAt int64
+	// PostOnly makes a LIMIT order maker only
+	PostOnly orderbook.PostOnly
+	// StopPrice activates stop orders, a stop order with a Price becomes a stop limit
+	StopPrice decimal.Decimal
+	// TrailingAmount or TrailingPercent of the last trade price is the distance of a trailing stop
+	TrailingAmount  decimal.Decimal
+	TrailingPercent decimal.Decimal
+	// Peg makes a PEGGED order track the best price of its side (PRIMARY) or the midpoint (MID)
+	Peg orderbook.Peg
+	// PegOffset is added to the peg price and PegCap limits how far a pegged order can go
+	PegOffset decimal.Decimal
+	PegCap    decimal.Decimal
+	// MaxSlippage in basis points of the best price or WorstPrice bound the fills of a MARKET order
+	MaxSlippage decimal.Decimal
+	WorstPrice  decimal.Decimal
+	// DisplaySize makes a LIMIT order an iceberg showing only this much of its size
+	DisplaySize decimal.Decimal
+	// SelfTradePrevention overrides the mode set for the account
+	SelfTradePrevention orderbook.SelfTradePrevention
+	// Group places the order as the take profit of an OCO group or as the entry of a bracket
+	Group *server.OrderGroupRequest
 }
 
-func (c *Client) GetTrades(market string) ([]*orderbook.Trade, error) {
+// GetMarket returns the trading rules of a market
+func (c *Client) GetMarket(market server.Market) (*server.MarketResponse, error) {
+	endpoint := fmt.Sprintf("%s/markets/%s", url, market)
+
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, decodeAPIError(resp)
+	}
+
+	marketResponse := &server.MarketResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(marketResponse); err != nil {
+		return nil, err
+	}
+
+	return marketResponse, nil
+}
+
+func (c *Client) GetTrades(market server.Market) ([]*orderbook.Trade, error) {
 	endpoint := fmt.Sprintf("%s/trades/%s", url, market)
 
 	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
@@ -53,22 +110,28 @@ func (c *Client) GetTrades(market string) ([]*orderbook.Trade, error) {
 
 func (c *Client) PlaceLimitOrder(p *PlaceOrderParams) (*server.PlaceOrderResponse, error) {
 	params := &server.PlaceOrderRequest{
-		UserId: p.UserId,
-		Type:   server.LimitOrder,
-		Bid:    p.Bid,
-		Size:   p.Size,
-		Price:  p.Price,
-		Market: server.MarketETH,
+		UserId:      p.UserId,
+		Type:        server.LimitOrder,
+		Bid:         p.Bid,
+		Size:        p.Size,
+		Price:       p.Price,
+		Market:      p.Market,
+		TimeInForce: p.TimeInForce,
+		ExpiresAt:   p.ExpiresAt,
+		PostOnly:    p.PostOnly,
+		DisplaySize: p.DisplaySize,
+		Group:       p.Group,
+
+		SelfTradePrevention: p.SelfTradePrevention,
 	}
 
-	body, err := json.Marshal(params)
-	if err != nil {
-		return nil, err
-	}
+	return c.placeOrder(params)
+}
 
-	endpoint := url + "/order"
+func (c *Client) GetOrders(userId int64) (*server.GetOrdersResponse, error) {
+	endpoint := fmt.Sprintf("%s/order/%d", url, userId)
 
-	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -78,16 +141,41 @@ func (c *Client) PlaceLimitOrder(p *PlaceOrderParams) (*server.PlaceOrderRespons
 		return nil, err
 	}
 
-	placeOrderResponse := &server.PlaceOrderResponse{}
-	if err := json.NewDecoder(resp.Body).Decode(&placeOrderResponse); err != nil {
+	orders := server.GetOrdersResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(&orders); err != nil {
 		return nil, err
 	}
+	return &orders, nil
+}
 
-	return placeOrderResponse, nil
+// OrderHistoryParams filters the order history, zero values don't filter
+type OrderHistoryParams struct {
+	Market server.Market
+	Status orderbook.OrderStatus
+	// From and To bound the creation time of the orders in Unix nanoseconds
+	From int64
+	To   int64
+	// Cursor is the NextCursor of the previous page
+	Cursor int64
+	Limit  int64
 }
 
-func (c *Client) GetOrders(userId int64) (*server.GetOrdersResponse, error) {
-	endpoint := fmt.Sprintf("%s/order/%d", url, userId)
+// GetOrderHistory returns a page of every order userId placed, oldest first
+func (c *Client) GetOrderHistory(userId int64, p *OrderHistoryParams) (*server.OrderHistoryResponse, error) {
+	query := neturl.Values{}
+	if p.Market != "" {
+		query.Set("market", string(p.Market))
+	}
+	if p.Status != "" {
+		query.Set("status", string(p.Status))
+	}
+	for name, value := range map[string]int64{"from": p.From, "to": p.To, "cursor": p.Cursor, "limit": p.Limit} {
+		if value != 0 {
+			query.Set(name, strconv.FormatInt(value, 10))
+		}
+	}
+
+	endpoint := fmt.Sprintf("%s/orders/%d/history?%s", url, userId, query.Encode())
 
 	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
 	if err != nil {
@@ -99,22 +187,96 @@ func (c *Client) GetOrders(userId int64) (*server.GetOrdersResponse, error) {
 		return nil, err
 	}
 
-	orders := server.GetOrdersResponse{}
-	if err := json.NewDecoder(resp.Body).Decode(&orders); err != nil {
+	if resp.StatusCode != http.StatusOK {
+		return nil, decodeAPIError(resp)
+	}
+
+	history := &server.OrderHistoryResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(history); err != nil {
 		return nil, err
 	}
-	return &orders, nil
+
+	return history, nil
 }
 
 func (c *Client) PlaceMarketOrder(p *PlaceOrderParams) (*server.PlaceOrderResponse, error) {
 	params := &server.PlaceOrderRequest{
-		UserId: p.UserId,
-		Type:   server.MarketOrder,
-		Bid:    p.Bid,
-		Size:   p.Size,
-		Market: server.MarketETH,
+		UserId:      p.UserId,
+		Type:        server.MarketOrder,
+		Bid:         p.Bid,
+		Size:        p.Size,
+		Market:      p.Market,
+		TimeInForce: p.TimeInForce,
+		MaxSlippage: p.MaxSlippage,
+		WorstPrice:  p.WorstPrice,
+		Group:       p.Group,
+
+		SelfTradePrevention: p.SelfTradePrevention,
+	}
+
+	return c.placeOrder(params)
+}
+
+// PlaceStopOrder places a STOP_LIMIT order when a price is given and a STOP_MARKET otherwise
+func (c *Client) PlaceStopOrder(p *PlaceOrderParams) (*server.PlaceOrderResponse, error) {
+	params := &server.PlaceOrderRequest{
+		UserId:    p.UserId,
+		Type:      server.StopMarketOrder,
+		Bid:       p.Bid,
+		Size:      p.Size,
+		Market:    p.Market,
+		StopPrice: p.StopPrice,
+
+		SelfTradePrevention: p.SelfTradePrevention,
+	}
+
+	if !p.Price.IsZero() {
+		params.Type = server.StopLimitOrder
+		params.Price = p.Price
 	}
 
+	return c.placeOrder(params)
+}
+
+// PlacePeggedOrder places a limit order whose price tracks the top of the book
+func (c *Client) PlacePeggedOrder(p *PlaceOrderParams) (*server.PlaceOrderResponse, error) {
+	params := &server.PlaceOrderRequest{
+		UserId:      p.UserId,
+		Type:        server.PeggedOrder,
+		Bid:         p.Bid,
+		Size:        p.Size,
+		Market:      p.Market,
+		TimeInForce: p.TimeInForce,
+		ExpiresAt:   p.ExpiresAt,
+		Peg:         p.Peg,
+		PegOffset:   p.PegOffset,
+		PegCap:      p.PegCap,
+
+		SelfTradePrevention: p.SelfTradePrevention,
+	}
+
+	return c.placeOrder(params)
+}
+
+// PlaceTrailingStopOrder places a stop market order that trails the last trade price
+// by TrailingAmount or TrailingPercent
+func (c *Client) PlaceTrailingStopOrder(p *PlaceOrderParams) (*server.PlaceOrderResponse, error) {
+	params := &server.PlaceOrderRequest{
+		UserId:          p.UserId,
+		Type:            server.TrailingStopOrder,
+		Bid:             p.Bid,
+		Size:            p.Size,
+		Market:          p.Market,
+		TrailingAmount:  p.TrailingAmount,
+		TrailingPercent: p.TrailingPercent,
+
+		SelfTradePrevention: p.SelfTradePrevention,
+	}
+
+	return c.placeOrder(params)
+}
+
+func (c *Client) placeOrder(params *server.PlaceOrderRequest) (*server.PlaceOrderResponse, error) {
 	body, err := json.Marshal(params)
 	if err != nil {
 		return nil, err
@@ -132,6 +294,10 @@ func (c *Client) PlaceMarketOrder(p *PlaceOrderParams) (*server.PlaceOrderRespon
 		return nil, err
 	}
 
+	if resp.StatusCode != http.StatusOK {
+		return nil, decodeAPIError(resp)
+	}
+
 	placeOrderResponse := &server.PlaceOrderResponse{}
 	if err := json.NewDecoder(resp.Body).Decode(&placeOrderResponse); err != nil {
 		return nil, err
@@ -140,48 +306,95 @@ func (c *Client) PlaceMarketOrder(p *PlaceOrderParams) (*server.PlaceOrderRespon
 	return placeOrderResponse, nil
 }
 
-func (c *Client) GetBestBid() (float64, error) {
-	endpoint := fmt.Sprintf("%s/book/ETH/bid", url)
+func (c *Client) GetBestBid(market server.Market) (decimal.Decimal, error) {
+	endpoint := fmt.Sprintf("%s/book/%s/bid", url, market)
 
 	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
 	if err != nil {
-		return 0, err
+		return decimal.Zero, err
 	}
 
 	response, err := c.Do(req)
 	if err != nil {
-		return 0, err
+		return decimal.Zero, err
 	}
 
 	priceResp := &server.PriceResponse{}
 	if err := json.NewDecoder(response.Body).Decode(priceResp); err != nil {
-		return 0, err
+		return decimal.Zero, err
 	}
 
 	return priceResp.Price, nil
 }
 
-func (c *Client) GetBestAsk() (float64, error) {
-	endpoint := fmt.Sprintf("%s/book/ETH/ask", url)
+func (c *Client) GetBestAsk(market server.Market) (decimal.Decimal, error) {
+	endpoint := fmt.Sprintf("%s/book/%s/ask", url, market)
 
 	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
 	if err != nil {
-		return 0, err
+		return decimal.Zero, err
 	}
 
 	response, err := c.Do(req)
 	if err != nil {
-		return 0, err
+		return decimal.Zero, err
 	}
 
 	priceResp := &server.PriceResponse{}
 	if err := json.NewDecoder(response.Body).Decode(priceResp); err != nil {
-		return 0, err
+		return decimal.Zero, err
 	}
 
 	return priceResp.Price, nil
 }
 
+type AmendOrderParams struct {
+	UserId int64
+	// Market of the order, the exchange finds it from the order id when empty
+	Market server.Market
+	Price  decimal.Decimal
+	// Size is the new remaining size of the order
+	Size decimal.Decimal
+}
+
+// AmendOrder changes the price or size of a resting order keeping its id
+func (c *Client) AmendOrder(orderId int64, p *AmendOrderParams) (*server.AmendOrderResponse, error) {
+	params := &server.AmendOrderRequest{
+		UserId: p.UserId,
+		Market: p.Market,
+		Price:  p.Price,
+		Size:   p.Size,
+	}
+
+	body, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := fmt.Sprintf("%s/order/%d", url, orderId)
+
+	req, err := http.NewRequest(http.MethodPatch, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, decodeAPIError(resp)
+	}
+
+	amendOrderResponse := &server.AmendOrderResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(amendOrderResponse); err != nil {
+		return nil, err
+	}
+
+	return amendOrderResponse, nil
+}
+
 func (c *Client) CancelOrder(orderId int64) error {
 	endpoint := fmt.Sprintf("%s/order/%d", url, orderId)
 
@@ -197,3 +410,98 @@ func (c *Client) CancelOrder(orderId int64) error {
 
 	return nil
 }
+
+// SetSelfTradePrevention sets the default self-trade prevention mode of the orders of userId
+func (c *Client) SetSelfTradePrevention(userId int64, mode orderbook.SelfTradePrevention) error {
+	body, err := json.Marshal(&server.SelfTradePreventionRequest{Mode: mode})
+	if err != nil {
+		return err
+	}
+
+	endpoint := fmt.Sprintf("%s/user/%d/self-trade-prevention", url, userId)
+
+	req, err := http.NewRequest(http.MethodPut, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return decodeAPIError(resp)
+	}
+
+	return nil
+}
+
+// ListMarkets returns all the markets of the exchange, halted ones included
+func (c *Client) ListMarkets() ([]server.MarketResponse, error) {
+	markets := []server.MarketResponse{}
+	if err := c.admin(http.MethodGet, "/admin/markets", nil, &markets); err != nil {
+		return nil, err
+	}
+	return markets, nil
+}
+
+// AddMarket opens a new market trading cfg.Base against cfg.Quote
+func (c *Client) AddMarket(cfg server.MarketConfig) (*server.MarketResponse, error) {
+	market := &server.MarketResponse{}
+	if err := c.admin(http.MethodPost, "/admin/markets", cfg, market); err != nil {
+		return nil, err
+	}
+	return market, nil
+}
+
+// HaltMarket stops accepting new orders and amendments in market, cancels are still accepted
+func (c *Client) HaltMarket(market server.Market) error {
+	return c.admin(http.MethodPost, fmt.Sprintf("/admin/markets/%s/halt", market), nil, nil)
+}
+
+// ResumeMarket lifts the halt of market
+func (c *Client) ResumeMarket(market server.Market) error {
+	return c.admin(http.MethodPost, fmt.Sprintf("/admin/markets/%s/resume", market), nil, nil)
+}
+
+// admin sends a request to the admin API with the admin key,
+// params is encoded as the body when not nil and the response decoded into out when not nil
+func (c *Client) admin(method, path string, params any, out any) error {
+	var body bytes.Buffer
+	if params != nil {
+		if err := json.NewEncoder(&body).Encode(params); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(method, url+path, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Admin-Key", c.AdminKey)
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return decodeAPIError(resp)
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func decodeAPIError(resp *http.Response) error {
+	apiErr := server.APIError{}
+	if err := json.NewDecoder(resp.Body).Decode(&apiErr); err != nil {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	return fmt.Errorf("request failed with status %d: [%s] %s", resp.StatusCode, apiErr.Code, apiErr.Error)
+}
@@ -0,0 +1,403 @@
+// Command replay rebuilds the books of the exchange from its journal to look into a matching incident.
+//
+// Every command is replayed through the engine of its market like on recovery: the clock of the book
+// is the timestamp the command was recorded with and one id generator hands out the ids of all the
+// books. The tool prints the commands with the trades they caused and the book of a market after any
+// command, and reports the commands whose replay gives other trades or events than the recorded ones.
+//
+// Usage:
+//
+//	replay -journal journal -market ETH-USDC -at 42
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/PanGan21/crypto-exchange-poc/engine"
+	"github.com/PanGan21/crypto-exchange-poc/orderbook"
+	"github.com/PanGan21/crypto-exchange-poc/server"
+)
+
+// snapshot is the part of the snapshot of the exchange holding its books
+type snapshot struct {
+	Markets []struct {
+		Config server.MarketConfig
+		Engine engine.State
+	}
+	Books *orderbook.Snapshot
+}
+
+// record is the part of the records of the exchange that adds markets
+type record struct {
+	AddMarket *server.MarketConfig `json:",omitempty"`
+}
+
+type replayer struct {
+	out     io.Writer
+	ids     *orderbook.IDGenerator
+	configs map[string]server.MarketConfig
+	engines map[string]*engine.Engine
+
+	market string // Market to print, all of them when empty
+	at     uint64 // Command of market after which its book is printed and the replay stops
+	quiet  bool   // Print the divergences only
+
+	diverged int
+}
+
+func main() {
+	journalDir := flag.String("journal", "journal", "directory of the journal")
+	marketsConfig := flag.String("markets", "markets.json", "markets configuration of the exchange")
+	fromSnapshot := flag.Bool("snapshot", true, "start from the latest snapshot, otherwise from the first segment of the journal")
+	market := flag.String("market", "", "market to print, all markets when empty")
+	at := flag.Uint64("at", 0, "print the book of -market after this command and stop, 0 prints it at the end of the journal")
+	quiet := flag.Bool("quiet", false, "print the divergences only")
+	flag.Parse()
+
+	if *at > 0 && *market == "" {
+		log.Fatal("-at needs a -market")
+	}
+
+	markets, err := server.LoadMarkets(*marketsConfig)
+	if errors.Is(err, os.ErrNotExist) {
+		markets = server.DefaultMarkets()
+	} else if err != nil {
+		log.Fatal(err)
+	}
+
+	r := newReplayer(os.Stdout, markets)
+	r.market = *market
+	r.at = *at
+	r.quiet = *quiet
+
+	var segment uint64
+	if *fromSnapshot {
+		if segment, err = r.restore(*journalDir); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	entries, err := engine.ReadJournal(*journalDir, segment)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := r.run(entries); err != nil {
+		log.Fatal(err)
+	}
+	if r.diverged > 0 {
+		log.Fatalf("%d commands diverged from the journal", r.diverged)
+	}
+}
+
+// newReplayer starts the markets of the config with empty books
+func newReplayer(out io.Writer, markets []server.MarketConfig) *replayer {
+	r := &replayer{
+		out:     out,
+		ids:     orderbook.NewIDGenerator(),
+		configs: make(map[string]server.MarketConfig),
+		engines: make(map[string]*engine.Engine),
+	}
+
+	for _, cfg := range markets {
+		r.addMarket(cfg)
+	}
+
+	return r
+}
+
+func (r *replayer) addMarket(cfg server.MarketConfig) {
+	name := string(cfg.Name())
+	r.configs[name] = cfg
+	r.engines[name] = engine.New(name, r.engineOptions(cfg)...)
+}
+
+// engineOptions are the options the exchange runs the engine of the market cfg with,
+// without its journal and events
+func (r *replayer) engineOptions(cfg server.MarketConfig) []engine.Option {
+	return []engine.Option{
+		engine.WithBookOptions(
+			orderbook.WithIDGenerator(r.ids),
+			orderbook.WithTickSize(cfg.TickSize),
+		),
+	}
+}
+
+// restore replaces the books with the ones of the latest snapshot of the journal in dir
+// and returns the segment the replay carries on from
+func (r *replayer) restore(dir string) (uint64, error) {
+	var s snapshot
+	segment, ok, err := engine.LoadSnapshot(dir, &s)
+	if err != nil || !ok {
+		return 0, err
+	}
+
+	for _, ms := range s.Markets {
+		name := string(ms.Config.Name())
+		// the markets of the config keep their config
+		cfg, ok := r.configs[name]
+		if !ok {
+			cfg = ms.Config
+		}
+
+		e, err := engine.Restore(name, s.Books, ms.Engine, r.engineOptions(cfg)...)
+		if err != nil {
+			return 0, fmt.Errorf("market %s: %w", name, err)
+		}
+		if old, ok := r.engines[name]; ok {
+			old.Stop()
+		}
+		r.configs[name] = cfg
+		r.engines[name] = e
+
+		if name == r.market && r.at > 0 && r.at <= ms.Engine.Sequence {
+			return 0, fmt.Errorf("command %d of %s is covered by the snapshot of segment %d, replay with -snapshot=false", r.at, name, segment)
+		}
+	}
+
+	if !r.quiet {
+		fmt.Fprintf(r.out, "restored %d markets from the snapshot of segment %d\n", len(s.Markets), segment)
+	}
+
+	return segment, nil
+}
+
+// run replays entries in order, it stops after the command of -at
+func (r *replayer) run(entries []*engine.Entry) error {
+	for i, entry := range entries {
+		if entry.Command == nil {
+			if err := r.record(entry); err != nil {
+				return fmt.Errorf("journal entry %d: %w", i, err)
+			}
+			continue
+		}
+
+		e, ok := r.engines[entry.Market]
+		if !ok {
+			return fmt.Errorf("journal entry %d: market %s not found", i, entry.Market)
+		}
+
+		if err := r.replay(e, entry); err != nil {
+			return fmt.Errorf("journal entry %d: %w", i, err)
+		}
+
+		if entry.Market == r.market && entry.Command.Sequence == r.at {
+			r.printBook(e, entry.Command)
+			return nil
+		}
+	}
+
+	if r.at > 0 {
+		return fmt.Errorf("the journal ends before command %d of %s", r.at, r.market)
+	}
+	if e, ok := r.engines[r.market]; ok {
+		r.printBook(e, nil)
+	}
+
+	return nil
+}
+
+// record adds the markets added through the API
+func (r *replayer) record(entry *engine.Entry) error {
+	var rec record
+	if err := json.Unmarshal(entry.Data, &rec); err != nil {
+		return err
+	}
+
+	// markets added to the config since are already there
+	if _, ok := r.engines[entry.Market]; rec.AddMarket != nil && !ok {
+		r.addMarket(*rec.AddMarket)
+	}
+
+	return nil
+}
+
+// replay applies the command of entry and compares the trades and events it causes with the recorded ones
+func (r *replayer) replay(e *engine.Engine, entry *engine.Entry) error {
+	cmd := entry.Command
+
+	var state engine.State
+	if err := e.Query(func(*orderbook.Orderbook) { state = e.State() }); err != nil {
+		return err
+	}
+	if cmd.Sequence != state.Sequence+1 {
+		return fmt.Errorf("%s command %d follows %d, the journal is missing commands", e.Market(), cmd.Sequence, state.Sequence)
+	}
+
+	// the orders are placed by the replay, describe them as they were recorded
+	line := describeCommand(cmd)
+
+	res, err := e.Replay(entry)
+	if err != nil && !errors.Is(err, engine.ErrReplayDiverged) {
+		return err
+	}
+
+	replayed := e.Book().TradesSince(state.LastTrade)
+	recorded := recordedTrades(entry.Events)
+
+	if r.printed(e.Market()) {
+		if res.Err != nil {
+			line += " => rejected: " + res.Err.Error()
+		} else if cmd.Type == engine.CommandPlace {
+			line += " => placed" + placedIds(cmd)
+		}
+		fmt.Fprintf(r.out, "%s #%d %s %s\n", e.Market(), cmd.Sequence, formatTime(cmd.Timestamp), line)
+		for _, t := range replayed {
+			fmt.Fprintf(r.out, "\t%s\n", describeTrade(t))
+		}
+	}
+
+	if err == nil && sameTrades(replayed, recorded) {
+		return nil
+	}
+
+	r.diverged++
+	reason := "other trades"
+	if err != nil {
+		reason = err.Error()
+	}
+	fmt.Fprintf(r.out, "DIVERGED %s command %d: %s\n", e.Market(), cmd.Sequence, reason)
+	for _, t := range recorded {
+		fmt.Fprintf(r.out, "\trecorded %s\n", describeTrade(t))
+	}
+	for _, t := range replayed {
+		fmt.Fprintf(r.out, "\treplayed %s\n", describeTrade(t))
+	}
+
+	return nil
+}
+
+func (r *replayer) printed(market string) bool {
+	return !r.quiet && (r.market == "" || r.market == market)
+}
+
+// printBook prints the levels and the pending stops of the book of e, after cmd when it is not nil
+func (r *replayer) printBook(e *engine.Engine, cmd *engine.Command) {
+	ob := e.Book()
+
+	if cmd != nil {
+		fmt.Fprintf(r.out, "\n%s book after command %d at %s\n", e.Market(), cmd.Sequence, formatTime(cmd.Timestamp))
+	} else {
+		fmt.Fprintf(r.out, "\n%s book at the end of the journal\n", e.Market())
+	}
+
+	// asks from the highest price so the best prices meet in the middle
+	asks := ob.Asks()
+	fmt.Fprintln(r.out, "asks")
+	for i := len(asks) - 1; i >= 0; i-- {
+		r.printLevel(asks[i])
+	}
+	fmt.Fprintln(r.out, "bids")
+	for _, level := range ob.Bids() {
+		r.printLevel(level)
+	}
+
+	for _, bid := range []bool{true, false} {
+		fmt.Fprintf(r.out, "%s stops\n", side(bid))
+		for _, o := range ob.StopOrders(bid) {
+			fmt.Fprintf(r.out, "\t%s\n", describeOrder(o))
+		}
+	}
+}
+
+func (r *replayer) printLevel(level *orderbook.Level) {
+	fmt.Fprintf(r.out, "\t%s\t%s\n", level.Price, level.TotalVolume)
+	for _, o := range level.Orders {
+		line := describeOrder(o)
+		if o.Reserve.IsPositive() {
+			line += fmt.Sprintf(" (+%s hidden)", o.Reserve)
+		}
+		fmt.Fprintf(r.out, "\t\t%s\n", line)
+	}
+}
+
+func recordedTrades(events []engine.Event) []*orderbook.Trade {
+	trades := []*orderbook.Trade{}
+	for _, event := range events {
+		if event.Type == engine.EventTrade && event.Trade != nil {
+			trades = append(trades, event.Trade)
+		}
+	}
+	return trades
+}
+
+func sameTrades(a, b []*orderbook.Trade) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if *a[i] != *b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// placedIds lists the ids given to the orders of a place command, the exits of a bracket get theirs once it fills
+func placedIds(cmd *engine.Command) string {
+	ids := ""
+	for _, o := range []*orderbook.Order{cmd.Order, cmd.TakeProfit, cmd.StopLoss} {
+		if o != nil && o.Id != 0 {
+			ids += fmt.Sprintf(" order %d", o.Id)
+		}
+	}
+	return ids
+}
+
+func describeCommand(cmd *engine.Command) string {
+	switch cmd.Type {
+	case engine.CommandPlace:
+		line := fmt.Sprintf("PLACE %s %s", cmd.Kind, describeOrder(cmd.Order))
+		if cmd.TakeProfit != nil {
+			line += " | take profit " + describeOrder(cmd.TakeProfit)
+		}
+		if cmd.StopLoss != nil {
+			line += " | stop loss " + describeOrder(cmd.StopLoss)
+		}
+		return line
+	case engine.CommandCancel:
+		return fmt.Sprintf("CANCEL order %d", cmd.OrderId)
+	case engine.CommandAmend:
+		return fmt.Sprintf("AMEND order %d of user %d to %s @ %s", cmd.OrderId, cmd.UserId, cmd.Size, cmd.Price)
+	default:
+		return string(cmd.Type)
+	}
+}
+
+// describeOrder leaves out the id of orders that are not placed yet
+func describeOrder(o *orderbook.Order) string {
+	line := fmt.Sprintf("user %d %s %s", o.UserId, side(o.Bid), o.Size)
+	if o.Id != 0 {
+		line = fmt.Sprintf("order %d %s", o.Id, line)
+	}
+	if !o.Price.IsZero() {
+		line += " @ " + o.Price.String()
+	}
+	if !o.StopPrice.IsZero() && !o.Triggered {
+		line += " stop " + o.StopPrice.String()
+	}
+	return line
+}
+
+func describeTrade(t *orderbook.Trade) string {
+	return fmt.Sprintf("trade %d #%d %s %s @ %s | taker order %d user %d | maker order %d user %d",
+		t.Id, t.Sequence, side(t.Bid), t.Size, t.Price, t.TakerOrderId, t.TakerUserId, t.MakerOrderId, t.MakerUserId)
+}
+
+func side(bid bool) string {
+	if bid {
+		return "BUY"
+	}
+	return "SELL"
+}
+
+func formatTime(ts int64) string {
+	return time.Unix(0, ts).UTC().Format(time.RFC3339Nano)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/PanGan21/crypto-exchange-poc/decimal"
+	"github.com/PanGan21/crypto-exchange-poc/engine"
+	"github.com/PanGan21/crypto-exchange-poc/orderbook"
+	"github.com/PanGan21/crypto-exchange-poc/server"
+)
+
+func assert(t *testing.T, a, b any) {
+	if !reflect.DeepEqual(a, b) {
+		t.Errorf("%+v != %+v", a, b)
+	}
+}
+
+func limitOrder(bid bool, price, size int64, userId int64) *engine.Command {
+	o := orderbook.NewOrder(bid, decimal.NewFromInt(size), userId)
+	o.Price = decimal.NewFromInt(price)
+	return &engine.Command{Type: engine.CommandPlace, Kind: engine.KindLimit, Order: o}
+}
+
+// recordJournal runs commands alternating between the default markets, which share their ids, and returns the journal
+func recordJournal(t *testing.T) []*engine.Entry {
+	dir := t.TempDir()
+	journal, _, err := engine.OpenJournal(dir, engine.SyncNever, 0)
+	assert(t, err, nil)
+
+	ids := orderbook.NewIDGenerator()
+	engines := []*engine.Engine{}
+	for _, cfg := range server.DefaultMarkets() {
+		engines = append(engines, engine.New(string(cfg.Name()),
+			engine.WithJournal(journal),
+			engine.WithBookOptions(orderbook.WithIDGenerator(ids), orderbook.WithTickSize(cfg.TickSize)),
+		))
+	}
+
+	for i := int64(0); i < 5; i++ {
+		for _, e := range engines {
+			assert(t, e.Submit(limitOrder(false, 100+i, 2, 1)).Err, nil)
+			assert(t, e.Submit(limitOrder(true, 100+i, 1, 2)).Err, nil)
+		}
+	}
+	for _, e := range engines {
+		e.Stop()
+	}
+	assert(t, journal.Close(), nil)
+
+	entries, err := engine.ReadJournal(dir, 0)
+	assert(t, err, nil)
+	return entries
+}
+
+func TestReplay(t *testing.T) {
+	entries := recordJournal(t)
+
+	var out bytes.Buffer
+	r := newReplayer(&out, server.DefaultMarkets())
+	r.market = "WBTC-ETH"
+	r.at = 4
+
+	assert(t, r.run(entries), nil)
+	assert(t, r.diverged, 0)
+	// the orders of the other market took the ids in between
+	assert(t, strings.Contains(out.String(), "trade 4 #2 BUY 1 @ 100 | taker order 8 user 2 | maker order 3 user 1"), true)
+	assert(t, strings.Contains(out.String(), "ETH-USDC"), false)
+	assert(t, strings.Contains(out.String(), "WBTC-ETH book after command 4"), true)
+	assert(t, strings.Contains(out.String(), "\t\torder 7 user 1 SELL 2 @ 101"), true)
+	assert(t, strings.Contains(out.String(), "command 5"), false)
+}
+
+func TestReplayDiverged(t *testing.T) {
+	entries := recordJournal(t)
+
+	// the recorded fill of the third trade of ETH-USDC is off
+	for _, entry := range entries {
+		for _, event := range entry.Events {
+			if event.Market == "ETH-USDC" && event.Type == engine.EventTrade && event.Trade.Sequence == 3 {
+				event.Trade.Price = decimal.NewFromInt(99)
+			}
+		}
+	}
+
+	var out bytes.Buffer
+	r := newReplayer(&out, server.DefaultMarkets())
+	r.quiet = true
+
+	assert(t, r.run(entries), nil)
+	assert(t, r.diverged, 1)
+	assert(t, strings.HasPrefix(out.String(), "DIVERGED ETH-USDC command 6: "), true)
+	assert(t, strings.Contains(out.String(), "\trecorded trade 5 #3 BUY 1 @ 99 "), true)
+	assert(t, strings.Contains(out.String(), "\treplayed trade 5 #3 BUY 1 @ 101 "), true)
+
+	// a command missing from the journal stops the replay
+	r = newReplayer(&out, server.DefaultMarkets())
+	assert(t, r.run(append(entries[:1], entries[2:]...)) == nil, false)
+}
@@ -0,0 +1,431 @@
+package decimal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// MaxScale is the maximum number of decimal places a Decimal can hold
+const MaxScale = 18
+
+var (
+	ErrInvalidDecimal = errors.New("invalid decimal")
+	ErrOverflow       = errors.New("decimal overflow")
+	ErrDivisionByZero = errors.New("decimal division by zero")
+)
+
+var pow10 = func() [MaxScale + 1]int64 {
+	var p [MaxScale + 1]int64
+	p[0] = 1
+	for i := 1; i <= MaxScale; i++ {
+		p[i] = p[i-1] * 10
+	}
+	return p
+}()
+
+var Zero = Decimal{}
+
+// Decimal is a fixed-point number stored as an integer amount of units
+// of 10^-scale. Values are always kept in canonical form (no trailing
+// zeros in units) so equal numbers are equal with == and can be used
+// as map keys.
+type Decimal struct {
+	units int64
+	scale int32
+}
+
+// New returns units * 10^-scale
+func New(units int64, scale int32) Decimal {
+	if scale < 0 || scale > MaxScale {
+		panic(fmt.Errorf("%w: scale %d out of range", ErrInvalidDecimal, scale))
+	}
+	return Decimal{units: units, scale: scale}.normalize()
+}
+
+func NewFromInt(i int64) Decimal {
+	return Decimal{units: i}
+}
+
+// Parse reads a decimal from its string representation, e.g. "-12.5"
+func Parse(s string) (Decimal, error) {
+	str := s
+	neg := false
+	if strings.HasPrefix(str, "-") || strings.HasPrefix(str, "+") {
+		neg = str[0] == '-'
+		str = str[1:]
+	}
+
+	intPart, fracPart, hasPoint := strings.Cut(str, ".")
+	if intPart == "" && fracPart == "" || hasPoint && fracPart == "" {
+		return Zero, fmt.Errorf("%w: %q", ErrInvalidDecimal, s)
+	}
+	if len(fracPart) > MaxScale {
+		return Zero, fmt.Errorf("%w: %q has more than %d decimals", ErrInvalidDecimal, s, MaxScale)
+	}
+
+	digits := intPart + fracPart
+	for _, r := range digits {
+		if r < '0' || r > '9' {
+			return Zero, fmt.Errorf("%w: %q", ErrInvalidDecimal, s)
+		}
+	}
+
+	units, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil {
+		return Zero, fmt.Errorf("%w: %q", ErrOverflow, s)
+	}
+	if neg {
+		units = -units
+	}
+
+	return Decimal{units: units, scale: int32(len(fracPart))}.normalize(), nil
+}
+
+// RequireFromString is like Parse but panics on malformed input
+func RequireFromString(s string) Decimal {
+	d, err := Parse(s)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+func (d Decimal) normalize() Decimal {
+	if d.units == 0 {
+		return Zero
+	}
+	for d.scale > 0 && d.units%10 == 0 {
+		d.units /= 10
+		d.scale--
+	}
+	return d
+}
+
+// rescale returns the units of d expressed in the given (larger) scale, ok is false when they don't fit
+func (d Decimal) rescale(scale int32) (int64, bool) {
+	return mul64(d.units, pow10[scale-d.scale])
+}
+
+// bigUnits returns the units of d expressed in the given (larger) scale
+func (d Decimal) bigUnits(scale int32) *big.Int {
+	return new(big.Int).Mul(big.NewInt(d.units), bigPow10(scale-d.scale))
+}
+
+func bigPow10(exp int32) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(exp)), nil)
+}
+
+func align(a, b Decimal) (int64, int64, int32, bool) {
+	if a.scale > b.scale {
+		units, ok := b.rescale(a.scale)
+		return a.units, units, a.scale, ok
+	}
+	units, ok := a.rescale(b.scale)
+	return units, b.units, b.scale, ok
+}
+
+func mul64(a, b int64) (int64, bool) {
+	if a == 0 || b == 0 {
+		return 0, true
+	}
+	c := a * b
+	if c/b != a || (a == -1 && b == math.MinInt64) || (b == -1 && a == math.MinInt64) {
+		return 0, false
+	}
+	return c, true
+}
+
+// must panics with err, the arithmetic of values coming from requests should use the checked variants
+func must(d Decimal, err error) Decimal {
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+// Add returns d + d2, it panics on overflow
+func (d Decimal) Add(d2 Decimal) Decimal {
+	return must(d.CheckedAdd(d2))
+}
+
+// CheckedAdd returns d + d2 or ErrOverflow when the sum does not fit
+func (d Decimal) CheckedAdd(d2 Decimal) (Decimal, error) {
+	a, b, scale, ok := align(d, d2)
+	if !ok {
+		return Zero, ErrOverflow
+	}
+	c := a + b
+	if (c > a) != (b > 0) {
+		return Zero, ErrOverflow
+	}
+	return Decimal{units: c, scale: scale}.normalize(), nil
+}
+
+// Sub returns d - d2, it panics on overflow
+func (d Decimal) Sub(d2 Decimal) Decimal {
+	return must(d.CheckedSub(d2))
+}
+
+// CheckedSub returns d - d2 or ErrOverflow when the difference does not fit
+func (d Decimal) CheckedSub(d2 Decimal) (Decimal, error) {
+	if d2.units == math.MinInt64 {
+		return Zero, ErrOverflow
+	}
+	return d.CheckedAdd(d2.Neg())
+}
+
+func (d Decimal) Neg() Decimal {
+	return Decimal{units: -d.units, scale: d.scale}
+}
+
+func (d Decimal) Abs() Decimal {
+	if d.units < 0 {
+		return d.Neg()
+	}
+	return d
+}
+
+// Mul returns d * d2 truncated to MaxScale decimals, it panics on overflow
+func (d Decimal) Mul(d2 Decimal) Decimal {
+	return must(d.CheckedMul(d2))
+}
+
+// CheckedMul returns d * d2 truncated to MaxScale decimals or ErrOverflow when the product does not fit
+func (d Decimal) CheckedMul(d2 Decimal) (Decimal, error) {
+	units := new(big.Int).Mul(big.NewInt(d.units), big.NewInt(d2.units))
+	return fromBig(units, d.scale+d2.scale)
+}
+
+// CheckedMulTruncate returns d * d2 truncated to the given number of decimals, ErrOverflow when
+// even the truncated product does not fit or ErrInvalidDecimal for a scale out of range
+func (d Decimal) CheckedMulTruncate(d2 Decimal, scale int32) (Decimal, error) {
+	if scale < 0 || scale > MaxScale {
+		return Zero, fmt.Errorf("%w: scale %d out of range", ErrInvalidDecimal, scale)
+	}
+	units := new(big.Int).Mul(big.NewInt(d.units), big.NewInt(d2.units))
+	if exp := d.scale + d2.scale - scale; exp > 0 {
+		units.Quo(units, bigPow10(exp))
+		return fromBig(units, scale)
+	}
+	return fromBig(units, d.scale+d2.scale)
+}
+
+// Div returns d / d2 truncated to the given number of decimals,
+// it panics on overflow, on division by zero and on a scale above MaxScale
+func (d Decimal) Div(d2 Decimal, scale int32) Decimal {
+	return must(d.CheckedDiv(d2, scale))
+}
+
+// CheckedDiv returns d / d2 truncated to the given number of decimals, ErrOverflow when the quotient
+// does not fit, ErrDivisionByZero or ErrInvalidDecimal for a scale out of range
+func (d Decimal) CheckedDiv(d2 Decimal, scale int32) (Decimal, error) {
+	if d2.units == 0 {
+		return Zero, ErrDivisionByZero
+	}
+	if scale < 0 || scale > MaxScale {
+		return Zero, fmt.Errorf("%w: scale %d out of range", ErrInvalidDecimal, scale)
+	}
+	// d / d2 = (d.units * 10^(scale + d2.scale - d.scale)) / d2.units * 10^-scale
+	num := big.NewInt(d.units)
+	exp := scale + d2.scale - d.scale
+	if exp >= 0 {
+		num.Mul(num, bigPow10(exp))
+	} else {
+		num.Quo(num, bigPow10(-exp))
+	}
+	num.Quo(num, big.NewInt(d2.units))
+	return fromBig(num, scale)
+}
+
+// Mod returns the remainder of d / d2, it has the sign of d. It panics on division by zero.
+func (d Decimal) Mod(d2 Decimal) Decimal {
+	if d2.units == 0 {
+		panic(ErrDivisionByZero)
+	}
+	scale := d.scale
+	if d2.scale > scale {
+		scale = d2.scale
+	}
+	a, b := d.bigUnits(scale), d2.bigUnits(scale)
+	// the remainder is smaller than the operand that was not rescaled, it always fits
+	return must(fromBig(a.Rem(a, b), scale))
+}
+
+// fromBig truncates units * 10^-scale to MaxScale decimals
+func fromBig(units *big.Int, scale int32) (Decimal, error) {
+	if scale > MaxScale {
+		units.Quo(units, bigPow10(scale-MaxScale))
+		scale = MaxScale
+	}
+	if !units.IsInt64() {
+		return Zero, ErrOverflow
+	}
+	return Decimal{units: units.Int64(), scale: scale}.normalize(), nil
+}
+
+// Truncate drops the decimals beyond the given number of places
+func (d Decimal) Truncate(places int32) Decimal {
+	if places < 0 || d.scale <= places {
+		return d
+	}
+	return Decimal{units: d.units / pow10[d.scale-places], scale: places}.normalize()
+}
+
+func (d Decimal) Cmp(d2 Decimal) int {
+	a, b, scale, ok := align(d, d2)
+	if !ok {
+		// values far apart in magnitude and scale are compared without rounding
+		return d.bigUnits(scale).Cmp(d2.bigUnits(scale))
+	}
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func (d Decimal) Equal(d2 Decimal) bool {
+	return d == d2
+}
+
+func (d Decimal) LessThan(d2 Decimal) bool {
+	return d.Cmp(d2) < 0
+}
+
+func (d Decimal) LessThanOrEqual(d2 Decimal) bool {
+	return d.Cmp(d2) <= 0
+}
+
+func (d Decimal) GreaterThan(d2 Decimal) bool {
+	return d.Cmp(d2) > 0
+}
+
+func (d Decimal) GreaterThanOrEqual(d2 Decimal) bool {
+	return d.Cmp(d2) >= 0
+}
+
+func (d Decimal) Sign() int {
+	switch {
+	case d.units < 0:
+		return -1
+	case d.units > 0:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func (d Decimal) IsZero() bool {
+	return d.units == 0
+}
+
+func (d Decimal) IsPositive() bool {
+	return d.units > 0
+}
+
+func (d Decimal) IsNegative() bool {
+	return d.units < 0
+}
+
+// Scale is the number of decimal places needed to represent d exactly
+func (d Decimal) Scale() int32 {
+	return d.scale
+}
+
+// IntPart returns the integer part of d, truncated towards zero
+func (d Decimal) IntPart() int64 {
+	return d.units / pow10[d.scale]
+}
+
+// Float64 is meant for display purposes only, it may lose precision
+func (d Decimal) Float64() float64 {
+	f, _ := strconv.ParseFloat(d.String(), 64)
+	return f
+}
+
+func Min(a, b Decimal) Decimal {
+	if a.LessThan(b) {
+		return a
+	}
+	return b
+}
+
+func Max(a, b Decimal) Decimal {
+	if a.GreaterThan(b) {
+		return a
+	}
+	return b
+}
+
+func (d Decimal) String() string {
+	return d.StringFixed(d.scale)
+}
+
+// StringFixed formats d with exactly the given number of decimals,
+// truncating any extra precision
+func (d Decimal) StringFixed(places int32) string {
+	if places < 0 {
+		places = 0
+	}
+	if places > MaxScale {
+		places = MaxScale
+	}
+
+	t := d.Truncate(places)
+	units := t.units
+	neg := units < 0
+	if neg {
+		units = -units
+	}
+
+	digits := strconv.FormatUint(uint64(units), 10)
+	digits += strings.Repeat("0", int(places-t.scale))
+	if len(digits) <= int(places) {
+		digits = strings.Repeat("0", int(places)-len(digits)+1) + digits
+	}
+
+	s := digits
+	if places > 0 {
+		s = digits[:len(digits)-int(places)] + "." + digits[len(digits)-int(places):]
+	}
+	if neg {
+		s = "-" + s
+	}
+	return s
+}
+
+// MarshalJSON encodes d as a JSON string so no precision is lost on the wire
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(d.String())), nil
+}
+
+// UnmarshalJSON accepts plain decimal literals, quoted or bare, but no exponents such as 1e3
+func (d *Decimal) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+
+	str := string(data)
+	if len(str) >= 2 && str[0] == '"' {
+		unquoted, err := strconv.Unquote(str)
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidDecimal, str)
+		}
+		str = unquoted
+	}
+
+	parsed, err := Parse(str)
+	if err != nil {
+		return err
+	}
+	*d = parsed
+	return nil
+}
@@ -0,0 +1,169 @@
+package decimal
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func assert(t *testing.T, a, b any) {
+	if !reflect.DeepEqual(a, b) {
+		t.Errorf("%+v != %+v", a, b)
+	}
+}
+
+func TestParseAndString(t *testing.T) {
+	cases := map[string]string{
+		"0":           "0",
+		"10000":       "10000",
+		"10000.00":    "10000",
+		"0.1":         "0.1",
+		"-0.001":      "-0.001",
+		".5":          "0.5",
+		"+3.140":      "3.14",
+		"0.000000001": "0.000000001",
+	}
+
+	for in, out := range cases {
+		d, err := Parse(in)
+		if err != nil {
+			t.Fatalf("parse %q: %v", in, err)
+		}
+		assert(t, d.String(), out)
+	}
+
+	for _, in := range []string{"", "-", "1.", "abc", "1.2.3", "1e5", "99999999999999999999"} {
+		if _, err := Parse(in); err == nil {
+			t.Errorf("expected error parsing %q", in)
+		}
+	}
+}
+
+func TestCanonicalMapKeys(t *testing.T) {
+	a := RequireFromString("0.1").Add(RequireFromString("0.2"))
+	b := RequireFromString("0.30")
+
+	m := map[Decimal]bool{a: true}
+	assert(t, m[b], true)
+	assert(t, a, b)
+}
+
+func TestArithmetic(t *testing.T) {
+	a := RequireFromString("1.5")
+	b := RequireFromString("0.25")
+
+	assert(t, a.Add(b).String(), "1.75")
+	assert(t, a.Sub(b).String(), "1.25")
+	assert(t, b.Sub(a).String(), "-1.25")
+	assert(t, a.Mul(b).String(), "0.375")
+	assert(t, a.Div(b, 4).String(), "6")
+	assert(t, NewFromInt(10).Div(NewFromInt(3), 4).String(), "3.3333")
+	assert(t, RequireFromString("10.15").Mod(RequireFromString("0.05")).IsZero(), true)
+	assert(t, RequireFromString("10.17").Mod(RequireFromString("0.05")).String(), "0.02")
+	assert(t, RequireFromString("-7").Mod(NewFromInt(3)).String(), "-1")
+	assert(t, New(9_000_000_000_000_000_000, 0).Mod(New(1, 8)).IsZero(), true)
+	assert(t, a.Cmp(b), 1)
+	assert(t, b.Cmp(a), -1)
+	assert(t, a.Sub(a).IsZero(), true)
+	assert(t, Min(a, b), b)
+	assert(t, RequireFromString("12.345").IntPart(), int64(12))
+	assert(t, RequireFromString("12.345").Truncate(1).String(), "12.3")
+	assert(t, RequireFromString("12.3").StringFixed(3), "12.300")
+	assert(t, RequireFromString("-0.05").StringFixed(2), "-0.05")
+}
+
+func TestJSON(t *testing.T) {
+	type payload struct {
+		Price Decimal
+		Size  Decimal
+	}
+
+	data, err := json.Marshal(payload{Price: RequireFromString("10000.5"), Size: New(1, 8)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert(t, string(data), `{"Price":"10000.5","Size":"0.00000001"}`)
+
+	var p payload
+	if err := json.Unmarshal([]byte(`{"Price":"0.1","Size":2.50}`), &p); err != nil {
+		t.Fatal(err)
+	}
+	assert(t, p.Price, New(1, 1))
+	assert(t, p.Size, RequireFromString("2.5"))
+}
+
+func TestCheckedArithmetic(t *testing.T) {
+	max := New(math.MaxInt64, 0)
+	min := New(math.MinInt64, 0)
+	tiny := New(1, MaxScale)
+
+	sum, err := max.Sub(NewFromInt(1)).CheckedAdd(NewFromInt(1))
+	assert(t, sum, max)
+	assert(t, err, nil)
+	_, err = max.CheckedAdd(NewFromInt(1))
+	assert(t, err, ErrOverflow)
+	_, err = min.CheckedSub(NewFromInt(1))
+	assert(t, err, ErrOverflow)
+	_, err = NewFromInt(1).CheckedSub(min)
+	assert(t, err, ErrOverflow)
+	// the sum needs more digits than the units hold
+	_, err = New(1_000_000_000, 0).CheckedAdd(tiny)
+	assert(t, err, ErrOverflow)
+
+	// 999999.99999999 * 3000.01 needs 10 decimals and 21 digits
+	_, err = RequireFromString("999999.99999999").CheckedMul(RequireFromString("3000.01"))
+	assert(t, err, ErrOverflow)
+	product, err := RequireFromString("99999.99999999").CheckedMul(RequireFromString("3000.01"))
+	assert(t, product.String(), "300000999.9999699999")
+	assert(t, err, nil)
+	// decimals beyond MaxScale are truncated
+	product, err = tiny.CheckedMul(RequireFromString("0.5"))
+	assert(t, product, Zero)
+	assert(t, err, nil)
+	product, err = RequireFromString("0.123456789").CheckedMul(RequireFromString("0.123456789123"))
+	assert(t, product.String(), "0.015241578765375706")
+	assert(t, err, nil)
+	// the product of 99999999.9999999999 and 0.0025 needs 14 decimals
+	big := RequireFromString("99999999.9999999999")
+	_, err = big.CheckedMul(RequireFromString("0.0025"))
+	assert(t, err, ErrOverflow)
+	product, err = big.CheckedMulTruncate(RequireFromString("0.0025"), 8)
+	assert(t, product.String(), "249999.99999999")
+	assert(t, err, nil)
+	product, err = RequireFromString("1.5").CheckedMulTruncate(RequireFromString("1.5"), 8)
+	assert(t, product.String(), "2.25")
+	assert(t, err, nil)
+	_, err = max.CheckedMulTruncate(NewFromInt(2), 0)
+	assert(t, err, ErrOverflow)
+	_, err = big.CheckedMulTruncate(big, MaxScale+1)
+	assert(t, errors.Is(err, ErrInvalidDecimal), true)
+
+	_, err = max.CheckedDiv(RequireFromString("0.5"), 0)
+	assert(t, err, ErrOverflow)
+	_, err = NewFromInt(1).CheckedDiv(Zero, 2)
+	assert(t, err, ErrDivisionByZero)
+	_, err = NewFromInt(1).CheckedDiv(NewFromInt(3), MaxScale+1)
+	assert(t, errors.Is(err, ErrInvalidDecimal), true)
+	quotient, err := NewFromInt(1).CheckedDiv(NewFromInt(3), MaxScale)
+	assert(t, quotient.String(), "0.333333333333333333")
+	assert(t, err, nil)
+
+	// the panicking variants panic with the same errors
+	defer func() {
+		assert(t, recover(), ErrOverflow)
+	}()
+	max.Mul(NewFromInt(2))
+	t.Error("no panic")
+}
+
+func TestCmpFarApart(t *testing.T) {
+	max := New(math.MaxInt64, 0)
+	tiny := New(1, MaxScale)
+
+	assert(t, max.Cmp(tiny), 1)
+	assert(t, tiny.Cmp(max), -1)
+	assert(t, New(math.MinInt64, 0).Cmp(tiny.Neg()), -1)
+	assert(t, max.GreaterThan(New(math.MaxInt64, MaxScale)), true)
+}
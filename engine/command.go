@@ -0,0 +1,122 @@
+package engine
+
+import (
+	"errors"
+
+	"github.com/PanGan21/crypto-exchange-poc/decimal"
+	"github.com/PanGan21/crypto-exchange-poc/orderbook"
+)
+
+var (
+	ErrStopped        = errors.New("engine stopped")
+	ErrInvalidCommand = errors.New("invalid command")
+)
+
+type CommandType string
+
+const (
+	CommandPlace  CommandType = "PLACE"
+	CommandCancel CommandType = "CANCEL"
+	CommandAmend  CommandType = "AMEND"
+	// CommandExpire expires the GTD orders of the book at the command timestamp
+	CommandExpire CommandType = "EXPIRE"
+	// CommandQuery reads the book between two commands, it changes nothing and takes no sequence
+	CommandQuery CommandType = "QUERY"
+)
+
+// OrderKind is the way a placed order enters the book
+type OrderKind string
+
+const (
+	KindLimit  OrderKind = "LIMIT"
+	KindMarket OrderKind = "MARKET"
+	// KindStop places a stop market, stop limit or trailing stop order
+	KindStop   OrderKind = "STOP"
+	KindPegged OrderKind = "PEGGED"
+	// KindOCO places Order as the take profit of an OCO group with StopLoss
+	KindOCO OrderKind = "OCO"
+	// KindBracket places Order as the entry of a bracket with TakeProfit and StopLoss
+	KindBracket OrderKind = "BRACKET"
+)
+
+// Command is a request to the engine of a market.
+// Everything but queries is plain data so commands can be stored and replayed.
+type Command struct {
+	Sequence  uint64 // Assigned by the engine, commands are applied in sequence order
+	Timestamp int64  // Unix nanoseconds, assigned by the engine with the sequence
+	Type      CommandType
+	Kind      OrderKind        `json:",omitempty"`
+	Order     *orderbook.Order `json:",omitempty"` // Order to place, limit orders are placed at its price
+	// TakeProfit and StopLoss are the exits of OCO and bracket groups
+	TakeProfit *orderbook.Order `json:",omitempty"`
+	StopLoss   *orderbook.Order `json:",omitempty"`
+	// OrderId is the order to cancel or amend, UserId must own an amended order
+	OrderId int64           `json:",omitempty"`
+	UserId  int64           `json:",omitempty"`
+	Price   decimal.Decimal // New price of an amended order
+	Size    decimal.Decimal // New remaining size of an amended order
+	// Query runs on the engine goroutine with the book of the market
+	Query func(ob *orderbook.Orderbook) `json:"-"`
+}
+
+// Result is the outcome of a command
+type Result struct {
+	Sequence  uint64
+	Matches   []orderbook.Match
+	Group     *orderbook.OrderGroup // Placed OCO or bracket group
+	Amendment *orderbook.Amendment
+	Expired   []*orderbook.Order
+	// Cancelled are the orders the book cancelled or expired while applying the command,
+	// including the remainder of IOC orders and self-trade prevention
+	Cancelled []*orderbook.Order
+	Err       error
+}
+
+// orders returns the orders placed by a place command
+func (cmd *Command) orders() []*orderbook.Order {
+	orders := []*orderbook.Order{}
+	for _, o := range []*orderbook.Order{cmd.Order, cmd.TakeProfit, cmd.StopLoss} {
+		if o != nil {
+			orders = append(orders, o)
+		}
+	}
+	return orders
+}
+
+func (cmd *Command) valid() bool {
+	switch cmd.Type {
+	case CommandPlace:
+		switch cmd.Kind {
+		case KindLimit, KindMarket, KindStop, KindPegged:
+			return cmd.Order != nil
+		case KindOCO:
+			return cmd.Order != nil && cmd.StopLoss != nil
+		case KindBracket:
+			return cmd.Order != nil && cmd.TakeProfit != nil && cmd.StopLoss != nil
+		}
+		return false
+	case CommandCancel, CommandAmend, CommandExpire:
+		return true
+	case CommandQuery:
+		return cmd.Query != nil
+	default:
+		return false
+	}
+}
+
+// clone copies cmd and the orders it places before the book changes them
+func (cmd *Command) clone() *Command {
+	c := *cmd
+	c.Order = cloneOrder(cmd.Order)
+	c.TakeProfit = cloneOrder(cmd.TakeProfit)
+	c.StopLoss = cloneOrder(cmd.StopLoss)
+	return &c
+}
+
+func cloneOrder(o *orderbook.Order) *orderbook.Order {
+	if o == nil {
+		return nil
+	}
+	c := *o
+	return &c
+}
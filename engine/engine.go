@@ -0,0 +1,317 @@
+package engine
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/PanGan21/crypto-exchange-poc/orderbook"
+)
+
+// queueSize is the number of commands waiting for the engine before Submit blocks
+const queueSize = 1024
+
+// Engine runs the book of a market on a single goroutine.
+// Commands are queued, sequenced and applied one at a time, so the book
+// always goes through the same states for the same commands.
+// The engine must be the only one changing its book.
+type Engine struct {
+	market   string
+	book     *orderbook.Orderbook
+	commands chan request
+	events   chan<- Event
+	journal  *Journal
+	applied  func(cmd *Command, res Result)
+	bookOpts []orderbook.Option
+	quit     chan struct{}
+	stopOnce sync.Once
+	stopped  chan struct{}
+
+	// owned by the engine goroutine
+	sequence      uint64
+	eventSequence uint64
+	lastTrade     uint64
+	now           int64 // timestamp of the command being applied, the clock of the book
+	cancelled     []*orderbook.Order
+}
+
+type request struct {
+	cmd    *Command
+	result chan Result
+}
+
+// State is where an engine is in its commands and events, it is saved with the snapshot of its book
+type State struct {
+	Sequence      uint64 // Last command applied
+	EventSequence uint64 // Last event sent
+	LastTrade     uint64 // Sequence of the last trade of the book
+}
+
+// New starts the engine of market with an empty book
+func New(market string, opts ...Option) *Engine {
+	e := newEngine(market, opts)
+	e.book = orderbook.NewOrderbook(e.bookOpts...)
+
+	go e.run()
+
+	return e
+}
+
+// Restore starts the engine of market from the snapshot of its book taken at state,
+// it carries on with the command after state.Sequence
+func Restore(market string, s *orderbook.Snapshot, state State, opts ...Option) (*Engine, error) {
+	e := newEngine(market, opts)
+
+	book, err := s.Restore(market, e.bookOpts...)
+	if err != nil {
+		return nil, err
+	}
+	e.book = book
+	e.sequence = state.Sequence
+	e.eventSequence = state.EventSequence
+	e.lastTrade = state.LastTrade
+
+	go e.run()
+
+	return e, nil
+}
+
+func newEngine(market string, opts []Option) *Engine {
+	e := &Engine{
+		market:   market,
+		commands: make(chan request, queueSize),
+		quit:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(e)
+	}
+
+	e.bookOpts = append(e.bookOpts,
+		orderbook.WithCancelListener(func(o *orderbook.Order) {
+			e.cancelled = append(e.cancelled, o)
+		}),
+		orderbook.WithClock(func() int64 {
+			return e.now
+		}),
+	)
+
+	return e
+}
+
+func (e *Engine) Market() string {
+	return e.market
+}
+
+// Book returns the book of the engine, it must only be read
+func (e *Engine) Book() *orderbook.Orderbook {
+	return e.book
+}
+
+// State returns where the engine is, it must be called while the engine applies no command:
+// from a query or while holding its journal in a checkpoint
+func (e *Engine) State() State {
+	return State{Sequence: e.sequence, EventSequence: e.eventSequence, LastTrade: e.lastTrade}
+}
+
+// Stop ends the engine once the command it is applying is done.
+// Commands still queued fail with ErrStopped.
+func (e *Engine) Stop() {
+	e.stop()
+	<-e.stopped
+}
+
+func (e *Engine) stop() {
+	e.stopOnce.Do(func() {
+		close(e.quit)
+	})
+}
+
+// Submit queues cmd and waits for its result
+func (e *Engine) Submit(cmd *Command) Result {
+	if !cmd.valid() {
+		return Result{Err: ErrInvalidCommand}
+	}
+
+	req := request{cmd: cmd, result: make(chan Result, 1)}
+
+	select {
+	case e.commands <- req:
+	case <-e.stopped:
+		return Result{Err: ErrStopped}
+	}
+
+	select {
+	case res := <-req.result:
+		return res
+	case <-e.stopped:
+		select {
+		case res := <-req.result:
+			return res
+		default:
+			return Result{Err: ErrStopped}
+		}
+	}
+}
+
+// Query runs f on the engine goroutine between two commands
+func (e *Engine) Query(f func(ob *orderbook.Orderbook)) error {
+	return e.Submit(&Command{Type: CommandQuery, Query: f}).Err
+}
+
+func (e *Engine) run() {
+	defer close(e.stopped)
+
+	for {
+		select {
+		case <-e.quit:
+			return
+		case req := <-e.commands:
+			req.result <- e.apply(req.cmd)
+		}
+	}
+}
+
+// Replay applies a command entry of the journal with its original sequence and timestamp.
+// Its events are checked against the journal instead of being sent.
+func (e *Engine) Replay(entry *Entry) (res Result, err error) {
+	cmd := entry.Command
+	if cmd == nil || cmd.Type == CommandQuery || !cmd.valid() {
+		return res, fmt.Errorf("%w: entry of %s has no command to replay", ErrCorruptJournal, e.market)
+	}
+
+	// replays run on the engine goroutine like any other command
+	queryErr := e.Query(func(*orderbook.Orderbook) {
+		res, err = e.replay(entry)
+	})
+	if queryErr != nil {
+		return res, queryErr
+	}
+	return res, err
+}
+
+func (e *Engine) replay(entry *Entry) (Result, error) {
+	cmd := entry.Command
+	if cmd.Sequence != e.sequence+1 {
+		return Result{}, fmt.Errorf("%w: %s command %d follows %d", ErrReplayDiverged, e.market, cmd.Sequence, e.sequence)
+	}
+
+	e.book.ReplayIDs(entry.IDs)
+	res, events := e.execute(cmd)
+	e.book.ReplayIDs(nil)
+	if !sameEvents(events, entry.Events) {
+		return res, fmt.Errorf("%w: %s command %d caused other events", ErrReplayDiverged, e.market, cmd.Sequence)
+	}
+
+	if e.applied != nil {
+		e.applied(cmd, res)
+	}
+
+	return res, nil
+}
+
+func (e *Engine) apply(cmd *Command) Result {
+	if cmd.Type == CommandQuery {
+		cmd.Query(e.book)
+		return Result{}
+	}
+
+	var ids *orderbook.IDLog
+	if e.journal != nil {
+		// the engines of the journal apply their commands side by side, only checkpoints wait for them
+		e.journal.apply.RLock()
+		defer e.journal.apply.RUnlock()
+
+		ids = &orderbook.IDLog{}
+		e.book.LogIDs(ids)
+	}
+
+	cmd.Sequence = e.sequence + 1
+	cmd.Timestamp = time.Now().UnixNano()
+	entry := &Entry{Market: e.market, Command: cmd.clone()}
+
+	res, events := e.execute(cmd)
+
+	if e.journal != nil {
+		e.book.LogIDs(nil)
+		if !ids.Empty() {
+			entry.IDs = ids
+		}
+		entry.Events = events
+		if err := e.journal.Append(entry); err != nil {
+			// the book is ahead of the journal, restarting recovers the journaled state
+			e.stop()
+			return Result{Sequence: cmd.Sequence, Err: fmt.Errorf("%w: %v", ErrJournal, err)}
+		}
+	}
+
+	if e.applied != nil {
+		e.applied(cmd, res)
+	}
+
+	if e.events != nil {
+		for _, event := range events {
+			e.events <- event
+		}
+	}
+
+	return res
+}
+
+// execute applies cmd to the book at its sequence and timestamp
+func (e *Engine) execute(cmd *Command) (Result, []Event) {
+	e.sequence = cmd.Sequence
+	e.now = cmd.Timestamp
+	e.cancelled = nil
+
+	res := Result{Sequence: cmd.Sequence}
+
+	switch cmd.Type {
+	case CommandPlace:
+		res.Group, res.Matches, res.Err = e.place(cmd)
+	case CommandCancel:
+		res.Matches, res.Err = e.book.CancelOrder(cmd.OrderId)
+	case CommandAmend:
+		res.Amendment, res.Matches, res.Err = e.book.ModifyOrder(cmd.OrderId, cmd.UserId, cmd.Price, cmd.Size)
+	case CommandExpire:
+		res.Expired, res.Matches = e.book.ExpireOrders(cmd.Timestamp)
+	}
+	res.Cancelled = e.cancelled
+
+	return res, e.collectEvents(cmd, res, e.cancelled)
+}
+
+func sameEvents(a, b []Event) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !reflect.DeepEqual(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (e *Engine) place(cmd *Command) (*orderbook.OrderGroup, []orderbook.Match, error) {
+	o := cmd.Order
+
+	switch cmd.Kind {
+	case KindLimit:
+		matches, err := e.book.PlaceLimitOrder(o.Price, o)
+		return nil, matches, err
+	case KindMarket:
+		matches, err := e.book.PlaceMarketOrder(o)
+		return nil, matches, err
+	case KindStop:
+		return nil, nil, e.book.PlaceStopOrder(o)
+	case KindPegged:
+		return nil, nil, e.book.PlacePeggedOrder(o)
+	case KindOCO:
+		return e.book.PlaceOCO(o, cmd.StopLoss)
+	default:
+		return e.book.PlaceBracket(o, cmd.TakeProfit, cmd.StopLoss)
+	}
+}
@@ -0,0 +1,184 @@
+package engine
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/PanGan21/crypto-exchange-poc/decimal"
+	"github.com/PanGan21/crypto-exchange-poc/orderbook"
+)
+
+func assert(t *testing.T, a, b any) {
+	if !reflect.DeepEqual(a, b) {
+		t.Errorf("%+v != %+v", a, b)
+	}
+}
+
+func limitOrder(bid bool, price, size int64, userId int64) *Command {
+	o := orderbook.NewOrder(bid, decimal.NewFromInt(size), userId)
+	o.Price = decimal.NewFromInt(price)
+	return &Command{Type: CommandPlace, Kind: KindLimit, Order: o}
+}
+
+func marketOrder(bid bool, size int64, userId int64) *Command {
+	return &Command{Type: CommandPlace, Kind: KindMarket, Order: orderbook.NewOrder(bid, decimal.NewFromInt(size), userId)}
+}
+
+// drain returns the events sent so far
+func drain(events chan Event) []Event {
+	drained := []Event{}
+	for {
+		select {
+		case event := <-events:
+			drained = append(drained, event)
+		default:
+			return drained
+		}
+	}
+}
+
+func eventTypes(events []Event) []EventType {
+	types := []EventType{}
+	for _, event := range events {
+		types = append(types, event.Type)
+	}
+	return types
+}
+
+func TestEngineEvents(t *testing.T) {
+	events := make(chan Event, 100)
+	e := New("ETH-USDC", WithEvents(events))
+	defer e.Stop()
+
+	ask := limitOrder(false, 100, 2, 1)
+	res := e.Submit(ask)
+	assert(t, res.Err, nil)
+	assert(t, res.Sequence, uint64(1))
+
+	// the remainder of the market order is cancelled
+	res = e.Submit(marketOrder(true, 3, 2))
+	assert(t, res.Err, nil)
+	assert(t, res.Sequence, uint64(2))
+	assert(t, len(res.Matches), 1)
+
+	got := drain(events)
+	assert(t, eventTypes(got), []EventType{EventAccepted, EventAccepted, EventTrade, EventCancelled})
+	for i, event := range got {
+		assert(t, event.Sequence, uint64(i+1))
+		assert(t, event.Market, "ETH-USDC")
+	}
+	assert(t, got[0].OrderId, ask.Order.Id)
+	assert(t, got[1].Command, uint64(2))
+	assert(t, got[2].Trade.MakerOrderId, ask.Order.Id)
+	assert(t, got[2].Trade.Size, decimal.NewFromInt(2))
+	assert(t, got[3].OrderId, got[1].OrderId)
+}
+
+func TestEngineRejectedCommands(t *testing.T) {
+	events := make(chan Event, 100)
+	e := New("ETH-USDC", WithEvents(events))
+	defer e.Stop()
+
+	res := e.Submit(&Command{Type: CommandCancel, OrderId: 42})
+	assert(t, res.Err, orderbook.ErrOrderNotFound)
+
+	res = e.Submit(marketOrder(true, 1, 1))
+	_, insufficient := res.Err.(*orderbook.InsufficientVolumeError)
+	assert(t, insufficient, true)
+
+	res = e.Submit(&Command{Type: CommandPlace, Kind: KindLimit})
+	assert(t, res.Err, ErrInvalidCommand)
+
+	got := drain(events)
+	assert(t, eventTypes(got), []EventType{EventRejected, EventRejected})
+	assert(t, got[0].OrderId, int64(42))
+	assert(t, got[0].Reason, orderbook.ErrOrderNotFound.Error())
+	assert(t, got[1].UserId, int64(1))
+}
+
+func TestEngineCancelAmendAndQuery(t *testing.T) {
+	events := make(chan Event, 100)
+	e := New("ETH-USDC", WithEvents(events), WithBookOptions(orderbook.WithTickSize(decimal.RequireFromString("0.01"))))
+	defer e.Stop()
+
+	bid := limitOrder(true, 90, 5, 1)
+	e.Submit(bid)
+
+	res := e.Submit(&Command{Type: CommandAmend, OrderId: bid.Order.Id, UserId: 1, Price: decimal.NewFromInt(95), Size: decimal.NewFromInt(4)})
+	assert(t, res.Err, nil)
+	assert(t, res.Amendment.NewPrice, decimal.NewFromInt(95))
+
+	var best *orderbook.Level
+	assert(t, e.Query(func(ob *orderbook.Orderbook) { best = ob.BestBid() }), nil)
+	assert(t, best.Price, decimal.NewFromInt(95))
+	assert(t, best.TotalVolume, decimal.NewFromInt(4))
+
+	res = e.Submit(&Command{Type: CommandCancel, OrderId: bid.Order.Id})
+	assert(t, res.Err, nil)
+	// queries take no sequence
+	assert(t, res.Sequence, uint64(3))
+
+	assert(t, eventTypes(drain(events)), []EventType{EventAccepted, EventAmended, EventCancelled})
+	assert(t, e.Book().BestBid() == nil, true)
+}
+
+func TestEngineStop(t *testing.T) {
+	e := New("ETH-USDC")
+	e.Submit(limitOrder(false, 100, 1, 1))
+	e.Stop()
+	e.Stop()
+
+	assert(t, e.Submit(limitOrder(false, 100, 1, 1)).Err, ErrStopped)
+}
+
+// run with go test -race, the book is only touched by the engine goroutine
+func TestEngineConcurrentSubmit(t *testing.T) {
+	const (
+		workers = 8
+		orders  = 100
+	)
+
+	events := make(chan Event)
+	e := New("ETH-USDC", WithEvents(events))
+
+	received := make(chan []Event)
+	go func() {
+		all := []Event{}
+		for event := range events {
+			all = append(all, event)
+		}
+		received <- all
+	}()
+
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		sequences = make(map[uint64]bool)
+	)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < orders; i++ {
+				cmd := limitOrder(i%2 == 0, int64(95+i%10), 1, int64(w))
+				if i%3 == 0 {
+					cmd = marketOrder(i%2 == 0, 2, int64(w))
+				}
+				res := e.Submit(cmd)
+
+				mu.Lock()
+				sequences[res.Sequence] = true
+				mu.Unlock()
+			}
+		}(w)
+	}
+	wg.Wait()
+	e.Stop()
+	close(events)
+
+	assert(t, len(sequences), workers*orders)
+	for i, event := range <-received {
+		assert(t, event.Sequence, uint64(i+1))
+	}
+}
@@ -0,0 +1,83 @@
+package engine
+
+import "github.com/PanGan21/crypto-exchange-poc/orderbook"
+
+type EventType string
+
+const (
+	EventAccepted  EventType = "ACCEPTED"
+	EventTrade     EventType = "TRADE"
+	EventAmended   EventType = "AMENDED"
+	EventCancelled EventType = "CANCELLED"
+	EventExpired   EventType = "EXPIRED"
+	// EventRejected is a command the book refused, with the order it was about
+	EventRejected EventType = "REJECTED"
+)
+
+// Event is a change of the book. The events of a command follow its order:
+// the orders it placed or amended, its trades, then the orders it cancelled or expired.
+type Event struct {
+	Sequence  uint64 // Increments by one for every event of the engine, a gap means a missed event
+	Command   uint64 // Sequence of the command that caused the event
+	Market    string
+	Type      EventType
+	Timestamp int64
+	OrderId   int64            `json:",omitempty"`
+	UserId    int64            `json:",omitempty"`
+	Trade     *orderbook.Trade `json:",omitempty"`
+	Reason    string           `json:",omitempty"` // Why a command was rejected
+}
+
+// collectEvents returns the events of cmd, cancelled holds the orders the book cancelled while applying it
+func (e *Engine) collectEvents(cmd *Command, res Result, cancelled []*orderbook.Order) []Event {
+	events := []Event{}
+	emit := func(event Event) {
+		e.eventSequence++
+		event.Sequence = e.eventSequence
+		event.Command = cmd.Sequence
+		event.Market = e.market
+		event.Timestamp = cmd.Timestamp
+		events = append(events, event)
+	}
+
+	if res.Err != nil {
+		if cmd.Type == CommandPlace {
+			for _, o := range cmd.orders() {
+				emit(Event{Type: EventRejected, OrderId: o.Id, UserId: o.UserId, Reason: res.Err.Error()})
+			}
+		} else {
+			emit(Event{Type: EventRejected, OrderId: cmd.OrderId, UserId: cmd.UserId, Reason: res.Err.Error()})
+		}
+	}
+
+	switch {
+	case res.Err != nil:
+	case cmd.Type == CommandPlace:
+		for _, o := range cmd.orders() {
+			// the exits of a bracket are placed once its entry fills
+			if o.Id != 0 {
+				emit(Event{Type: EventAccepted, OrderId: o.Id, UserId: o.UserId})
+			}
+		}
+	case cmd.Type == CommandAmend:
+		emit(Event{Type: EventAmended, OrderId: res.Amendment.OrderId, UserId: res.Amendment.UserId})
+	}
+
+	for _, trade := range e.book.TradesSince(e.lastTrade) {
+		emit(Event{Type: EventTrade, Trade: trade})
+		e.lastTrade = trade.Sequence
+	}
+
+	for _, o := range cancelled {
+		if o.Id == 0 {
+			continue
+		}
+		event := Event{Type: EventCancelled, OrderId: o.Id, UserId: o.UserId}
+		if o.Status == orderbook.StatusExpired {
+			event.Type = EventExpired
+		}
+		emit(event)
+	}
+
+	return events
+}
@@ -0,0 +1,335 @@
+package engine
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/PanGan21/crypto-exchange-poc/orderbook"
+)
+
+var (
+	ErrCorruptJournal = errors.New("corrupt journal")
+	ErrJournal        = errors.New("journal write failed")
+	ErrReplayDiverged = errors.New("replay diverged from the journal")
+)
+
+// SyncPolicy decides when the journal is flushed to disk with fsync
+type SyncPolicy string
+
+const (
+	// SyncAlways flushes every command before its result is returned
+	SyncAlways SyncPolicy = "ALWAYS"
+	// SyncPeriodic flushes at most once every syncInterval, a crash loses the commands since the last flush
+	SyncPeriodic SyncPolicy = "PERIODIC"
+	// SyncNever leaves flushing to the operating system
+	SyncNever SyncPolicy = "NEVER"
+)
+
+const (
+	syncInterval = 100 * time.Millisecond
+	// every entry is framed by the length and the CRC-32C checksum of its payload
+	headerSize = 8
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Entry is a record of the journal, a command with the events it caused
+// or a record of the user of the journal, like the creation of a market
+type Entry struct {
+	Market  string
+	Command *Command         `json:",omitempty"`
+	Events  []Event          `json:",omitempty"`
+	IDs     *orderbook.IDLog `json:",omitempty"` // Ids taken by the command, handed out again by its replay
+	Data    json.RawMessage  `json:",omitempty"`
+}
+
+// Journal is an append-only log of the commands applied by the engines of an exchange.
+// The engines apply their commands concurrently and the entries waiting to be written
+// are written together, with one flush for all of them. The books of an exchange share
+// their id generator, so every entry records the ids its command took for the replay
+// of the command to take them again.
+//
+// The journal is a directory of numbered segment files. A checkpoint starts a new segment,
+// a snapshot of the state at a checkpoint replaces the segments before it.
+type Journal struct {
+	// engines hold apply for reading while they apply a command,
+	// checkpoints hold it for writing to see no command half applied
+	apply sync.RWMutex
+
+	mu      sync.Mutex
+	written *sync.Cond // Signalled when a batch of entries is written
+	pending [][]byte   // Framed entries waiting for the next batch
+	queued  uint64     // Entries appended so far
+	done    uint64     // Entries written so far
+	writing bool       // An appender is writing a batch, it owns the fields below
+
+	dir      string
+	f        *os.File
+	segment  uint64 // Number of the segment being written
+	size     int64  // Bytes written to the segment
+	policy   SyncPolicy
+	lastSync time.Time
+	err      error // set by the first failed write, the end of the file can't be trusted after it
+}
+
+// OpenJournal opens or creates the journal in dir and returns the entries of its segments
+// from segment from on, the segments before it are covered by a snapshot.
+// A partial entry at the end of the last segment, left by a crash while it was written, is dropped.
+func OpenJournal(dir string, policy SyncPolicy, from uint64) (*Journal, []*Entry, error) {
+	switch policy {
+	case SyncAlways, SyncPeriodic, SyncNever:
+	default:
+		return nil, nil, fmt.Errorf("sync policy [%s] not supported", policy)
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, nil, err
+	}
+
+	entries, segment, size, err := readSegments(dir, from)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	path := segmentPath(dir, segment)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = f.Truncate(size)
+	if err == nil {
+		_, err = f.Seek(size, io.SeekStart)
+	}
+	if err == nil {
+		err = syncDir(dir)
+	}
+	if err != nil {
+		f.Close()
+		return nil, nil, fmt.Errorf("opening journal %s: %w", path, err)
+	}
+
+	j := &Journal{dir: dir, f: f, segment: segment, size: size, policy: policy, lastSync: time.Now()}
+	j.written = sync.NewCond(&j.mu)
+
+	return j, entries, nil
+}
+
+// ReadJournal returns the entries of the journal in dir from segment from on without changing it,
+// so it can be read while an exchange writes it. A partial entry at the end is left out.
+func ReadJournal(dir string, from uint64) ([]*Entry, error) {
+	entries, _, _, err := readSegments(dir, from)
+	return entries, err
+}
+
+// readSegments decodes the entries of the segments from segment from on
+// and returns the last segment with the size of its complete entries
+func readSegments(dir string, from uint64) ([]*Entry, uint64, int64, error) {
+	segments, err := listFiles(dir, segmentExt, from)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if len(segments) == 0 {
+		// a checkpoint creates its segment before the snapshot is taken
+		if from > 0 {
+			return nil, 0, 0, fmt.Errorf("%w: segment %d is missing", ErrCorruptJournal, from)
+		}
+		return []*Entry{}, from, 0, nil
+	}
+
+	entries := []*Entry{}
+	var size int64
+
+	for i, segment := range segments {
+		if segment != from+uint64(i) {
+			return nil, 0, 0, fmt.Errorf("%w: segment %d is missing", ErrCorruptJournal, from+uint64(i))
+		}
+
+		data, err := os.ReadFile(segmentPath(dir, segment))
+		if err != nil {
+			return nil, 0, 0, err
+		}
+
+		var segmentEntries []*Entry
+		segmentEntries, size, err = readEntries(data)
+		if err == nil && size != int64(len(data)) && i < len(segments)-1 {
+			err = fmt.Errorf("%w: partial entry at offset %d", ErrCorruptJournal, size)
+		}
+		if err != nil {
+			return nil, 0, 0, fmt.Errorf("segment %d: %w", segment, err)
+		}
+		entries = append(entries, segmentEntries...)
+	}
+
+	return entries, segments[len(segments)-1], size, nil
+}
+
+// readEntries decodes the entries of data and returns the size of the complete ones.
+// Only an entry cut short by the end of data is left out, any other damage is an error.
+func readEntries(data []byte) ([]*Entry, int64, error) {
+	entries := []*Entry{}
+	offset := 0
+
+	for offset < len(data) {
+		frame := data[offset:]
+		if len(frame) < headerSize || len(frame)-headerSize < int(binary.BigEndian.Uint32(frame[0:4])) {
+			// a crash while an entry was written leaves its start at the end of the file,
+			// a complete entry after it means the length was damaged instead
+			if frameAt(frame[1:]) {
+				return nil, 0, fmt.Errorf("%w: bad length at offset %d", ErrCorruptJournal, offset)
+			}
+			break
+		}
+
+		length := int(binary.BigEndian.Uint32(frame[0:4]))
+		checksum := binary.BigEndian.Uint32(frame[4:8])
+		payload := frame[headerSize : headerSize+length]
+		if crc32.Checksum(payload, crcTable) != checksum {
+			return nil, 0, fmt.Errorf("%w: bad checksum at offset %d", ErrCorruptJournal, offset)
+		}
+
+		entry := &Entry{}
+		if err := json.Unmarshal(payload, entry); err != nil {
+			return nil, 0, fmt.Errorf("%w: entry at offset %d: %v", ErrCorruptJournal, offset, err)
+		}
+		entries = append(entries, entry)
+		offset += headerSize + length
+	}
+
+	return entries, int64(offset), nil
+}
+
+// frameAt reports whether a complete entry with a valid checksum starts anywhere in data
+func frameAt(data []byte) bool {
+	for i := 0; len(data)-i > headerSize; i++ {
+		length := int(binary.BigEndian.Uint32(data[i : i+4]))
+		if length == 0 || len(data)-i-headerSize < length {
+			continue
+		}
+		if crc32.Checksum(data[i+headerSize:i+headerSize+length], crcTable) == binary.BigEndian.Uint32(data[i+4:i+8]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Update runs f while no checkpoint is taken, so a change f makes to the state
+// and journals with Append is either in the snapshot or in the segments after it
+func (j *Journal) Update(f func() error) error {
+	j.apply.RLock()
+	defer j.apply.RUnlock()
+
+	return f()
+}
+
+// Append adds entry at the end of the journal and waits until it is written
+// and flushed according to the sync policy
+func (j *Journal) Append(entry *Entry) error {
+	payload, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.err != nil {
+		return j.err
+	}
+
+	j.pending = append(j.pending, newFrame(payload))
+	j.queued++
+	ticket := j.queued
+
+	// the first appender to find no batch being written writes the entries waiting
+	for j.done < ticket && j.err == nil {
+		if j.writing {
+			j.written.Wait()
+			continue
+		}
+		j.writeBatch()
+	}
+	if j.done < ticket {
+		return j.err
+	}
+	return nil
+}
+
+// writeBatch writes the pending entries, j.mu is released while they are written
+func (j *Journal) writeBatch() {
+	batch := j.pending
+	j.pending = nil
+	j.writing = true
+	j.mu.Unlock()
+
+	err := j.write(batch)
+
+	j.mu.Lock()
+	j.writing = false
+	if err != nil {
+		j.err = err
+	} else {
+		j.done += uint64(len(batch))
+	}
+	j.written.Broadcast()
+}
+
+func (j *Journal) write(batch [][]byte) error {
+	var buf []byte
+	for _, frame := range batch {
+		buf = append(buf, frame...)
+	}
+
+	n, err := j.f.Write(buf)
+	j.size += int64(n)
+	if err != nil {
+		return err
+	}
+
+	switch j.policy {
+	case SyncAlways:
+		return j.f.Sync()
+	case SyncPeriodic:
+		if now := time.Now(); now.Sub(j.lastSync) >= syncInterval {
+			j.lastSync = now
+			return j.f.Sync()
+		}
+	}
+
+	return nil
+}
+
+// flush writes the entries still waiting, j.mu must be held
+func (j *Journal) flush() error {
+	for j.err == nil && (j.writing || len(j.pending) > 0) {
+		if j.writing {
+			j.written.Wait()
+			continue
+		}
+		j.writeBatch()
+	}
+	return j.err
+}
+
+// Close flushes the journal and closes its file
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if err := j.flush(); err != nil {
+		j.f.Close()
+		return err
+	}
+	if err := j.f.Sync(); err != nil {
+		j.f.Close()
+		return err
+	}
+	return j.f.Close()
+}
@@ -0,0 +1,393 @@
+package engine
+
+import (
+	"errors"
+	"math/rand"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/PanGan21/crypto-exchange-poc/decimal"
+	"github.com/PanGan21/crypto-exchange-poc/orderbook"
+)
+
+type restingOrder struct {
+	Id        int64
+	UserId    int64
+	Bid       bool
+	Price     decimal.Decimal
+	Size      decimal.Decimal
+	StopPrice decimal.Decimal
+	Status    orderbook.OrderStatus
+	Filled    decimal.Decimal
+	Sequence  uint64
+	Timestamp int64
+}
+
+type bookState struct {
+	Asks   []restingOrder
+	Bids   []restingOrder
+	Stops  []restingOrder
+	Trades []*orderbook.Trade
+}
+
+func newBookState(ob *orderbook.Orderbook) bookState {
+	state := bookState{Trades: ob.Trades()}
+
+	for _, level := range ob.Asks() {
+		state.Asks = append(state.Asks, restingOrders(level.Orders)...)
+	}
+	for _, level := range ob.Bids() {
+		state.Bids = append(state.Bids, restingOrders(level.Orders)...)
+	}
+	state.Stops = append(restingOrders(ob.StopOrders(true)), restingOrders(ob.StopOrders(false))...)
+
+	return state
+}
+
+func restingOrders(orders []*orderbook.Order) []restingOrder {
+	resting := []restingOrder{}
+	for _, o := range orders {
+		resting = append(resting, restingOrder{
+			Id:        o.Id,
+			UserId:    o.UserId,
+			Bid:       o.Bid,
+			Price:     o.Price,
+			Size:      o.Size,
+			StopPrice: o.StopPrice,
+			Status:    o.Status,
+			Filled:    o.Filled,
+			Sequence:  o.Sequence,
+			Timestamp: o.Timestamp,
+		})
+	}
+	return resting
+}
+
+func randomCommand(r *rand.Rand, userId int64) *Command {
+	bid := r.Intn(2) == 0
+	price := int64(95 + r.Intn(10))
+	size := int64(r.Intn(5) + 1)
+
+	switch r.Intn(8) {
+	case 0:
+		return marketOrder(bid, size, userId)
+	case 1:
+		return &Command{Type: CommandCancel, OrderId: int64(r.Intn(200) + 1)}
+	case 2:
+		return &Command{Type: CommandAmend, OrderId: int64(r.Intn(200) + 1), UserId: userId, Price: decimal.NewFromInt(price), Size: decimal.NewFromInt(size)}
+	case 3:
+		cmd := limitOrder(bid, price, size, userId)
+		cmd.Order.TimeInForce = orderbook.GTD
+		cmd.Order.ExpiresAt = time.Now().Add(time.Duration(r.Intn(5)) * time.Millisecond).UnixNano()
+		return cmd
+	case 4:
+		return &Command{Type: CommandExpire}
+	case 5:
+		o := orderbook.NewOrder(bid, decimal.NewFromInt(size), userId)
+		o.StopPrice = decimal.NewFromInt(price)
+		return &Command{Type: CommandPlace, Kind: KindStop, Order: o}
+	default:
+		return limitOrder(bid, price, size, userId)
+	}
+}
+
+// workers submits random commands to e from several goroutines and calls
+// checkpoint then stop after the given numbers of commands
+func workers(e *Engine, checkpoint func(), stopAfter int64) {
+	var (
+		wg      sync.WaitGroup
+		applied atomic.Int64
+	)
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(int64(w)))
+
+			for {
+				if e.Submit(randomCommand(r, int64(w))).Err == ErrStopped {
+					return
+				}
+				switch applied.Add(1) {
+				case stopAfter / 2:
+					checkpoint()
+				case stopAfter:
+					e.Stop()
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+}
+
+// tear appends the start of an entry to the last segment of the journal in dir, like a crash would
+func tear(t *testing.T, dir string) {
+	segments, err := listFiles(dir, segmentExt, 0)
+	assert(t, err, nil)
+
+	f, err := os.OpenFile(segmentPath(dir, segments[len(segments)-1]), os.O_APPEND|os.O_WRONLY, 0)
+	assert(t, err, nil)
+	f.Write([]byte{0, 0, 1, 0, 1, 2, 3, 4, '{', '"'})
+	f.Close()
+}
+
+func TestJournalRecoversBookAfterCrash(t *testing.T) {
+	dir := t.TempDir()
+
+	journal, entries, err := OpenJournal(dir, SyncAlways, 0)
+	assert(t, err, nil)
+	assert(t, len(entries), 0)
+
+	// kill the engine while the workers are still sending commands
+	e := New("ETH-USDC", WithJournal(journal), WithBookOptions(orderbook.WithIDGenerator(orderbook.NewIDGenerator())))
+	workers(e, func() {}, 500)
+
+	want := newBookState(e.Book())
+	assert(t, len(want.Trades) > 0, true)
+
+	tear(t, dir)
+
+	journal, entries, err = OpenJournal(dir, SyncAlways, 0)
+	assert(t, err, nil)
+	defer journal.Close()
+
+	recovered := New("ETH-USDC", WithJournal(journal), WithBookOptions(orderbook.WithIDGenerator(orderbook.NewIDGenerator())))
+	defer recovered.Stop()
+
+	for _, entry := range entries {
+		if _, err := recovered.Replay(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assert(t, newBookState(recovered.Book()), want)
+
+	// the recovered engine carries on after the last journaled command
+	res := recovered.Submit(limitOrder(true, 90, 1, 9))
+	assert(t, res.Err, nil)
+	assert(t, res.Sequence, uint64(len(entries)+1))
+
+	_, entries, err = OpenJournal(dir, SyncNever, 0)
+	assert(t, err, nil)
+	assert(t, entries[len(entries)-1].Command.Sequence, res.Sequence)
+}
+
+func TestJournalMarketsApplyConcurrently(t *testing.T) {
+	dir := t.TempDir()
+
+	journal, _, err := OpenJournal(dir, SyncAlways, 0)
+	assert(t, err, nil)
+
+	// the markets share their ids and take them in whatever order their commands run
+	markets := []string{"ETH-USDC", "WBTC-ETH"}
+	newEngines := func(journal *Journal) []*Engine {
+		ids := orderbook.NewIDGenerator()
+		engines := []*Engine{}
+		for _, market := range markets {
+			engines = append(engines, New(market, WithJournal(journal), WithBookOptions(orderbook.WithIDGenerator(ids))))
+		}
+		return engines
+	}
+
+	engines := newEngines(journal)
+	var wg sync.WaitGroup
+	for _, e := range engines {
+		wg.Add(1)
+		go func(e *Engine) {
+			defer wg.Done()
+			workers(e, func() {}, 300)
+		}(e)
+	}
+	wg.Wait()
+	assert(t, journal.Close(), nil)
+
+	journal, entries, err := OpenJournal(dir, SyncNever, 0)
+	assert(t, err, nil)
+	defer journal.Close()
+
+	recovered := newEngines(journal)
+	for _, entry := range entries {
+		e := recovered[0]
+		if entry.Market == markets[1] {
+			e = recovered[1]
+		}
+		if _, err := e.Replay(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i, e := range recovered {
+		assert(t, newBookState(e.Book()), newBookState(engines[i].Book()))
+		e.Stop()
+	}
+}
+
+type engineSnapshot struct {
+	Books *orderbook.Snapshot
+	State State
+}
+
+func TestJournalSnapshot(t *testing.T) {
+	dir := t.TempDir()
+
+	journal, _, err := OpenJournal(dir, SyncNever, 0)
+	assert(t, err, nil)
+
+	var (
+		e        *Engine
+		segment  uint64
+		snapshot engineSnapshot
+	)
+	checkpoint := func() {
+		err := journal.Checkpoint(func(s uint64) error {
+			segment = s
+			snapshot = engineSnapshot{Books: orderbook.NewSnapshot(), State: e.State()}
+			snapshot.Books.AddBook(e.Market(), e.Book())
+			return nil
+		})
+		assert(t, err, nil)
+		assert(t, journal.SaveSnapshot(segment, snapshot), nil)
+	}
+
+	// snapshot the book while the workers are sending commands
+	e = New("ETH-USDC", WithJournal(journal), WithBookOptions(orderbook.WithIDGenerator(orderbook.NewIDGenerator())))
+	workers(e, checkpoint, 500)
+	journal.Close()
+
+	want := newBookState(e.Book())
+	want.Trades = e.Book().TradesSince(snapshot.State.LastTrade)
+
+	tear(t, dir)
+
+	// only the segments after the snapshot are left
+	segments, err := listFiles(dir, segmentExt, 0)
+	assert(t, err, nil)
+	assert(t, segments, []uint64{1})
+
+	var loaded engineSnapshot
+	loadedSegment, ok, err := LoadSnapshot(dir, &loaded)
+	assert(t, err, nil)
+	assert(t, ok, true)
+	assert(t, loadedSegment, segment)
+	assert(t, loaded.State, snapshot.State)
+
+	journal, entries, err := OpenJournal(dir, SyncNever, loadedSegment)
+	assert(t, err, nil)
+	defer journal.Close()
+	assert(t, entries[0].Command.Sequence, snapshot.State.Sequence+1)
+
+	recovered, err := Restore("ETH-USDC", loaded.Books, loaded.State, WithJournal(journal), WithBookOptions(orderbook.WithIDGenerator(orderbook.NewIDGenerator())))
+	assert(t, err, nil)
+	defer recovered.Stop()
+
+	for _, entry := range entries {
+		if _, err := recovered.Replay(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := newBookState(recovered.Book())
+	got.Trades = recovered.Book().TradesSince(snapshot.State.LastTrade)
+	assert(t, got, want)
+
+	res := recovered.Submit(limitOrder(true, 90, 1, 9))
+	assert(t, res.Err, nil)
+	assert(t, res.Sequence, snapshot.State.Sequence+uint64(len(entries))+1)
+
+	// without a snapshot the journal can't be replayed
+	_, _, err = OpenJournal(dir, SyncNever, 0)
+	assert(t, errors.Is(err, ErrCorruptJournal), true)
+}
+
+func TestJournalCorruption(t *testing.T) {
+	dir := t.TempDir()
+
+	journal, _, err := OpenJournal(dir, SyncNever, 0)
+	assert(t, err, nil)
+	e := New("ETH-USDC", WithJournal(journal))
+	e.Submit(limitOrder(true, 90, 1, 1))
+	e.Submit(limitOrder(true, 91, 1, 1))
+	assert(t, journal.Checkpoint(func(uint64) error { return nil }), nil)
+	e.Submit(limitOrder(true, 92, 1, 1))
+	e.Stop()
+	journal.Close()
+
+	// a partial entry is only expected at the end of the last segment
+	data, err := os.ReadFile(segmentPath(dir, 0))
+	assert(t, err, nil)
+	assert(t, os.WriteFile(segmentPath(dir, 0), data[:len(data)-1], 0o644), nil)
+
+	_, _, err = OpenJournal(dir, SyncNever, 0)
+	assert(t, errors.Is(err, ErrCorruptJournal), true)
+
+	data[headerSize+1] ^= 0xff
+	assert(t, os.WriteFile(segmentPath(dir, 0), data, 0o644), nil)
+
+	_, _, err = OpenJournal(dir, SyncNever, 0)
+	assert(t, errors.Is(err, ErrCorruptJournal), true)
+
+	_, _, err = OpenJournal(dir, SyncNever, 2)
+	assert(t, errors.Is(err, ErrCorruptJournal), true)
+
+	assert(t, os.WriteFile(snapshotPath(dir, 1), []byte("{}"), 0o644), nil)
+	_, _, err = LoadSnapshot(dir, &engineSnapshot{})
+	assert(t, errors.Is(err, ErrCorruptJournal), true)
+
+	_, _, err = OpenJournal(dir, "SOMETIMES", 0)
+	assert(t, err == nil, false)
+}
+
+func TestJournalDamagedLastSegment(t *testing.T) {
+	dir := t.TempDir()
+
+	journal, _, err := OpenJournal(dir, SyncNever, 0)
+	assert(t, err, nil)
+	e := New("ETH-USDC", WithJournal(journal))
+	e.Submit(limitOrder(true, 90, 1, 1))
+	e.Submit(limitOrder(true, 91, 1, 1))
+	e.Stop()
+	journal.Close()
+
+	data, err := os.ReadFile(segmentPath(dir, 0))
+	assert(t, err, nil)
+
+	// a damaged length runs past the end of the segment like a partial entry,
+	// but the entry after it is still there and must not be dropped
+	damaged := append([]byte{}, data...)
+	damaged[0] = 0xff
+	assert(t, os.WriteFile(segmentPath(dir, 0), damaged, 0o644), nil)
+
+	_, _, err = OpenJournal(dir, SyncNever, 0)
+	assert(t, errors.Is(err, ErrCorruptJournal), true)
+
+	// a complete last entry with a bad checksum is damage too
+	damaged = append([]byte{}, data...)
+	damaged[len(damaged)-1] ^= 0xff
+	assert(t, os.WriteFile(segmentPath(dir, 0), damaged, 0o644), nil)
+
+	_, _, err = OpenJournal(dir, SyncNever, 0)
+	assert(t, errors.Is(err, ErrCorruptJournal), true)
+
+	// only the start of an entry cut off by the end of the file is dropped
+	assert(t, os.WriteFile(segmentPath(dir, 0), data[:len(data)-1], 0o644), nil)
+
+	_, entries, err := OpenJournal(dir, SyncNever, 0)
+	assert(t, err, nil)
+	assert(t, len(entries), 1)
+}
+
+func TestReplayDiverged(t *testing.T) {
+	e := New("ETH-USDC")
+	defer e.Stop()
+
+	cmd := limitOrder(true, 90, 1, 1)
+	cmd.Sequence = 2
+	_, err := e.Replay(&Entry{Market: "ETH-USDC", Command: cmd})
+	assert(t, errors.Is(err, ErrReplayDiverged), true)
+
+	cmd.Sequence = 1
+	_, err = e.Replay(&Entry{Market: "ETH-USDC", Command: cmd})
+	assert(t, errors.Is(err, ErrReplayDiverged), true)
+}
@@ -0,0 +1,39 @@
+package engine
+
+import "github.com/PanGan21/crypto-exchange-poc/orderbook"
+
+// Option configures an Engine on creation
+type Option func(*Engine)
+
+// WithEvents makes the engine send its events to events in order.
+// A full channel blocks the engine until it is drained, without it the events are dropped.
+func WithEvents(events chan<- Event) Option {
+	return func(e *Engine) {
+		e.events = events
+	}
+}
+
+// WithJournal makes the engine append its commands to journal before their result is returned,
+// without it they are kept in memory only
+func WithJournal(journal *Journal) Option {
+	return func(e *Engine) {
+		e.journal = journal
+	}
+}
+
+// WithBookOptions creates the book of the engine with opts
+func WithBookOptions(opts ...orderbook.Option) Option {
+	return func(e *Engine) {
+		e.bookOpts = append(e.bookOpts, opts...)
+	}
+}
+
+// WithApplied makes the engine call f with every command it applied or replayed and its result.
+// f runs on the engine goroutine before a checkpoint of the journal can start, so a snapshot
+// sees what f did for every command it covers. The engines sharing a journal call their f
+// concurrently. f must not submit commands.
+func WithApplied(f func(cmd *Command, res Result)) Option {
+	return func(e *Engine) {
+		e.applied = f
+	}
+}
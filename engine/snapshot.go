@@ -0,0 +1,216 @@
+package engine
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	segmentExt  = ".journal"
+	snapshotExt = ".snapshot"
+)
+
+// Checkpoint runs f while no command is applied, with the first segment of the journal
+// holding the commands applied after f. Saving the state copied by f with SaveSnapshot
+// at that segment makes the segments before it unnecessary.
+// f holds every engine of the journal and should only copy the state it needs.
+func (j *Journal) Checkpoint(f func(segment uint64) error) error {
+	j.apply.Lock()
+	defer j.apply.Unlock()
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	// entries appended outside of the engines and Update may still be waiting
+	if err := j.flush(); err != nil {
+		return err
+	}
+
+	if j.size > 0 {
+		if err := j.rotate(); err != nil {
+			return err
+		}
+	}
+
+	return f(j.segment)
+}
+
+// rotate closes the segment being written and starts the next one
+func (j *Journal) rotate() error {
+	if err := j.f.Sync(); err != nil {
+		j.err = err
+		return err
+	}
+
+	f, err := os.OpenFile(segmentPath(j.dir, j.segment+1), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	if err := syncDir(j.dir); err != nil {
+		f.Close()
+		return err
+	}
+
+	j.f.Close()
+	j.f = f
+	j.segment++
+	j.size = 0
+
+	return nil
+}
+
+// SaveSnapshot writes v as the state at the start of segment, then removes
+// the older snapshots and the segments before segment.
+// It must not be called concurrently.
+func (j *Journal) SaveSnapshot(segment uint64, v any) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	// a crash while the snapshot is written leaves the previous one in place
+	path := snapshotPath(j.dir, segment)
+	tmp := path + ".tmp"
+
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(newFrame(payload)); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	if err := syncDir(j.dir); err != nil {
+		return err
+	}
+
+	return j.compact(segment)
+}
+
+// compact removes the snapshots and segments before segment
+func (j *Journal) compact(segment uint64) error {
+	for _, ext := range []string{snapshotExt, segmentExt} {
+		numbers, err := listFiles(j.dir, ext, 0)
+		if err != nil {
+			return err
+		}
+
+		for _, n := range numbers {
+			if n >= segment {
+				break
+			}
+			if err := os.Remove(filepath.Join(j.dir, fileName(n, ext))); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// LoadSnapshot decodes the latest snapshot of the journal in dir into v and returns
+// the segment it was taken at, ok is false when there is no snapshot
+func LoadSnapshot(dir string, v any) (segment uint64, ok bool, err error) {
+	snapshots, err := listFiles(dir, snapshotExt, 0)
+	if err != nil || len(snapshots) == 0 {
+		return 0, false, err
+	}
+
+	segment = snapshots[len(snapshots)-1]
+	path := snapshotPath(dir, segment)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, false, err
+	}
+
+	if len(data) < headerSize ||
+		int(binary.BigEndian.Uint32(data[0:4])) != len(data)-headerSize ||
+		crc32.Checksum(data[headerSize:], crcTable) != binary.BigEndian.Uint32(data[4:8]) {
+		return 0, false, fmt.Errorf("%w: bad snapshot %s", ErrCorruptJournal, path)
+	}
+
+	if err := json.Unmarshal(data[headerSize:], v); err != nil {
+		return 0, false, fmt.Errorf("%w: snapshot %s: %v", ErrCorruptJournal, path, err)
+	}
+
+	return segment, true, nil
+}
+
+// newFrame prefixes payload with its length and checksum
+func newFrame(payload []byte) []byte {
+	frame := make([]byte, headerSize+len(payload))
+	binary.BigEndian.PutUint32(frame[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(frame[4:8], crc32.Checksum(payload, crcTable))
+	copy(frame[headerSize:], payload)
+	return frame
+}
+
+func fileName(n uint64, ext string) string {
+	return fmt.Sprintf("%020d%s", n, ext)
+}
+
+func segmentPath(dir string, segment uint64) string {
+	return filepath.Join(dir, fileName(segment, segmentExt))
+}
+
+func snapshotPath(dir string, segment uint64) string {
+	return filepath.Join(dir, fileName(segment, snapshotExt))
+}
+
+// listFiles returns the numbers from from on of the files of dir with the extension ext, in order
+func listFiles(dir, ext string, from uint64) ([]uint64, error) {
+	files, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	numbers := []uint64{}
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), ext) {
+			continue
+		}
+		n, err := strconv.ParseUint(strings.TrimSuffix(file.Name(), ext), 10, 64)
+		if err != nil || n < from {
+			continue
+		}
+		numbers = append(numbers, n)
+	}
+
+	sort.Slice(numbers, func(a, b int) bool { return numbers[a] < numbers[b] })
+
+	return numbers, nil
+}
+
+// syncDir flushes the creation, renaming and removal of the files of dir
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
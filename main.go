@@ -3,15 +3,18 @@ package main
 import (
 	"fmt"
 	"log"
-	"math"
 	"time"
 
 	"github.com/PanGan21/crypto-exchange-poc/client"
+	"github.com/PanGan21/crypto-exchange-poc/decimal"
+	"github.com/PanGan21/crypto-exchange-poc/orderbook"
 	"github.com/PanGan21/crypto-exchange-poc/server"
 )
 
 const (
 	maxOrders = 3
+
+	market server.Market = "ETH-USDC"
 )
 
 var (
@@ -21,19 +24,20 @@ var (
 func marketOrderPlacer(c *client.Client) {
 	ticker := time.NewTicker(5 * time.Second)
 	for {
-		trades, err := c.GetTrades("ETH")
+		trades, err := c.GetTrades(market)
 		if err != nil {
 			panic(err)
 		}
 
 		if len(trades) > 0 {
-			fmt.Printf("exchange price => %2.f\n", trades[len(trades)-1].Price)
+			fmt.Printf("exchange price => %s\n", trades[len(trades)-1].Price)
 		}
 
 		orderMarketSellOrder := &client.PlaceOrderParams{
+			Market: market,
 			UserId: 5,
 			Bid:    false,
-			Size:   1000,
+			Size:   decimal.NewFromInt(1000),
 		}
 		_, err = c.PlaceMarketOrder(orderMarketSellOrder)
 		if err != nil {
@@ -41,9 +45,10 @@ func marketOrderPlacer(c *client.Client) {
 		}
 
 		marketSellOrder := &client.PlaceOrderParams{
+			Market: market,
 			UserId: 6,
 			Bid:    false,
-			Size:   100,
+			Size:   decimal.NewFromInt(100),
 		}
 
 		_, err = c.PlaceMarketOrder(marketSellOrder)
@@ -52,9 +57,10 @@ func marketOrderPlacer(c *client.Client) {
 		}
 
 		marketBuyOrder := &client.PlaceOrderParams{
+			Market: market,
 			UserId: 6,
 			Bid:    true,
-			Size:   100,
+			Size:   decimal.NewFromInt(100),
 		}
 
 		_, err = c.PlaceMarketOrder(marketBuyOrder)
@@ -75,25 +81,28 @@ func makeMarketSimple(c *client.Client) {
 			log.Println(err)
 		}
 
-		bestAsk, err := c.GetBestAsk()
+		bestAsk, err := c.GetBestAsk(market)
 		if err != nil {
 			log.Println(err)
 		}
 
-		bestBid, err := c.GetBestBid()
+		bestBid, err := c.GetBestBid(market)
 		if err != nil {
 			log.Println(err)
 		}
 
-		spread := math.Abs(bestBid - bestAsk)
+		spread := bestBid.Sub(bestAsk).Abs()
 		fmt.Println("exchange spread", spread)
 
 		if len(orders.Bids) < maxOrders {
 			bidLimit := &client.PlaceOrderParams{
+				Market: market,
 				UserId: 7,
 				Bid:    true,
-				Price:  bestBid + 100,
-				Size:   1000,
+				Price:  bestBid.Add(decimal.NewFromInt(100)),
+				Size:   decimal.NewFromInt(1000),
+				// never cross the spread, stay one tick below the best ask instead
+				PostOnly: orderbook.PostOnlySlide,
 			}
 
 			_, err := c.PlaceLimitOrder(bidLimit)
@@ -104,10 +113,13 @@ func makeMarketSimple(c *client.Client) {
 
 		if len(orders.Asks) < maxOrders {
 			askLimit := &client.PlaceOrderParams{
+				Market: market,
 				UserId: 7,
 				Bid:    false,
-				Price:  bestAsk - 100,
-				Size:   1000,
+				Price:  bestAsk.Sub(decimal.NewFromInt(100)),
+				Size:   decimal.NewFromInt(1000),
+				// never cross the spread, stay one tick above the best bid instead
+				PostOnly: orderbook.PostOnlySlide,
 			}
 
 			_, err := c.PlaceLimitOrder(askLimit)
@@ -128,17 +140,19 @@ const userId = 5
 
 func seedMarket(c *client.Client) error {
 	ask := &client.PlaceOrderParams{
+		Market: market,
 		UserId: userId,
 		Bid:    false,
-		Price:  10_000,
-		Size:   1_000,
+		Price:  decimal.NewFromInt(10_000),
+		Size:   decimal.NewFromInt(1_000),
 	}
 
 	bid := &client.PlaceOrderParams{
+		Market: market,
 		UserId: userId,
 		Bid:    true,
-		Price:  9_000,
-		Size:   10_000,
+		Price:  decimal.NewFromInt(9_000),
+		Size:   decimal.NewFromInt(10_000),
 	}
 
 	_, err := c.PlaceLimitOrder(ask)
@@ -165,6 +179,11 @@ func main() {
 		panic(err)
 	}
 
+	// user 5 quotes both sides and also sends market orders, never let it trade with itself
+	if err := pocClient.SetSelfTradePrevention(userId, orderbook.SelfTradeCancelNewest); err != nil {
+		panic(err)
+	}
+
 	go makeMarketSimple(pocClient)
 
 	time.Sleep(1 * time.Second)
@@ -0,0 +1,100 @@
+package orderbook
+
+import (
+	"errors"
+
+	"github.com/PanGan21/crypto-exchange-poc/decimal"
+)
+
+var (
+	ErrOrderNotFound    = errors.New("order not found")
+	ErrNotOrderOwner    = errors.New("order belongs to another user")
+	ErrInvalidAmendment = errors.New("amended price and size must be positive")
+)
+
+// Amendment describes a change made to a resting order
+type Amendment struct {
+	OrderId      int64
+	UserId       int64
+	OldPrice     decimal.Decimal
+	NewPrice     decimal.Decimal
+	OldSize      decimal.Decimal
+	NewSize      decimal.Decimal
+	PriorityKept bool // false when the order was requeued at the back of its level
+	Timestamp    int64
+}
+
+// ModifyOrder changes the price and the remaining size of a resting order of userId.
+// Reducing the size at the same price keeps the order's place in the queue,
+// while a new price or a bigger size requeues it at the back of its level
+// as if it was a new order, matching it first when the new price crosses the book.
+func (ob *Orderbook) ModifyOrder(orderId, userId int64, price, size decimal.Decimal) (*Amendment, []Match, error) {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+
+	o, ok := ob.orders[orderId]
+	if !ok || o.Limit == nil {
+		return nil, nil, ErrOrderNotFound
+	}
+	if o.UserId != userId {
+		return nil, nil, ErrNotOrderOwner
+	}
+	if !size.IsPositive() || !price.IsPositive() {
+		return nil, nil, ErrInvalidAmendment
+	}
+
+	// pegged orders keep the price of their peg
+	if o.Peg != "" {
+		price = o.Price
+	}
+
+	if o.PostOnly != "" && !price.Equal(o.Price) {
+		var err error
+		if price, err = ob.postOnlyPrice(price, o); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	amendment := &Amendment{
+		OrderId:   o.Id,
+		UserId:    o.UserId,
+		OldPrice:  o.Price,
+		NewPrice:  price,
+		OldSize:   o.TotalRemaining(),
+		NewSize:   size,
+		Timestamp: ob.now(),
+	}
+
+	if price.Equal(o.Price) && size.LessThanOrEqual(o.TotalRemaining()) {
+		o.Limit.reduce(o, o.TotalRemaining().Sub(size))
+		amendment.PriorityKept = true
+		return amendment, []Match{}, nil
+	}
+
+	if err := ob.checkVolume(o.Bid, size.Sub(o.TotalRemaining())); err != nil {
+		return nil, nil, err
+	}
+
+	ob.cancelOrder(o)
+
+	o.Price = price
+	o.Size = size
+	o.Reserve = decimal.Zero
+
+	matches := ob.placeLimitOrder(o)
+
+	return amendment, ob.cascade(matches), nil
+}
+
+// reduce takes quantity out of a queued order without changing its position,
+// iceberg orders give up their hidden reserve first
+func (l *Limit) reduce(o *Order, quantity decimal.Decimal) {
+	fromReserve := decimal.Min(quantity, o.Reserve)
+	o.Reserve = o.Reserve.Sub(fromReserve)
+	l.reserveVolume = l.reserveVolume.Sub(fromReserve)
+
+	fromSize := quantity.Sub(fromReserve)
+	o.Size = o.Size.Sub(fromSize)
+	l.TotalVolume = l.TotalVolume.Sub(fromSize)
+	l.subSideVolume(quantity)
+}
@@ -0,0 +1,90 @@
+package orderbook
+
+import (
+	"testing"
+
+	"github.com/PanGan21/crypto-exchange-poc/decimal"
+)
+
+func TestModifyOrderReduceKeepsPriority(t *testing.T) {
+	ob := NewOrderbook()
+	price := decimal.NewFromInt(10_000)
+
+	sellOrderA := NewOrder(false, decimal.NewFromInt(5), 1)
+	sellOrderB := NewOrder(false, decimal.NewFromInt(5), 2)
+	ob.PlaceLimitOrder(price, sellOrderA)
+	ob.PlaceLimitOrder(price, sellOrderB)
+	sequence := sellOrderA.Sequence
+
+	amendment, matches, err := ob.ModifyOrder(sellOrderA.Id, 1, price, decimal.NewFromInt(3))
+
+	assert(t, err, nil)
+	assert(t, len(matches), 0)
+	assert(t, amendment.PriorityKept, true)
+	assert(t, amendment.OldSize, decimal.NewFromInt(5))
+	assert(t, amendment.NewSize, decimal.NewFromInt(3))
+	assert(t, sellOrderA.Size, decimal.NewFromInt(3))
+	assert(t, sellOrderA.Sequence, sequence)
+	assert(t, ob.askLimits[price].Orders(), []*Order{sellOrderA, sellOrderB})
+	assert(t, ob.AskTotalVolume(), decimal.NewFromInt(8))
+}
+
+func TestModifyOrderIncreaseLosesPriority(t *testing.T) {
+	ob := NewOrderbook()
+	price := decimal.NewFromInt(10_000)
+
+	sellOrderA := NewOrder(false, decimal.NewFromInt(5), 1)
+	sellOrderB := NewOrder(false, decimal.NewFromInt(5), 2)
+	ob.PlaceLimitOrder(price, sellOrderA)
+	ob.PlaceLimitOrder(price, sellOrderB)
+	id := sellOrderA.Id
+
+	amendment, _, err := ob.ModifyOrder(sellOrderA.Id, 1, price, decimal.NewFromInt(7))
+
+	assert(t, err, nil)
+	assert(t, amendment.PriorityKept, false)
+	assert(t, sellOrderA.Id, id)
+	assert(t, sellOrderA.Sequence > sellOrderB.Sequence, true)
+	assert(t, ob.askLimits[price].Orders(), []*Order{sellOrderB, sellOrderA})
+	assert(t, ob.AskTotalVolume(), decimal.NewFromInt(12))
+}
+
+func TestModifyOrderPriceCrossesBook(t *testing.T) {
+	ob := NewOrderbook()
+
+	ob.PlaceLimitOrder(decimal.NewFromInt(9_000), NewOrder(true, decimal.NewFromInt(2), 1))
+	sellOrder := NewOrder(false, decimal.NewFromInt(5), 2)
+	ob.PlaceLimitOrder(decimal.NewFromInt(10_000), sellOrder)
+
+	amendment, matches, err := ob.ModifyOrder(sellOrder.Id, 2, decimal.NewFromInt(8_500), decimal.NewFromInt(5))
+
+	assert(t, err, nil)
+	assert(t, amendment.OldPrice, decimal.NewFromInt(10_000))
+	assert(t, amendment.NewPrice, decimal.NewFromInt(8_500))
+	assert(t, len(matches), 1)
+	assert(t, matches[0].Price, decimal.NewFromInt(9_000))
+	assert(t, sellOrder.Size, decimal.NewFromInt(3))
+	assert(t, ob.BestAsk().Price, decimal.NewFromInt(8_500))
+	assert(t, ob.bids.len(), 0)
+	_, ok := ob.askLimits[decimal.NewFromInt(10_000)]
+	assert(t, ok, false)
+}
+
+func TestModifyOrderValidation(t *testing.T) {
+	ob := NewOrderbook()
+	price := decimal.NewFromInt(10_000)
+
+	sellOrder := NewOrder(false, decimal.NewFromInt(5), 1)
+	ob.PlaceLimitOrder(price, sellOrder)
+
+	_, _, err := ob.ModifyOrder(sellOrder.Id, 2, price, decimal.NewFromInt(1))
+	assert(t, err, ErrNotOrderOwner)
+
+	_, _, err = ob.ModifyOrder(sellOrder.Id+1, 1, price, decimal.NewFromInt(1))
+	assert(t, err, ErrOrderNotFound)
+
+	_, _, err = ob.ModifyOrder(sellOrder.Id, 1, price, decimal.Zero)
+	assert(t, err, ErrInvalidAmendment)
+
+	assert(t, sellOrder.Size, decimal.NewFromInt(5))
+}
@@ -0,0 +1,132 @@
+package orderbook
+
+import (
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/PanGan21/crypto-exchange-poc/decimal"
+)
+
+// run with go test -race to catch unsynchronized access to the book
+
+func TestConcurrentPlacementAndCancellation(t *testing.T) {
+	const (
+		workers = 8
+		orders  = 200
+	)
+
+	ob := NewOrderbook()
+	var wg sync.WaitGroup
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(int64(w)))
+
+			for i := 0; i < orders; i++ {
+				bid := r.Intn(2) == 0
+				size := decimal.NewFromInt(int64(r.Intn(5) + 1))
+
+				switch r.Intn(4) {
+				case 0:
+					ob.PlaceMarketOrder(NewOrder(bid, size, int64(w)))
+				case 1:
+					o := NewOrder(bid, size, int64(w))
+					ob.PlaceLimitOrder(decimal.NewFromInt(int64(95+r.Intn(10))), o)
+					ob.CancelOrder(o.Id)
+				default:
+					ob.PlaceLimitOrder(decimal.NewFromInt(int64(95+r.Intn(10))), NewOrder(bid, size, int64(w)))
+				}
+			}
+		}(w)
+	}
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for i := 0; i < orders; i++ {
+				ob.Asks()
+				ob.Bids()
+				ob.BestAsk()
+				ob.BestBid()
+				ob.BidTotalVolume()
+				ob.AskTotalVolume()
+				ob.Trades()
+				ob.LastPrice()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	// the book is left consistent: volumes add up and it is not crossed
+	assertLevelsConsistent(t, ob.Asks(), ob.AskTotalVolume())
+	assertLevelsConsistent(t, ob.Bids(), ob.BidTotalVolume())
+
+	bestAsk, bestBid := ob.BestAsk(), ob.BestBid()
+	if bestAsk != nil && bestBid != nil && bestBid.Price.GreaterThanOrEqual(bestAsk.Price) {
+		t.Errorf("crossed book: best bid %s >= best ask %s", bestBid.Price, bestAsk.Price)
+	}
+
+	trades := ob.Trades()
+	for i, trade := range trades {
+		assert(t, trade.Sequence, uint64(i+1))
+	}
+}
+
+func assertLevelsConsistent(t *testing.T, levels []*Level, totalVolume decimal.Decimal) {
+	sum := decimal.Zero
+	for _, level := range levels {
+		levelVolume := decimal.Zero
+		for _, o := range level.Orders {
+			levelVolume = levelVolume.Add(o.Size)
+		}
+		assert(t, levelVolume, level.TotalVolume)
+		sum = sum.Add(level.TotalVolume)
+	}
+	assert(t, sum, totalVolume)
+}
+
+func TestConcurrentStopsAndExpiry(t *testing.T) {
+	ob := NewOrderbook()
+	ob.PlaceLimitOrder(decimal.NewFromInt(100), NewOrder(false, decimal.NewFromInt(1_000), 0))
+	ob.PlaceLimitOrder(decimal.NewFromInt(90), NewOrder(true, decimal.NewFromInt(1_000), 0))
+
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(3)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				stopOrder := NewOrder(w%2 == 0, decimal.NewFromInt(1), int64(w))
+				stopOrder.StopPrice = decimal.NewFromInt(int64(91 + i%9))
+				if ob.PlaceStopOrder(stopOrder) == nil && i%3 == 0 {
+					ob.CancelOrder(stopOrder.Id)
+				}
+			}
+		}(w)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				ob.PlaceMarketOrder(NewOrder(i%2 == 0, decimal.NewFromInt(1), int64(w)))
+				ob.StopOrders(true)
+				ob.StopOrders(false)
+			}
+		}(w)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				ob.ExpireOrders(0)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, o := range ob.StopOrders(true) {
+		assert(t, o.IsStopPending(), true)
+	}
+}
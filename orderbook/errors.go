@@ -0,0 +1,28 @@
+package orderbook
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/PanGan21/crypto-exchange-poc/decimal"
+)
+
+var (
+	ErrInvalidExpiry      = errors.New("GTD order must expire in the future")
+	ErrInvalidDisplaySize = errors.New("display size can't be negative")
+	// ErrNotionalOverflow stops the matching of an order whose price * size no longer fits in a decimal
+	ErrNotionalOverflow = errors.New("notional of the order is too large")
+	// ErrVolumeOverflow rejects an order that would take the volume of its side of the book past what a decimal holds
+	ErrVolumeOverflow = errors.New("volume of the book is too large for the order")
+)
+
+// InsufficientVolumeError is returned when an order can't be executed
+// because the book does not have enough volume on the opposite side
+type InsufficientVolumeError struct {
+	Requested decimal.Decimal
+	Available decimal.Decimal
+}
+
+func (e *InsufficientVolumeError) Error() string {
+	return fmt.Sprintf("not enough volume [size: %s] for order [size: %s]", e.Available, e.Requested)
+}
@@ -0,0 +1,259 @@
+package orderbook
+
+import (
+	"errors"
+
+	"github.com/PanGan21/crypto-exchange-poc/decimal"
+)
+
+// GroupType is the kind of link between the orders of a group
+type GroupType string
+
+const (
+	// GroupOCO links a take profit and a stop loss, the first one to trade or trigger cancels the other
+	GroupOCO GroupType = "OCO"
+	// GroupBracket places an OCO pair of exits on the opposite side once its entry order is filled
+	GroupBracket GroupType = "BRACKET"
+)
+
+type GroupStatus string
+
+const (
+	GroupPending   GroupStatus = "PENDING"   // bracket waiting for its entry to fill
+	GroupActive    GroupStatus = "ACTIVE"    // both exits are working
+	GroupDone      GroupStatus = "DONE"      // an exit traded or triggered and the other one was cancelled
+	GroupCancelled GroupStatus = "CANCELLED" // the entry did not fill or an exit was cancelled
+)
+
+var ErrInvalidGroup = errors.New("group exits must be a GTC limit and a stop order of the same user and side")
+
+// OrderGroup links the exits of a position so that only one of them executes
+type OrderGroup struct {
+	Id         int64
+	Type       GroupType
+	Status     GroupStatus
+	Entry      *Order // Bracket entry, nil for OCO groups
+	TakeProfit *Order // Limit exit
+	StopLoss   *Order // Stop market or stop limit exit
+	// EntryFilled is the size filled of a bracket entry, its exits are placed for this size
+	EntryFilled decimal.Decimal
+}
+
+func (g *OrderGroup) IsOpen() bool {
+	return g.Status == GroupPending || g.Status == GroupActive
+}
+
+// PlaceOCO places takeProfit as a limit order at takeProfit.Price and stopLoss as a stop order.
+// The first of them to trade or trigger cancels the other, cancelling one cancels both.
+func (ob *Orderbook) PlaceOCO(takeProfit, stopLoss *Order) (*OrderGroup, []Match, error) {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+
+	if !validExits(takeProfit, stopLoss) || !takeProfit.Size.Equal(stopLoss.Size) {
+		return nil, nil, rejectAll(ErrInvalidGroup, takeProfit, stopLoss)
+	}
+
+	matches, err := ob.submitLimitOrder(takeProfit.Price, takeProfit)
+	if err != nil {
+		return nil, nil, rejectAll(err, takeProfit, stopLoss)
+	}
+
+	stopLoss.Id = ob.nextOrderId()
+	stopLoss.Triggered = false
+	ob.addStop(stopLoss)
+
+	g := &OrderGroup{
+		Id:         ob.nextGroupId(),
+		Type:       GroupOCO,
+		Status:     GroupActive,
+		TakeProfit: takeProfit,
+		StopLoss:   stopLoss,
+	}
+	ob.addGroup(g)
+
+	return g, ob.cascade(matches), nil
+}
+
+// PlaceBracket places entry as a limit order at entry.Price, or as a market order when it has no price.
+// Once the entry is filled, or what is left of it is cancelled by its time in force,
+// takeProfit and stopLoss are placed as an OCO pair for the filled size.
+// The same happens when the entry is cancelled or expires while resting in the book,
+// unless it filled nothing and the bracket is cancelled.
+func (ob *Orderbook) PlaceBracket(entry, takeProfit, stopLoss *Order) (*OrderGroup, []Match, error) {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+
+	if !validExits(takeProfit, stopLoss) || entry.UserId != takeProfit.UserId || entry.Bid == takeProfit.Bid {
+		return nil, nil, rejectAll(ErrInvalidGroup, entry, takeProfit, stopLoss)
+	}
+
+	var (
+		matches []Match
+		err     error
+	)
+
+	if entry.Price.IsPositive() {
+		matches, err = ob.submitLimitOrder(entry.Price, entry)
+	} else {
+		entry.Id = ob.nextOrderId()
+		matches, err = ob.placeMarketOrder(entry)
+	}
+	if err != nil {
+		return nil, nil, rejectAll(err, entry, takeProfit, stopLoss)
+	}
+
+	g := &OrderGroup{
+		Id:         ob.nextGroupId(),
+		Type:       GroupBracket,
+		Status:     GroupPending,
+		Entry:      entry,
+		TakeProfit: takeProfit,
+		StopLoss:   stopLoss,
+	}
+	ob.addGroup(g)
+
+	return g, ob.cascade(matches), nil
+}
+
+func validExits(takeProfit, stopLoss *Order) bool {
+	return takeProfit.Price.IsPositive() &&
+		takeProfit.StopPrice.IsZero() &&
+		(takeProfit.TimeInForce == "" || takeProfit.TimeInForce == GTC) &&
+		takeProfit.PostOnly == "" &&
+		stopLoss.StopPrice.IsPositive() &&
+		takeProfit.UserId == stopLoss.UserId &&
+		takeProfit.Bid == stopLoss.Bid
+}
+
+func (ob *Orderbook) addGroup(g *OrderGroup) {
+	for _, o := range []*Order{g.Entry, g.TakeProfit, g.StopLoss} {
+		if o != nil {
+			o.group = g
+		}
+	}
+	ob.groups = append(ob.groups, g)
+}
+
+// settleGroups activates the exits of the brackets whose entry is done and cancels
+// the exit left over when the other one traded or triggered.
+// Activated exits can trade and trigger stops, so it keeps going until nothing changes.
+func (ob *Orderbook) settleGroups(matches []Match) []Match {
+	settled := []Match{}
+
+	for {
+		filled := filledSizes(matches)
+
+		matches = []Match{}
+		for _, g := range ob.groups {
+			matches = append(matches, ob.settleGroup(g, filled)...)
+		}
+		ob.dropClosedGroups()
+
+		if len(matches) == 0 {
+			return settled
+		}
+
+		matches = append(matches, ob.triggerStops()...)
+		settled = append(settled, matches...)
+	}
+}
+
+func (ob *Orderbook) settleGroup(g *OrderGroup, filled map[*Order]decimal.Decimal) []Match {
+	switch g.Status {
+	case GroupPending:
+		g.EntryFilled = g.EntryFilled.Add(filled[g.Entry])
+		if g.Entry.working() {
+			return nil
+		}
+		if !g.EntryFilled.IsPositive() {
+			// the exits were never placed, they are closed with the group
+			ob.cancelExits(g)
+			return nil
+		}
+		return ob.activateExits(g)
+
+	case GroupActive:
+		takeProfitExecuted := filled[g.TakeProfit].IsPositive()
+		stopLossExecuted := g.StopLoss.Triggered
+
+		switch {
+		case takeProfitExecuted || stopLossExecuted:
+			if !takeProfitExecuted {
+				ob.closeOrder(g.TakeProfit, StatusCancelled)
+			}
+			if !stopLossExecuted {
+				ob.closeOrder(g.StopLoss, StatusCancelled)
+			}
+			g.Status = GroupDone
+		case !g.TakeProfit.working() || !g.StopLoss.working():
+			ob.cancelExits(g)
+		}
+	}
+
+	return nil
+}
+
+// activateExits places the exits of a bracket for the size its entry filled
+func (ob *Orderbook) activateExits(g *OrderGroup) []Match {
+	g.Status = GroupActive
+	g.TakeProfit.Size = g.EntryFilled
+	g.StopLoss.Size = g.EntryFilled
+
+	g.StopLoss.Id = ob.nextOrderId()
+	g.StopLoss.Triggered = false
+	ob.addStop(g.StopLoss)
+
+	g.TakeProfit.Id = ob.nextOrderId()
+	return ob.placeLimitOrder(g.TakeProfit)
+}
+
+// cancelGroup cancels the group of o after o was cancelled and returns the matches of the exits it placed.
+// Cancelling one of the exits or the entry of a bracket that filled nothing cancels the whole group,
+// the exits of an entry that partly filled are placed for the size it filled.
+func (ob *Orderbook) cancelGroup(o *Order) []Match {
+	g := o.group
+	if g == nil || !g.IsOpen() || o == g.Entry && g.Status != GroupPending {
+		return nil
+	}
+
+	if o == g.Entry && g.EntryFilled.IsPositive() {
+		return ob.activateExits(g)
+	}
+
+	ob.cancelExits(g)
+	ob.dropClosedGroups()
+
+	return nil
+}
+
+// cancelExits cancels g and both of its exits, placed or not
+func (ob *Orderbook) cancelExits(g *OrderGroup) {
+	ob.closeOrder(g.TakeProfit, StatusCancelled)
+	ob.closeOrder(g.StopLoss, StatusCancelled)
+	g.Status = GroupCancelled
+}
+
+func (ob *Orderbook) dropClosedGroups() {
+	open := ob.groups[:0]
+	for _, g := range ob.groups {
+		if g.IsOpen() {
+			open = append(open, g)
+		}
+	}
+	ob.groups = open
+}
+
+// working reports whether o is resting in the book or waiting for its trigger
+func (o *Order) working() bool {
+	return o.Limit != nil || o.trigger != nil
+}
+
+// filledSizes sums the size filled by every order taking part in matches
+func filledSizes(matches []Match) map[*Order]decimal.Decimal {
+	filled := make(map[*Order]decimal.Decimal)
+	for _, match := range matches {
+		filled[match.Ask] = filled[match.Ask].Add(match.SizeFilled)
+		filled[match.Bid] = filled[match.Bid].Add(match.SizeFilled)
+	}
+	return filled
+}
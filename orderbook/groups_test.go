@@ -0,0 +1,196 @@
+package orderbook
+
+import (
+	"testing"
+	"time"
+
+	"github.com/PanGan21/crypto-exchange-poc/decimal"
+)
+
+func newExits(bid bool, size decimal.Decimal, userId int64) (*Order, *Order) {
+	takeProfit := NewOrder(bid, size, userId)
+	takeProfit.Price = decimal.NewFromInt(11_000)
+	stopLoss := NewOrder(bid, size, userId)
+	stopLoss.StopPrice = decimal.NewFromInt(9_000)
+	return takeProfit, stopLoss
+}
+
+func TestOCOTakeProfitCancelsStopLoss(t *testing.T) {
+	ob := NewOrderbook()
+
+	takeProfit, stopLoss := newExits(false, decimal.NewFromInt(2), 1)
+	g, matches, err := ob.PlaceOCO(takeProfit, stopLoss)
+
+	assert(t, err, nil)
+	assert(t, len(matches), 0)
+	assert(t, g.Status, GroupActive)
+	assert(t, ob.AskTotalVolume(), decimal.NewFromInt(2))
+	assert(t, ob.StopOrders(false), []*Order{ob.Snapshot(stopLoss)})
+
+	matches, err = ob.PlaceMarketOrder(NewOrder(true, decimal.NewFromInt(1), 2))
+
+	assert(t, err, nil)
+	assert(t, len(matches), 1)
+	assert(t, g.Status, GroupDone)
+	assert(t, ob.StopOrders(false), []*Order{})
+	// the rest of the take profit keeps working
+	assert(t, takeProfit.Limit, ob.askLimits[decimal.NewFromInt(11_000)])
+	assert(t, len(ob.groups), 0)
+}
+
+func TestOCOStopLossCancelsTakeProfit(t *testing.T) {
+	ob := NewOrderbook()
+	ob.PlaceLimitOrder(decimal.NewFromInt(9_000), NewOrder(true, decimal.NewFromInt(5), 3))
+	ob.PlaceLimitOrder(decimal.NewFromInt(8_000), NewOrder(true, decimal.NewFromInt(5), 3))
+
+	takeProfit, stopLoss := newExits(false, decimal.NewFromInt(2), 1)
+	g, _, err := ob.PlaceOCO(takeProfit, stopLoss)
+	assert(t, err, nil)
+
+	matches, err := ob.PlaceMarketOrder(NewOrder(false, decimal.NewFromInt(1), 2))
+
+	assert(t, err, nil)
+	assert(t, len(matches), 2)
+	assert(t, matches[1].Ask, stopLoss)
+	assert(t, g.Status, GroupDone)
+	assert(t, takeProfit.Limit, (*Limit)(nil))
+	assert(t, ob.asks.len(), 0)
+
+	_, ok := ob.orders[takeProfit.Id]
+	assert(t, ok, false)
+}
+
+func TestBracketActivatesExitsOnEntryFill(t *testing.T) {
+	ob := NewOrderbook()
+
+	entry := NewOrder(true, decimal.NewFromInt(3), 1)
+	entry.Price = decimal.NewFromInt(10_000)
+	takeProfit, stopLoss := newExits(false, decimal.Zero, 1)
+	g, _, err := ob.PlaceBracket(entry, takeProfit, stopLoss)
+
+	assert(t, err, nil)
+	assert(t, g.Status, GroupPending)
+	assert(t, ob.BidTotalVolume(), decimal.NewFromInt(3))
+	assert(t, ob.StopOrders(false), []*Order{})
+
+	// a partial fill keeps the entry working
+	ob.PlaceMarketOrder(NewOrder(false, decimal.NewFromInt(1), 2))
+	assert(t, g.Status, GroupPending)
+	assert(t, g.EntryFilled, decimal.NewFromInt(1))
+
+	ob.PlaceMarketOrder(NewOrder(false, decimal.NewFromInt(2), 2))
+
+	assert(t, g.Status, GroupActive)
+	assert(t, g.EntryFilled, decimal.NewFromInt(3))
+	assert(t, takeProfit.Size, decimal.NewFromInt(3))
+	assert(t, ob.askLimits[decimal.NewFromInt(11_000)].Orders(), []*Order{takeProfit})
+	assert(t, ob.StopOrders(false), []*Order{ob.Snapshot(stopLoss)})
+	assert(t, stopLoss.Size, decimal.NewFromInt(3))
+}
+
+func TestBracketMarketEntryPartialFill(t *testing.T) {
+	ob := NewOrderbook()
+	ob.PlaceLimitOrder(decimal.NewFromInt(10_000), NewOrder(false, decimal.NewFromInt(1), 2))
+
+	entry := NewOrder(true, decimal.NewFromInt(3), 1)
+	takeProfit, stopLoss := newExits(false, decimal.Zero, 1)
+	g, matches, err := ob.PlaceBracket(entry, takeProfit, stopLoss)
+
+	assert(t, err, nil)
+	assert(t, len(matches), 1)
+	assert(t, g.Status, GroupActive)
+	assert(t, takeProfit.Size, decimal.NewFromInt(1))
+	assert(t, stopLoss.Size, decimal.NewFromInt(1))
+}
+
+func TestCancelGroupOrder(t *testing.T) {
+	ob := NewOrderbook()
+
+	takeProfit, stopLoss := newExits(false, decimal.NewFromInt(2), 1)
+	g, _, _ := ob.PlaceOCO(takeProfit, stopLoss)
+	ob.CancelOrder(stopLoss.Id)
+
+	assert(t, g.Status, GroupCancelled)
+	assert(t, ob.asks.len(), 0)
+	assert(t, len(ob.orders), 0)
+
+	entry := NewOrder(true, decimal.NewFromInt(3), 1)
+	entry.Price = decimal.NewFromInt(10_000)
+	takeProfit, stopLoss = newExits(false, decimal.Zero, 1)
+	g, _, _ = ob.PlaceBracket(entry, takeProfit, stopLoss)
+	ob.CancelOrder(entry.Id)
+
+	assert(t, g.Status, GroupCancelled)
+	assert(t, ob.bids.len(), 0)
+	assert(t, len(ob.groups), 0)
+
+	_, _, err := ob.PlaceBracket(NewOrder(false, decimal.NewFromInt(1), 1), takeProfit, stopLoss)
+	assert(t, err, ErrInvalidGroup)
+}
+
+func TestCancelPartlyFilledBracketEntry(t *testing.T) {
+	ob := NewOrderbook()
+
+	entry := NewOrder(true, decimal.NewFromInt(10), 1)
+	entry.Price = decimal.NewFromInt(10_000)
+	takeProfit, stopLoss := newExits(false, decimal.Zero, 1)
+	g, _, _ := ob.PlaceBracket(entry, takeProfit, stopLoss)
+	ob.PlaceMarketOrder(NewOrder(false, decimal.NewFromInt(4), 2))
+
+	// the exits cover the size the entry filled before it was cancelled
+	matches, err := ob.CancelOrder(entry.Id)
+
+	assert(t, err, nil)
+	assert(t, len(matches), 0)
+	assert(t, entry.Status, StatusCancelled)
+	assert(t, g.Status, GroupActive)
+	assert(t, takeProfit.Size, decimal.NewFromInt(4))
+	assert(t, ob.askLimits[decimal.NewFromInt(11_000)].Orders(), []*Order{takeProfit})
+	assert(t, ob.StopOrders(false), []*Order{ob.Snapshot(stopLoss)})
+	assert(t, stopLoss.Size, decimal.NewFromInt(4))
+}
+
+func TestExpirePartlyFilledBracketEntry(t *testing.T) {
+	now := time.Now().UnixNano()
+	ob := NewOrderbook(WithClock(func() int64 { return now }))
+
+	entry := NewOrder(true, decimal.NewFromInt(10), 1)
+	entry.Price = decimal.NewFromInt(10_000)
+	entry.TimeInForce = GTD
+	entry.ExpiresAt = now + int64(time.Minute)
+	takeProfit, stopLoss := newExits(false, decimal.Zero, 1)
+	g, _, _ := ob.PlaceBracket(entry, takeProfit, stopLoss)
+	ob.PlaceMarketOrder(NewOrder(false, decimal.NewFromInt(4), 2))
+	// a bid above the take profit fills it as soon as it is placed
+	ob.PlaceLimitOrder(decimal.NewFromInt(11_500), NewOrder(true, decimal.NewFromInt(4), 3))
+
+	expired, matches := ob.ExpireOrders(now + int64(2*time.Minute))
+
+	assert(t, expired, []*Order{entry})
+	assert(t, len(matches), 1)
+	assert(t, matches[0].Ask, takeProfit)
+	assert(t, matches[0].SizeFilled, decimal.NewFromInt(4))
+	assert(t, g.Status, GroupDone)
+	assert(t, stopLoss.Status, StatusCancelled)
+	assert(t, ob.StopOrders(false), []*Order{})
+	assert(t, len(ob.groups), 0)
+}
+
+func TestBracketEntryFillsNothing(t *testing.T) {
+	ob := NewOrderbook()
+
+	entry := NewOrder(true, decimal.NewFromInt(3), 1)
+	entry.Price = decimal.NewFromInt(10_000)
+	entry.TimeInForce = IOC
+	takeProfit, stopLoss := newExits(false, decimal.Zero, 1)
+	g, matches, err := ob.PlaceBracket(entry, takeProfit, stopLoss)
+
+	// the exits that were never placed are closed with the group
+	assert(t, err, nil)
+	assert(t, len(matches), 0)
+	assert(t, g.Status, GroupCancelled)
+	assert(t, entry.Status, StatusCancelled)
+	assert(t, takeProfit.Status, StatusCancelled)
+	assert(t, stopLoss.Status, StatusCancelled)
+	assert(t, len(ob.groups), 0)
+}
@@ -0,0 +1,80 @@
+package orderbook
+
+import (
+	"testing"
+
+	"github.com/PanGan21/crypto-exchange-poc/decimal"
+)
+
+func TestIcebergOrderShowsDisplaySize(t *testing.T) {
+	ob := NewOrderbook()
+	price := decimal.NewFromInt(10_000)
+
+	iceberg := NewOrder(false, decimal.NewFromInt(10), 1)
+	iceberg.DisplaySize = decimal.NewFromInt(2)
+	_, err := ob.PlaceLimitOrder(price, iceberg)
+
+	assert(t, err, nil)
+	assert(t, iceberg.Size, decimal.NewFromInt(2))
+	assert(t, iceberg.Reserve, decimal.NewFromInt(8))
+	assert(t, iceberg.TotalRemaining(), decimal.NewFromInt(10))
+	assert(t, ob.AskTotalVolume(), decimal.NewFromInt(2))
+	assert(t, ob.askLimits[price].ExecutableVolume(), decimal.NewFromInt(10))
+}
+
+func TestIcebergOrderRefillLosesPriority(t *testing.T) {
+	ob := NewOrderbook()
+	price := decimal.NewFromInt(10_000)
+
+	iceberg := NewOrder(false, decimal.NewFromInt(5), 1)
+	iceberg.DisplaySize = decimal.NewFromInt(2)
+	ob.PlaceLimitOrder(price, iceberg)
+
+	sellOrder := NewOrder(false, decimal.NewFromInt(1), 2)
+	ob.PlaceLimitOrder(price, sellOrder)
+	sequence := iceberg.Sequence
+
+	matches, err := ob.PlaceMarketOrder(NewOrder(true, decimal.NewFromInt(3), 3))
+
+	assert(t, err, nil)
+	assert(t, len(matches), 2)
+	assert(t, matches[0].Ask, iceberg)
+	assert(t, matches[0].SizeFilled, decimal.NewFromInt(2))
+	// the refilled slice went behind the order that was queued after the iceberg
+	assert(t, matches[1].Ask, sellOrder)
+	assert(t, iceberg.Size, decimal.NewFromInt(2))
+	assert(t, iceberg.Reserve, decimal.NewFromInt(1))
+	assert(t, iceberg.Sequence > sequence, true)
+	assert(t, ob.askLimits[price].Orders(), []*Order{iceberg})
+	assert(t, ob.AskTotalVolume(), decimal.NewFromInt(2))
+
+	// the last refill only shows what is left in the reserve
+	matches, err = ob.PlaceMarketOrder(NewOrder(true, decimal.NewFromInt(2), 3))
+
+	assert(t, err, nil)
+	assert(t, len(matches), 1)
+	assert(t, iceberg.Size, decimal.NewFromInt(1))
+	assert(t, iceberg.Reserve, decimal.Zero)
+	assert(t, ob.AskTotalVolume(), decimal.NewFromInt(1))
+}
+
+func TestIcebergOrderHiddenVolumeIsExecutable(t *testing.T) {
+	ob := NewOrderbook()
+
+	iceberg := NewOrder(false, decimal.NewFromInt(10), 1)
+	iceberg.DisplaySize = decimal.NewFromInt(2)
+	ob.PlaceLimitOrder(decimal.NewFromInt(10_000), iceberg)
+
+	buyOrder := NewOrder(true, decimal.NewFromInt(9), 2)
+	buyOrder.TimeInForce = FOK
+	matches, err := ob.PlaceMarketOrder(buyOrder)
+
+	assert(t, err, nil)
+	assert(t, len(matches), 5)
+	assert(t, buyOrder.IsFilled(), true)
+	assert(t, iceberg.TotalRemaining(), decimal.NewFromInt(1))
+
+	ob.CancelOrder(iceberg.Id)
+	assert(t, ob.asks.len(), 0)
+	assert(t, ob.AskTotalVolume(), decimal.Zero)
+}
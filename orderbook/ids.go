@@ -0,0 +1,149 @@
+package orderbook
+
+import "sync/atomic"
+
+// IDGenerator hands out unique, monotonically increasing order, trade and group ids.
+// A single generator can be shared by all the books of an exchange so ids
+// stay unique across markets.
+type IDGenerator struct {
+	orderId atomic.Int64
+	tradeId atomic.Int64
+	groupId atomic.Int64
+}
+
+func NewIDGenerator() *IDGenerator {
+	return &IDGenerator{}
+}
+
+func (g *IDGenerator) NextOrderId() int64 {
+	return g.orderId.Add(1)
+}
+
+func (g *IDGenerator) NextTradeId() int64 {
+	return g.tradeId.Add(1)
+}
+
+func (g *IDGenerator) NextGroupId() int64 {
+	return g.groupId.Add(1)
+}
+
+// IDs are the last ids handed out by an IDGenerator
+type IDs struct {
+	OrderId int64
+	TradeId int64
+	GroupId int64
+}
+
+func (g *IDGenerator) last() IDs {
+	return IDs{
+		OrderId: g.orderId.Load(),
+		TradeId: g.tradeId.Load(),
+		GroupId: g.groupId.Load(),
+	}
+}
+
+// restore makes g continue after ids unless it is already past them
+func (g *IDGenerator) restore(ids IDs) {
+	for _, counter := range []struct {
+		id   *atomic.Int64
+		last int64
+	}{
+		{&g.orderId, ids.OrderId},
+		{&g.tradeId, ids.TradeId},
+		{&g.groupId, ids.GroupId},
+	} {
+		for current := counter.id.Load(); current < counter.last; current = counter.id.Load() {
+			if counter.id.CompareAndSwap(current, counter.last) {
+				break
+			}
+		}
+	}
+}
+
+// IDLog lists the ids a book took while it applied a command, in the order it took them.
+// Replaying the command with the log hands out the same ids, whatever the other
+// books sharing the generator took in between.
+type IDLog struct {
+	Orders []int64 `json:",omitempty"`
+	Trades []int64 `json:",omitempty"`
+	Groups []int64 `json:",omitempty"`
+}
+
+// Empty reports whether l holds no id
+func (l *IDLog) Empty() bool {
+	return len(l.Orders) == 0 && len(l.Trades) == 0 && len(l.Groups) == 0
+}
+
+// last returns the highest ids of l
+func (l *IDLog) last() IDs {
+	last := func(ids []int64) int64 {
+		var max int64
+		for _, id := range ids {
+			if id > max {
+				max = id
+			}
+		}
+		return max
+	}
+	return IDs{OrderId: last(l.Orders), TradeId: last(l.Trades), GroupId: last(l.Groups)}
+}
+
+// LogIDs makes the book add the ids it takes to log, until it is called with nil
+func (ob *Orderbook) LogIDs(log *IDLog) {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+
+	ob.idLog = log
+	ob.replayIds = false
+}
+
+// ReplayIDs makes the book take the ids of log again, in order, until it is called with nil.
+// The generator of the book moves past them, once log runs out the book takes new ids.
+func (ob *Orderbook) ReplayIDs(log *IDLog) {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+
+	ob.idLog = nil
+	ob.replayIds = false
+	if log != nil {
+		ob.idLog = &IDLog{
+			Orders: append([]int64{}, log.Orders...),
+			Trades: append([]int64{}, log.Trades...),
+			Groups: append([]int64{}, log.Groups...),
+		}
+		ob.replayIds = true
+		ob.ids.restore(log.last())
+	}
+}
+
+func (ob *Orderbook) nextOrderId() int64 {
+	return ob.nextId(ob.ids.NextOrderId, func(l *IDLog) *[]int64 { return &l.Orders })
+}
+
+func (ob *Orderbook) nextTradeId() int64 {
+	return ob.nextId(ob.ids.NextTradeId, func(l *IDLog) *[]int64 { return &l.Trades })
+}
+
+func (ob *Orderbook) nextGroupId() int64 {
+	return ob.nextId(ob.ids.NextGroupId, func(l *IDLog) *[]int64 { return &l.Groups })
+}
+
+func (ob *Orderbook) nextId(next func() int64, logged func(*IDLog) *[]int64) int64 {
+	if ob.idLog == nil {
+		return next()
+	}
+
+	ids := logged(ob.idLog)
+	if ob.replayIds {
+		if len(*ids) == 0 {
+			return next()
+		}
+		id := (*ids)[0]
+		*ids = (*ids)[1:]
+		return id
+	}
+
+	id := next()
+	*ids = append(*ids, id)
+	return id
+}
@@ -0,0 +1,41 @@
+package orderbook
+
+import (
+	"testing"
+
+	"github.com/PanGan21/crypto-exchange-poc/decimal"
+)
+
+func TestReplayIDs(t *testing.T) {
+	ids := NewIDGenerator()
+	eth := NewOrderbook(WithIDGenerator(ids))
+	btc := NewOrderbook(WithIDGenerator(ids))
+
+	eth.PlaceLimitOrder(decimal.NewFromInt(100), NewOrder(false, decimal.NewFromInt(1), 1))
+
+	// the other book takes an id in the middle of the command
+	log := &IDLog{}
+	eth.LogIDs(log)
+	btc.PlaceLimitOrder(decimal.NewFromInt(100), NewOrder(false, decimal.NewFromInt(1), 1))
+	taker := NewOrder(true, decimal.NewFromInt(1), 2)
+	matches, _ := eth.PlaceLimitOrder(decimal.NewFromInt(100), taker)
+	eth.LogIDs(nil)
+
+	assert(t, taker.Id, int64(3))
+	assert(t, *log, IDLog{Orders: []int64{3}, Trades: []int64{1}})
+
+	// replayed alone the command takes the same ids, the generator carries on after them
+	replayIds := NewIDGenerator()
+	replayed := NewOrderbook(WithIDGenerator(replayIds))
+	replayed.PlaceLimitOrder(decimal.NewFromInt(100), NewOrder(false, decimal.NewFromInt(1), 1))
+
+	replayed.ReplayIDs(log)
+	replayedTaker := NewOrder(true, decimal.NewFromInt(1), 2)
+	replayedMatches, _ := replayed.PlaceLimitOrder(decimal.NewFromInt(100), replayedTaker)
+	replayed.ReplayIDs(nil)
+
+	assert(t, replayedTaker.Id, taker.Id)
+	assert(t, replayedMatches[0].TradeId, matches[0].TradeId)
+	assert(t, *log, IDLog{Orders: []int64{3}, Trades: []int64{1}})
+	assert(t, replayIds.NextOrderId(), int64(4))
+}
@@ -0,0 +1,119 @@
+package orderbook
+
+import "github.com/PanGan21/crypto-exchange-poc/decimal"
+
+// OrderStatus is the state of an order in its lifecycle:
+// NEW -> PARTIALLY_FILLED -> FILLED, CANCELLED or EXPIRED.
+// Orders the book refuses to place are REJECTED.
+type OrderStatus string
+
+const (
+	// StatusNew is an order resting in the book or waiting for its trigger with nothing filled
+	StatusNew             OrderStatus = "NEW"
+	StatusPartiallyFilled OrderStatus = "PARTIALLY_FILLED"
+	StatusFilled          OrderStatus = "FILLED"
+	// StatusCancelled is an order whose remaining size was cancelled, by its owner,
+	// its time in force, self-trade prevention, its slippage limit or its group
+	StatusCancelled OrderStatus = "CANCELLED"
+	StatusExpired   OrderStatus = "EXPIRED"
+	StatusRejected  OrderStatus = "REJECTED"
+)
+
+// IsFinal reports whether an order in this status left the book for good
+func (s OrderStatus) IsFinal() bool {
+	switch s {
+	case StatusFilled, StatusCancelled, StatusExpired, StatusRejected:
+		return true
+	default:
+		return false
+	}
+}
+
+// AveragePrice is the size weighted average price of the fills of o, with at most decimal.MaxScale decimals
+func (o *Order) AveragePrice(scale int32) decimal.Decimal {
+	if o.Filled.IsZero() {
+		return decimal.Zero
+	}
+	if scale > decimal.MaxScale {
+		scale = decimal.MaxScale
+	}
+	// the average is between the lowest and the highest fill price so it always fits
+	return o.FilledNotional.Div(o.Filled, scale)
+}
+
+// notionalAfter returns the notional of o once size more of it traded at price,
+// ErrNotionalOverflow when it does not fit
+func (o *Order) notionalAfter(price, size decimal.Decimal) (decimal.Decimal, error) {
+	notional, err := price.CheckedMul(size)
+	if err == nil {
+		notional, err = o.FilledNotional.CheckedAdd(notional)
+	}
+	if err != nil {
+		return decimal.Zero, ErrNotionalOverflow
+	}
+	return notional, nil
+}
+
+// fill records that size of o traded, notional is its notional after the fill from notionalAfter
+func (o *Order) fill(size, notional decimal.Decimal) {
+	o.Filled = o.Filled.Add(size)
+	o.FilledNotional = notional
+
+	o.Status = StatusPartiallyFilled
+	if o.TotalRemaining().IsZero() && o.Prevented.IsZero() {
+		o.Status = StatusFilled
+	}
+}
+
+// close moves o to a final status once it left the book: FILLED when all of it traded
+// and status otherwise. Orders already in a final status keep it.
+func (o *Order) close(status OrderStatus) {
+	if o.Status.IsFinal() {
+		return
+	}
+
+	if o.TotalRemaining().IsZero() && o.Prevented.IsZero() && o.Filled.IsPositive() {
+		o.Status = StatusFilled
+		return
+	}
+
+	o.Status = status
+}
+
+// close moves o to a final status and tells the cancel listener when o did not fill
+func (ob *Orderbook) close(o *Order, status OrderStatus) {
+	if o.Status.IsFinal() {
+		return
+	}
+	o.close(status)
+	ob.notifyCancelled(o)
+}
+
+// notifyCancelled passes o to the cancel listener unless it filled
+func (ob *Orderbook) notifyCancelled(o *Order) {
+	if ob.onCancel != nil && o.Status != StatusFilled {
+		ob.onCancel(o)
+	}
+}
+
+// closeOrder takes o out of the book for good
+func (ob *Orderbook) closeOrder(o *Order, status OrderStatus) {
+	ob.cancelOrder(o)
+	ob.close(o, status)
+}
+
+// reject marks o as refused by the book and passes err through
+func (o *Order) reject(err error) error {
+	if err != nil {
+		o.Status = StatusRejected
+	}
+	return err
+}
+
+// rejectAll rejects the orders placed together when err is not nil
+func rejectAll(err error, orders ...*Order) error {
+	for _, o := range orders {
+		o.reject(err)
+	}
+	return err
+}
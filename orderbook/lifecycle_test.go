@@ -0,0 +1,155 @@
+package orderbook
+
+import (
+	"testing"
+	"time"
+
+	"github.com/PanGan21/crypto-exchange-poc/decimal"
+)
+
+func TestOrderLifecycleFills(t *testing.T) {
+	ob := NewOrderbook()
+
+	sellOrder := NewOrder(false, decimal.NewFromInt(3), 1)
+	ob.PlaceLimitOrder(decimal.NewFromInt(100), sellOrder)
+	assert(t, sellOrder.Status, StatusNew)
+
+	ob.PlaceMarketOrder(NewOrder(true, decimal.NewFromInt(1), 2))
+	assert(t, sellOrder.Status, StatusPartiallyFilled)
+	assert(t, sellOrder.Filled, decimal.NewFromInt(1))
+
+	ob.PlaceLimitOrder(decimal.NewFromInt(101), NewOrder(false, decimal.NewFromInt(1), 1))
+
+	buyOrder := NewOrder(true, decimal.NewFromInt(3), 2)
+	ob.PlaceLimitOrder(decimal.NewFromInt(101), buyOrder)
+
+	assert(t, sellOrder.Status, StatusFilled)
+	assert(t, buyOrder.Status, StatusFilled)
+	assert(t, buyOrder.Filled, decimal.NewFromInt(3))
+	assert(t, buyOrder.FilledNotional, decimal.NewFromInt(301))
+	assert(t, buyOrder.AveragePrice(2), decimal.RequireFromString("100.33"))
+}
+
+func TestOrderLifecycleCancelled(t *testing.T) {
+	ob := NewOrderbook()
+	ob.PlaceLimitOrder(decimal.NewFromInt(100), NewOrder(false, decimal.NewFromInt(1), 1))
+
+	// the remainder of an IOC order is cancelled
+	iocOrder := NewOrder(true, decimal.NewFromInt(2), 2)
+	iocOrder.TimeInForce = IOC
+	ob.PlaceLimitOrder(decimal.NewFromInt(100), iocOrder)
+	assert(t, iocOrder.Status, StatusCancelled)
+	assert(t, iocOrder.Filled, decimal.NewFromInt(1))
+
+	restingOrder := NewOrder(true, decimal.NewFromInt(1), 2)
+	ob.PlaceLimitOrder(decimal.NewFromInt(90), restingOrder)
+	ob.CancelOrder(restingOrder.Id)
+	assert(t, restingOrder.Status, StatusCancelled)
+
+	// self-trade prevention cancels the resting order
+	ownOrder := NewOrder(false, decimal.NewFromInt(1), 3)
+	ob.PlaceLimitOrder(decimal.NewFromInt(110), ownOrder)
+	buyOrder := NewOrder(true, decimal.NewFromInt(1), 3)
+	buyOrder.SelfTradePrevention = SelfTradeCancelOldest
+	ob.PlaceLimitOrder(decimal.NewFromInt(110), buyOrder)
+	assert(t, ownOrder.Status, StatusCancelled)
+	assert(t, buyOrder.Status, StatusNew)
+
+	// cancelling again keeps the final status
+	filledOrder := NewOrder(false, decimal.NewFromInt(1), 4)
+	ob.PlaceLimitOrder(decimal.NewFromInt(110), filledOrder)
+	assert(t, filledOrder.Status, StatusFilled)
+	_, err := ob.CancelOrder(filledOrder.Id)
+	assert(t, err, ErrOrderNotFound)
+	assert(t, filledOrder.Status, StatusFilled)
+}
+
+func TestOrderLifecycleExpiredAndRejected(t *testing.T) {
+	ob := NewOrderbook()
+	now := time.Now().UnixNano()
+
+	gtdOrder := NewOrder(true, decimal.NewFromInt(1), 1)
+	gtdOrder.TimeInForce = GTD
+	gtdOrder.ExpiresAt = now + int64(time.Minute)
+	ob.PlaceLimitOrder(decimal.NewFromInt(100), gtdOrder)
+	expiresAt, ok := ob.NextExpiry()
+	assert(t, ok, true)
+	assert(t, expiresAt, gtdOrder.ExpiresAt)
+
+	ob.ExpireOrders(now + int64(2*time.Minute))
+	assert(t, gtdOrder.Status, StatusExpired)
+	_, ok = ob.NextExpiry()
+	assert(t, ok, false)
+
+	fokOrder := NewOrder(false, decimal.NewFromInt(1), 2)
+	fokOrder.TimeInForce = FOK
+	_, err := ob.PlaceLimitOrder(decimal.NewFromInt(100), fokOrder)
+	assert(t, err == nil, false)
+	assert(t, fokOrder.Status, StatusRejected)
+
+	stopOrder := NewOrder(false, decimal.NewFromInt(1), 2)
+	assert(t, ob.PlaceStopOrder(stopOrder), ErrInvalidStopPrice)
+	assert(t, stopOrder.Status, StatusRejected)
+}
+
+func TestCancelListener(t *testing.T) {
+	cancelled := []int64{}
+	ob := NewOrderbook(WithCancelListener(func(o *Order) {
+		cancelled = append(cancelled, o.Id)
+	}))
+
+	ownOrder := NewOrder(false, decimal.NewFromInt(1), 1)
+	ob.PlaceLimitOrder(decimal.NewFromInt(100), ownOrder)
+	sellOrder := NewOrder(false, decimal.NewFromInt(1), 2)
+	ob.PlaceLimitOrder(decimal.NewFromInt(101), sellOrder)
+
+	// self-trade prevention cancels the resting order, the taker fills
+	buyOrder := NewOrder(true, decimal.NewFromInt(1), 1)
+	buyOrder.SelfTradePrevention = SelfTradeCancelOldest
+	ob.PlaceLimitOrder(decimal.NewFromInt(101), buyOrder)
+	assert(t, cancelled, []int64{ownOrder.Id})
+
+	// the remainder of an IOC order is cancelled
+	iocOrder := NewOrder(true, decimal.NewFromInt(1), 3)
+	iocOrder.TimeInForce = IOC
+	ob.PlaceLimitOrder(decimal.NewFromInt(90), iocOrder)
+	assert(t, cancelled, []int64{ownOrder.Id, iocOrder.Id})
+
+	restingOrder := NewOrder(true, decimal.NewFromInt(1), 3)
+	ob.PlaceLimitOrder(decimal.NewFromInt(90), restingOrder)
+	ob.CancelOrder(restingOrder.Id)
+	assert(t, cancelled, []int64{ownOrder.Id, iocOrder.Id, restingOrder.Id})
+
+	assert(t, len(ob.TradesSince(0)), 1)
+	assert(t, len(ob.TradesSince(1)), 0)
+}
+
+func TestFillNotionalOverflow(t *testing.T) {
+	ob := NewOrderbook(WithTickSize(decimal.New(1, 2)))
+
+	// 999999.99999999 * 3000.01 does not fit in a decimal with 10 decimals
+	size := decimal.RequireFromString("999999.99999999")
+	sellOrder := NewOrder(false, size, 1)
+	ob.PlaceLimitOrder(decimal.RequireFromString("3000.01"), sellOrder)
+
+	buyOrder := NewOrder(true, size, 2)
+	matches, err := ob.PlaceMarketOrder(buyOrder)
+	assert(t, err, nil)
+	assert(t, len(matches), 0)
+	assert(t, buyOrder.Status, StatusCancelled)
+	assert(t, buyOrder.Filled, decimal.Zero)
+	assert(t, sellOrder.Status, StatusNew)
+	assert(t, sellOrder.Size, size)
+
+	// the remainder of a limit order that stopped matching does not rest across the book
+	ob.PlaceLimitOrder(decimal.NewFromInt(3000), NewOrder(false, decimal.NewFromInt(1), 1))
+	buyOrder = NewOrder(true, size, 2)
+	matches, err = ob.PlaceLimitOrder(decimal.RequireFromString("3000.01"), buyOrder)
+	assert(t, err, nil)
+	assert(t, len(matches), 1)
+	assert(t, buyOrder.Status, StatusCancelled)
+	assert(t, buyOrder.Filled, decimal.NewFromInt(1))
+	assert(t, buyOrder.FilledNotional, decimal.NewFromInt(3000))
+	assert(t, ob.BidTotalVolume(), decimal.Zero)
+	assert(t, ob.AskTotalVolume(), size)
+}
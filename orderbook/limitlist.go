@@ -0,0 +1,158 @@
+package orderbook
+
+import (
+	"math/rand"
+
+	"github.com/PanGan21/crypto-exchange-poc/decimal"
+)
+
+const (
+	maxLevel    = 32
+	levelFactor = 4 // on average one in levelFactor nodes is promoted a level
+)
+
+// limitList is a skiplist of price levels ordered from the best to the
+// worst price of one side of the book. Inserts and deletes are O(log n)
+// and the best level is always the first node.
+type limitList struct {
+	head   *limitNode
+	level  int
+	length int
+	rnd    *rand.Rand
+	// volume is the executable volume of all the levels, kept up to date by the levels
+	volume decimal.Decimal
+	// before reports whether price a ranks ahead of price b
+	before func(a, b decimal.Decimal) bool
+}
+
+type limitNode struct {
+	limit *Limit
+	next  []*limitNode
+}
+
+func newLimitList(before func(a, b decimal.Decimal) bool) *limitList {
+	return &limitList{
+		head:   &limitNode{next: make([]*limitNode, maxLevel)},
+		level:  1,
+		rnd:    rand.New(rand.NewSource(1)),
+		before: before,
+	}
+}
+
+// newAskList orders the levels with the lowest price first
+func newAskList() *limitList {
+	return newLimitList(func(a, b decimal.Decimal) bool { return a.LessThan(b) })
+}
+
+// newBidList orders the levels with the highest price first
+func newBidList() *limitList {
+	return newLimitList(func(a, b decimal.Decimal) bool { return a.GreaterThan(b) })
+}
+
+func (ll *limitList) randomLevel() int {
+	level := 1
+	for level < maxLevel && ll.rnd.Intn(levelFactor) == 0 {
+		level++
+	}
+	return level
+}
+
+// findPrev fills update with the right-most node on every level that
+// ranks strictly ahead of price
+func (ll *limitList) findPrev(price decimal.Decimal, update []*limitNode) *limitNode {
+	node := ll.head
+	for i := ll.level - 1; i >= 0; i-- {
+		for node.next[i] != nil && ll.before(node.next[i].limit.Price, price) {
+			node = node.next[i]
+		}
+		update[i] = node
+	}
+	return node.next[0]
+}
+
+func (ll *limitList) insert(l *Limit) {
+	var update [maxLevel]*limitNode
+
+	if next := ll.findPrev(l.Price, update[:]); next != nil && next.limit.Price.Equal(l.Price) {
+		next.limit = l
+		return
+	}
+
+	level := ll.randomLevel()
+	if level > ll.level {
+		for i := ll.level; i < level; i++ {
+			update[i] = ll.head
+		}
+		ll.level = level
+	}
+
+	node := &limitNode{limit: l, next: make([]*limitNode, level)}
+	for i := 0; i < level; i++ {
+		node.next[i] = update[i].next[i]
+		update[i].next[i] = node
+	}
+	ll.length++
+}
+
+func (ll *limitList) remove(l *Limit) bool {
+	var update [maxLevel]*limitNode
+
+	node := ll.findPrev(l.Price, update[:])
+	if node == nil || node.limit != l {
+		return false
+	}
+
+	for i := 0; i < ll.level; i++ {
+		if update[i].next[i] != node {
+			break
+		}
+		update[i].next[i] = node.next[i]
+	}
+
+	for ll.level > 1 && ll.head.next[ll.level-1] == nil {
+		ll.level--
+	}
+	ll.length--
+
+	return true
+}
+
+// find returns the level at price or nil when there is none
+func (ll *limitList) find(price decimal.Decimal) *Limit {
+	var update [maxLevel]*limitNode
+
+	if node := ll.findPrev(price, update[:]); node != nil && node.limit.Price.Equal(price) {
+		return node.limit
+	}
+	return nil
+}
+
+// best returns the level with the best price or nil when the side is empty
+func (ll *limitList) best() *Limit {
+	if first := ll.head.next[0]; first != nil {
+		return first.limit
+	}
+	return nil
+}
+
+func (ll *limitList) len() int {
+	return ll.length
+}
+
+// each visits the levels from the best to the worst price until fn returns false
+func (ll *limitList) each(fn func(l *Limit) bool) {
+	for node := ll.head.next[0]; node != nil; node = node.next[0] {
+		if !fn(node.limit) {
+			return
+		}
+	}
+}
+
+func (ll *limitList) limits() []*Limit {
+	limits := make([]*Limit, 0, ll.length)
+	ll.each(func(l *Limit) bool {
+		limits = append(limits, l)
+		return true
+	})
+	return limits
+}
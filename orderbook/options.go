@@ -0,0 +1,36 @@
+package orderbook
+
+import "github.com/PanGan21/crypto-exchange-poc/decimal"
+
+// Option configures an Orderbook on creation
+type Option func(*Orderbook)
+
+// WithIDGenerator makes the book take its ids from g instead of its own generator
+func WithIDGenerator(g *IDGenerator) Option {
+	return func(ob *Orderbook) {
+		ob.ids = g
+	}
+}
+
+// WithCancelListener makes the book call f with every order it cancels or expires,
+// including the remainder of IOC orders and self-trade prevention, once its status is final.
+// f runs while the book is locked and must not call back into it.
+func WithCancelListener(f func(*Order)) Option {
+	return func(ob *Orderbook) {
+		ob.onCancel = f
+	}
+}
+
+// WithClock makes the book take the time from now instead of the system clock
+func WithClock(now func() int64) Option {
+	return func(ob *Orderbook) {
+		ob.now = now
+	}
+}
+
+// WithTickSize sets the minimum price increment of the book, it defaults to 1
+func WithTickSize(tick decimal.Decimal) Option {
+	return func(ob *Orderbook) {
+		ob.tickSize = tick
+	}
+}
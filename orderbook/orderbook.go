@@ -2,62 +2,117 @@ package orderbook
 
 import (
 	"fmt"
-	"math/rand"
 	"sort"
 	"sync"
 	"time"
+
+	"github.com/PanGan21/crypto-exchange-poc/decimal"
 )
 
 type Trade struct {
-	Price     float64
-	Bid       bool
-	Size      float64
-	Timestamp int64
+	Id           int64
+	Sequence     uint64 // Increments by one for every trade of the book, a gap means a missed trade
+	Price        decimal.Decimal
+	Bid          bool // Side of the taker
+	Size         decimal.Decimal
+	Timestamp    int64
+	MakerOrderId int64
+	TakerOrderId int64
+	MakerUserId  int64
+	TakerUserId  int64
 }
 
 type Match struct {
 	Ask        *Order
 	Bid        *Order
-	SizeFilled float64
-	Price      float64
+	SizeFilled decimal.Decimal
+	Price      decimal.Decimal
+	TradeId    int64
+	Taker      *Order // Bid or Ask, whichever took liquidity
 }
 
 type Order struct {
-	Id        int64
-	UserId    int64
-	Size      float64 // Amount of crypto to buy
-	Bid       bool
-	Limit     *Limit // Limit that this order belongs to
-	Timestamp int64
+	Id          int64
+	UserId      int64
+	Size        decimal.Decimal // Amount of crypto to buy
+	Price       decimal.Decimal // Limit price, zero for market orders
+	Bid         bool
+	TimeInForce TimeInForce
+	ExpiresAt   int64           // Unix nanoseconds after which a GTD order is cancelled
+	PostOnly    PostOnly        // Makes sure a limit order never takes liquidity
+	StopPrice   decimal.Decimal // Last trade price that activates a stop order, moved by trailing stops
+	Triggered   bool            // Set once a stop order has been activated
+	// TrailingAmount or TrailingPercent of the price make a stop order follow the last trade price
+	TrailingAmount  decimal.Decimal
+	TrailingPercent decimal.Decimal
+	// Peg makes the price of a limit order track the top of the book, moved by PegOffset and capped at PegCap
+	Peg       Peg
+	PegOffset decimal.Decimal
+	PegCap    decimal.Decimal
+	// MaxSlippage in basis points of the best price at arrival or WorstPrice
+	// stop a market order from filling further, the remainder is cancelled
+	MaxSlippage decimal.Decimal
+	WorstPrice  decimal.Decimal
+	DisplaySize decimal.Decimal // Visible slice of an iceberg order, zero shows the whole size
+	Reserve     decimal.Decimal // Hidden quantity of an iceberg order
+	// SelfTradePrevention stops the order from matching resting orders of the same user
+	SelfTradePrevention SelfTradePrevention
+	Prevented           decimal.Decimal // Quantity cancelled by self-trade prevention
+	Status              OrderStatus
+	Filled              decimal.Decimal // Quantity traded so far
+	FilledNotional      decimal.Decimal // Sum of price * size of the fills
+	Limit               *Limit          // Limit that this order belongs to
+	Timestamp           int64
+	Sequence            uint64 // Assigned by the book when the order is queued, defines time priority
+
+	// position in the queue of the limit
+	prev *Order
+	next *Order
+	// trigger queue of a pending stop order
+	trigger *Limit
+	// OCO or bracket group the order belongs to
+	group *OrderGroup
+	// a copy of the order drops the pointers into the book but keeps whether it rested or was pending
+	copyResting bool
+	copyPending bool
 }
 
-type Orders []*Order
-
-func (o Orders) Len() int {
-	return len(o)
-}
-
-func (o Orders) Swap(i, j int) {
-	o[i], o[j] = o[j], o[i]
+func (o *Order) String() string {
+	return fmt.Sprintf("[size: %s]", o.Size)
 }
 
-func (o Orders) Less(i, j int) bool {
-	return o[i].Timestamp < o[j].Timestamp
+func (o *Order) IsFilled() bool {
+	return o.Size.IsZero()
 }
 
-func (o *Order) String() string {
-	return fmt.Sprintf("[size: %.2f]", o.Size)
+// IsResting reports whether o rests in the book, or for a copy whether it rested when copied
+func (o *Order) IsResting() bool {
+	return o.Limit != nil || o.copyResting
 }
 
-func (o *Order) IsFilled() bool {
-	return o.Size == 0.0
+// TotalRemaining is the visible size plus the hidden reserve of an iceberg order
+func (o *Order) TotalRemaining() decimal.Decimal {
+	return o.Size.Add(o.Reserve)
 }
 
-// Limit is a bucket of orders at the same price level
+// Limit is a bucket of orders at the same price level.
+// The orders are kept in a FIFO queue so the oldest order is always filled first.
 type Limit struct {
-	Price       float64
-	Orders      Orders
-	TotalVolume float64
+	Price       decimal.Decimal
+	TotalVolume decimal.Decimal // Visible volume, excludes the reserve of iceberg orders
+
+	reserveVolume decimal.Decimal
+	pegged        int // number of pegged orders in the queue
+	// nextSequence is set by the book to stamp the orders that are requeued
+	nextSequence func() uint64
+	// cancelled is set by the book to stop tracking orders cancelled by self-trade prevention
+	cancelled func(*Order)
+	// side is set by the book to keep the volume of the whole side
+	side *limitList
+
+	head   *Order
+	tail   *Order
+	length int
 }
 
 type Limits []*Limit
@@ -73,7 +128,7 @@ func (a ByBestAsk) Swap(i, j int) {
 }
 
 func (a ByBestAsk) Less(i, j int) bool {
-	return a.Limits[i].Price < a.Limits[j].Price
+	return a.Limits[i].Price.LessThan(a.Limits[j].Price)
 }
 
 type ByBestBid struct{ Limits }
@@ -87,79 +142,184 @@ func (b ByBestBid) Swap(i, j int) {
 }
 
 func (b ByBestBid) Less(i, j int) bool {
-	return b.Limits[i].Price > b.Limits[j].Price
+	return b.Limits[i].Price.GreaterThan(b.Limits[j].Price)
 }
 
-func NewLimit(price float64) *Limit {
+func NewLimit(price decimal.Decimal) *Limit {
 	return &Limit{
-		Price:  price,
-		Orders: []*Order{},
+		Price: price,
 	}
 }
 
-func NewOrder(bid bool, size float64, userId int64) *Order {
+// NewOrder creates an order without an id, the id is assigned by the book on placement
+func NewOrder(bid bool, size decimal.Decimal, userId int64) *Order {
 	return &Order{
-		Id:        int64(rand.Intn(10000000)),
 		UserId:    userId,
 		Size:      size,
 		Bid:       bid,
+		Status:    StatusNew,
 		Timestamp: time.Now().UnixNano(),
 	}
 }
 
+// AddOrder appends the order to the back of the queue
 func (l *Limit) AddOrder(o *Order) {
 	o.Limit = l
-	l.Orders = append(l.Orders, o)
-	l.TotalVolume += o.Size
+	l.push(o)
+
+	l.TotalVolume = l.TotalVolume.Add(o.Size)
+	l.reserveVolume = l.reserveVolume.Add(o.Reserve)
+	l.addSideVolume(o.TotalRemaining())
+	if o.Peg != "" {
+		l.pegged++
+	}
 }
 
+// RemoveOrder unlinks the order from the queue in O(1)
 func (l *Limit) RemoveOrder(o *Order) {
-	for i := 0; i < len(l.Orders); i++ {
-		if l.Orders[i] == o {
-			l.Orders[i] = l.Orders[len(l.Orders)-1]
-			l.Orders = l.Orders[:len(l.Orders)-1]
-		}
+	if o.Limit != l {
+		return
 	}
 
+	l.unlink(o)
+
 	o.Limit = nil
-	l.TotalVolume -= o.Size
+	l.TotalVolume = l.TotalVolume.Sub(o.Size)
+	l.reserveVolume = l.reserveVolume.Sub(o.Reserve)
+	l.subSideVolume(o.TotalRemaining())
+	if o.Peg != "" {
+		l.pegged--
+	}
+}
 
-	sort.Sort(l.Orders)
+// addSideVolume adds volume to the side of the book the limit belongs to.
+// The book checks that it fits before it rests an order so the sums never overflow.
+func (l *Limit) addSideVolume(volume decimal.Decimal) {
+	if l.side != nil {
+		l.side.volume = l.side.volume.Add(volume)
+	}
 }
 
-func (l *Limit) Fill(o *Order) []Match {
-	var (
-		matches        []Match
-		ordersToDelete []*Order
-	)
+func (l *Limit) subSideVolume(volume decimal.Decimal) {
+	if l.side != nil {
+		l.side.volume = l.side.volume.Sub(volume)
+	}
+}
 
-	for _, order := range l.Orders {
-		if o.IsFilled() {
-			break
+func (l *Limit) push(o *Order) {
+	o.prev = l.tail
+	o.next = nil
+
+	if l.tail == nil {
+		l.head = o
+	} else {
+		l.tail.next = o
+	}
+	l.tail = o
+	l.length++
+}
+
+func (l *Limit) unlink(o *Order) {
+	if o.prev == nil {
+		l.head = o.next
+	} else {
+		o.prev.next = o.next
+	}
+
+	if o.next == nil {
+		l.tail = o.prev
+	} else {
+		o.next.prev = o.prev
+	}
+	l.length--
+
+	o.prev = nil
+	o.next = nil
+}
+
+// Len returns the number of orders queued in the limit
+func (l *Limit) Len() int {
+	return l.length
+}
+
+// Front returns the order with the highest time priority
+func (l *Limit) Front() *Order {
+	return l.head
+}
+
+// Orders returns the queued orders in time priority
+func (l *Limit) Orders() []*Order {
+	orders := make([]*Order, 0, l.length)
+	for o := l.head; o != nil; o = o.next {
+		orders = append(orders, o)
+	}
+	return orders
+}
+
+// Fill matches o against the queue in time priority until o is filled.
+// It stops with ErrNotionalOverflow before a fill whose notional does not fit.
+func (l *Limit) Fill(o *Order) ([]Match, error) {
+	var matches []Match
+
+	for order := l.head; order != nil && !o.IsFilled(); {
+		next := order.next
+
+		if o.SelfTradePrevention != "" && order.UserId == o.UserId {
+			l.preventSelfTrade(order, o)
+			order = next
+			continue
+		}
+
+		match, err := l.fillOrder(order, o)
+		if err != nil {
+			return matches, err
 		}
-		match := l.fillOrder(order, o)
 		matches = append(matches, match)
 
-		l.TotalVolume -= match.SizeFilled
+		l.TotalVolume = l.TotalVolume.Sub(match.SizeFilled)
+		l.subSideVolume(match.SizeFilled)
 
 		if order.IsFilled() {
-			ordersToDelete = append(ordersToDelete, order)
+			if order.Reserve.IsPositive() {
+				l.refill(order)
+			} else {
+				l.RemoveOrder(order)
+			}
 		}
 
+		order = next
 	}
 
-	for _, order := range ordersToDelete {
-		l.RemoveOrder(order)
+	return matches, nil
+}
+
+// refill shows the next slice of an iceberg order from its reserve.
+// The order goes to the back of the queue and loses its time priority.
+func (l *Limit) refill(o *Order) {
+	slice := decimal.Min(o.DisplaySize, o.Reserve)
+	o.Reserve = o.Reserve.Sub(slice)
+	o.Size = slice
+
+	l.TotalVolume = l.TotalVolume.Add(slice)
+	l.reserveVolume = l.reserveVolume.Sub(slice)
+
+	l.unlink(o)
+	l.push(o)
+	if l.nextSequence != nil {
+		o.Sequence = l.nextSequence()
 	}
+}
 
-	return matches
+// ExecutableVolume is the visible volume plus the hidden reserve of iceberg orders
+func (l *Limit) ExecutableVolume() decimal.Decimal {
+	return l.TotalVolume.Add(l.reserveVolume)
 }
 
-func (l *Limit) fillOrder(a, b *Order) Match {
+// fillOrder trades a against b at the price of the limit, nothing changes when it fails
+func (l *Limit) fillOrder(a, b *Order) (Match, error) {
 	var (
-		bid        *Order
-		ask        *Order
-		sizeFilled float64
+		bid *Order
+		ask *Order
 	)
 
 	if a.Bid {
@@ -170,178 +330,685 @@ func (l *Limit) fillOrder(a, b *Order) Match {
 		ask = a
 	}
 
-	if a.Size >= b.Size {
-		a.Size -= b.Size
-		sizeFilled = b.Size
-		b.Size = 0.0
-	} else {
-		b.Size -= a.Size
-		sizeFilled = a.Size
-		a.Size = 0.0
+	sizeFilled := decimal.Min(a.Size, b.Size)
+
+	notionalA, err := a.notionalAfter(l.Price, sizeFilled)
+	if err != nil {
+		return Match{}, err
 	}
+	notionalB, err := b.notionalAfter(l.Price, sizeFilled)
+	if err != nil {
+		return Match{}, err
+	}
+
+	a.Size = a.Size.Sub(sizeFilled)
+	b.Size = b.Size.Sub(sizeFilled)
+	a.fill(sizeFilled, notionalA)
+	b.fill(sizeFilled, notionalB)
 
 	return Match{
 		Bid:        bid,
 		Ask:        ask,
 		SizeFilled: sizeFilled,
 		Price:      l.Price,
-	}
+	}, nil
 }
 
+// Orderbook is safe for concurrent use, readers get copies of its state
 type Orderbook struct {
-	asks   []*Limit
-	bids   []*Limit
-	Trades []*Trade
+	asks   *limitList
+	bids   *limitList
+	trades []*Trade
 
 	mu        sync.RWMutex
-	AskLimits map[float64]*Limit
-	BidLimits map[float64]*Limit
-	Orders    map[int64]*Order
+	askLimits map[decimal.Decimal]*Limit
+	bidLimits map[decimal.Decimal]*Limit
+	orders    map[int64]*Order // Resting and pending stop orders
+	onCancel  func(*Order)     // Called with the orders cancelled or expired by the book
+
+	expiring      map[int64]*Order // GTD orders resting in the book
+	groups        []*OrderGroup    // Open OCO and bracket groups, oldest first
+	trailing      []*Order         // Pending trailing stops, oldest first
+	pegged        []*Order         // Resting pegged orders, oldest first
+	buyStops      *limitList       // Pending buy stops, lowest stop price first
+	sellStops     *limitList       // Pending sell stops, highest stop price first
+	tickSize      decimal.Decimal
+	ids           *IDGenerator
+	idLog         *IDLog // Ids taken by the command being applied, or to take again when replayIds
+	replayIds     bool
+	now           func() int64 // Unix nanoseconds used for trades, amendments and GTD checks
+	sequence      uint64
+	tradeSequence uint64
 }
 
-func NewOrderbook() *Orderbook {
-	return &Orderbook{
-		asks:      []*Limit{},
-		bids:      []*Limit{},
-		Trades:    []*Trade{},
-		AskLimits: make(map[float64]*Limit),
-		BidLimits: make(map[float64]*Limit),
-		Orders:    make(map[int64]*Order),
+func NewOrderbook(opts ...Option) *Orderbook {
+	ob := &Orderbook{
+		asks:      newAskList(),
+		bids:      newBidList(),
+		trades:    []*Trade{},
+		askLimits: make(map[decimal.Decimal]*Limit),
+		bidLimits: make(map[decimal.Decimal]*Limit),
+		orders:    make(map[int64]*Order),
+		expiring:  make(map[int64]*Order),
+		buyStops:  newAskList(),
+		sellStops: newBidList(),
+		ids:       NewIDGenerator(),
+		tickSize:  decimal.NewFromInt(1),
+		now: func() int64 {
+			return time.Now().UnixNano()
+		},
+	}
+
+	for _, opt := range opts {
+		opt(ob)
 	}
+
+	return ob
 }
 
-func (ob *Orderbook) PlaceMarketOrder(o *Order) []Match {
+// PlaceMarketOrder fills o against the opposite side of the book.
+// When the book can't fill the whole order the time in force decides the outcome:
+// an IOC order fills what is available and the rest is cancelled (left in o.Size),
+// a FOK order is rejected without touching the book. The volume self-trade prevention
+// would keep it from filling doesn't count for a FOK order.
+// MaxSlippage and WorstPrice limit the levels the order fills at, the volume
+// beyond them counts as unavailable.
+// An *InsufficientVolumeError is returned whenever nothing was filled.
+func (ob *Orderbook) PlaceMarketOrder(o *Order) ([]Match, error) {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+
+	o.Id = ob.nextOrderId()
+
+	matches, err := ob.placeMarketOrder(o)
+	if err != nil {
+		return nil, o.reject(err)
+	}
+
+	return ob.cascade(matches), nil
+}
+
+// cascade appends the matches of the stop orders and the order groups
+// set off by matches and reprices the pegged orders to the new top of the book
+func (ob *Orderbook) cascade(matches []Match) []Match {
+	matches = append(matches, ob.triggerStops()...)
+	matches = append(matches, ob.settleGroups(matches)...)
+	ob.repricePegged()
+	return matches
+}
+
+func (ob *Orderbook) placeMarketOrder(o *Order) ([]Match, error) {
 	matches := []Match{}
 
-	if o.Bid {
-		if o.Size > ob.AskTotalVolume() {
-			panic(fmt.Errorf("not enough volume [size: %.2f] for market order [size: %.2f]", ob.AskTotalVolume(), o.Size))
+	if o.MaxSlippage.IsNegative() || o.WorstPrice.IsNegative() {
+		return nil, ErrInvalidSlippage
+	}
+
+	available := ob.executableVolume(!o.Bid)
+	priceLimit, bounded := ob.priceLimit(o)
+	if bounded {
+		available = ob.volumeUpTo(!o.Bid, priceLimit)
+	}
+	if o.TimeInForce == FOK && o.SelfTradePrevention != "" {
+		available = ob.selfTradeVolume(o, priceLimit, bounded)
+	}
+
+	if available.IsZero() || o.Size.GreaterThan(available) && o.TimeInForce == FOK {
+		return nil, &InsufficientVolumeError{
+			Requested: o.Size,
+			Available: available,
 		}
-		for _, limit := range ob.Asks() {
-			limitMatches := limit.Fill(o)
-			matches = append(matches, limitMatches...)
+	}
 
-			if len(limit.Orders) == 0 {
-				ob.clearLimit(false, limit)
-			}
+	for !o.IsFilled() {
+		limit := ob.bestOpposite(o.Bid)
+		if limit == nil || bounded && o.worse(limit.Price, priceLimit) {
+			break
 		}
-	} else {
-		if o.Size > ob.BidTotalVolume() {
-			panic(fmt.Errorf("not enough volume [size: %.2f] for market order [size: %.2f]", ob.BidTotalVolume(), o.Size))
+		filled, err := ob.fillLimit(limit, o)
+		matches = append(matches, filled...)
+		if err != nil {
+			break
 		}
-		for _, limit := range ob.Bids() {
-			limitMatches := limit.Fill(o)
-			matches = append(matches, limitMatches...)
+	}
 
-			if len(limit.Orders) == 0 {
-				ob.clearLimit(true, limit)
-			}
+	// the remainder is cancelled, also when a fill would overflow the notional
+	ob.recordTrades(o, matches)
+	ob.close(o, StatusCancelled)
+
+	return matches, nil
+}
+
+// PlaceLimitOrder is the way to provide liquidity in the exchange.
+// A limit order that crosses the spread is first matched against the
+// opposite side up to its limit price, what happens to the remainder
+// depends on the time in force of the order:
+// GTC and GTD orders rest in the book, IOC orders cancel it (left in o.Size)
+// and FOK orders are rejected before touching the book unless they can be filled completely,
+// without the volume self-trade prevention would keep them from filling.
+func (ob *Orderbook) PlaceLimitOrder(price decimal.Decimal, o *Order) ([]Match, error) {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+
+	matches, err := ob.submitLimitOrder(price, o)
+	if err != nil {
+		return nil, o.reject(err)
+	}
+
+	return ob.cascade(matches), nil
+}
+
+// submitLimitOrder validates o and places it at price
+func (ob *Orderbook) submitLimitOrder(price decimal.Decimal, o *Order) ([]Match, error) {
+	if o.TimeInForce == GTD && o.ExpiresAt <= ob.now() {
+		return nil, ErrInvalidExpiry
+	}
+
+	if o.DisplaySize.IsNegative() {
+		return nil, ErrInvalidDisplaySize
+	}
+
+	if err := ob.checkVolume(o.Bid, o.Size); err != nil {
+		return nil, err
+	}
+
+	if o.PostOnly != "" {
+		var err error
+		if price, err = ob.postOnlyPrice(price, o); err != nil {
+			return nil, err
 		}
 	}
 
-	for _, match := range matches {
-		trade := &Trade{
-			Price:     match.Price,
-			Size:      match.SizeFilled,
-			Timestamp: time.Now().UnixNano(),
-			Bid:       o.Bid,
+	if o.TimeInForce == FOK {
+		available := ob.volumeUpTo(!o.Bid, price)
+		if o.SelfTradePrevention != "" {
+			available = ob.selfTradeVolume(o, price, true)
 		}
-		ob.Trades = append(ob.Trades, trade)
+		if o.Size.GreaterThan(available) {
+			return nil, &InsufficientVolumeError{
+				Requested: o.Size,
+				Available: available,
+			}
+		}
+	}
+
+	o.Id = ob.nextOrderId()
+	o.Price = price
+
+	return ob.placeLimitOrder(o), nil
+}
+
+// placeLimitOrder matches o up to its price and rests the remainder when its time in force allows it.
+// The remainder of an order that stopped matching because of ErrNotionalOverflow would cross the book,
+// it is cancelled, and so is a remainder that fails ErrVolumeOverflow, as a triggered stop can.
+func (ob *Orderbook) placeLimitOrder(o *Order) []Match {
+	matches, err := ob.matchLimitOrder(o.Price, o)
+	ob.recordTrades(o, matches)
+
+	if err == nil {
+		err = ob.checkVolume(o.Bid, o.Size)
 	}
+	if o.IsFilled() || o.TimeInForce == IOC || o.TimeInForce == FOK || err != nil {
+		ob.close(o, StatusCancelled)
+		return matches
+	}
+
+	// only the display size of an iceberg order is shown in the book
+	if o.DisplaySize.IsPositive() && o.Size.GreaterThan(o.DisplaySize) {
+		o.Reserve = o.Reserve.Add(o.Size.Sub(o.DisplaySize))
+		o.Size = o.DisplaySize
+	}
+
+	ob.addOrder(o.Price, o)
 
 	return matches
 }
 
-// LimitOrder is the way to provide liquidity in the exchange
-func (ob *Orderbook) PlaceLimitOrder(price float64, o *Order) {
+// addOrder rests o at the back of the queue of the given price level
+func (ob *Orderbook) addOrder(price decimal.Decimal, o *Order) {
 	var limit *Limit
 
-	ob.mu.Lock()
-	defer ob.mu.Unlock()
-
 	if o.Bid {
-		limit = ob.BidLimits[price]
+		limit = ob.bidLimits[price]
 	} else {
-		limit = ob.AskLimits[price]
+		limit = ob.askLimits[price]
 	}
 
 	if limit == nil {
-		limit = NewLimit(price)
+		limit = ob.newLimit(price, ob.side(o.Bid))
 
 		if o.Bid {
-			ob.bids = append(ob.bids, limit)
-			ob.BidLimits[price] = limit
+			ob.bids.insert(limit)
+			ob.bidLimits[price] = limit
 		} else {
-			ob.asks = append(ob.asks, limit)
-			ob.AskLimits[price] = limit
+			ob.asks.insert(limit)
+			ob.askLimits[price] = limit
 		}
 	}
 
-	ob.Orders[o.Id] = o
+	o.Sequence = ob.nextSequence()
+
+	ob.orders[o.Id] = o
+	if o.TimeInForce == GTD {
+		ob.expiring[o.Id] = o
+	}
 	limit.AddOrder(o)
 }
 
-func (ob *Orderbook) clearLimit(bid bool, l *Limit) {
+func (ob *Orderbook) newLimit(price decimal.Decimal, side *limitList) *Limit {
+	limit := NewLimit(price)
+	limit.nextSequence = ob.nextSequence
+	limit.cancelled = ob.selfTradeCancelled
+	limit.side = side
+	return limit
+}
+
+func (ob *Orderbook) side(bid bool) *limitList {
 	if bid {
-		delete(ob.BidLimits, l.Price)
+		return ob.bids
+	}
+	return ob.asks
+}
 
-		for i := 0; i < len(ob.bids); i++ {
-			if ob.bids[i] == l {
-				ob.bids[i] = ob.bids[len(ob.bids)-1]
-				ob.bids = ob.bids[:len(ob.bids)-1]
-			}
+// checkVolume fails with ErrVolumeOverflow when size more on the side of bid
+// would no longer fit in a decimal, with it every level and every sum of them fits
+func (ob *Orderbook) checkVolume(bid bool, size decimal.Decimal) error {
+	if _, err := ob.side(bid).volume.CheckedAdd(size); err != nil {
+		return ErrVolumeOverflow
+	}
+	return nil
+}
+
+func (ob *Orderbook) nextSequence() uint64 {
+	ob.sequence++
+	return ob.sequence
+}
+
+// removeOrder stops tracking an order that left the book
+func (ob *Orderbook) removeOrder(o *Order) {
+	delete(ob.orders, o.Id)
+	delete(ob.expiring, o.Id)
+}
+
+// selfTradeCancelled stops tracking a resting order cancelled by self-trade prevention
+func (ob *Orderbook) selfTradeCancelled(o *Order) {
+	ob.removeOrder(o)
+	ob.notifyCancelled(o)
+}
+
+// executableVolume is the visible and hidden volume of a side
+func (ob *Orderbook) executableVolume(bid bool) decimal.Decimal {
+	return ob.side(bid).volume
+}
+
+// volumeUpTo sums the volume of a side at prices that are as good or better than price
+func (ob *Orderbook) volumeUpTo(bid bool, price decimal.Decimal) decimal.Decimal {
+	side := ob.asks
+	if bid {
+		side = ob.bids
+	}
+
+	volume := decimal.Zero
+	side.each(func(l *Limit) bool {
+		if bid && l.Price.LessThan(price) || !bid && l.Price.GreaterThan(price) {
+			return false
 		}
-	} else {
-		delete(ob.AskLimits, l.Price)
+		volume = volume.Add(l.ExecutableVolume())
+		return true
+	})
 
-		for i := 0; i < len(ob.asks); i++ {
-			if ob.asks[i] == l {
-				ob.asks[i] = ob.asks[len(ob.asks)-1]
-				ob.asks = ob.asks[:len(ob.asks)-1]
-			}
+	return volume
+}
+
+// ExpireOrders cancels the GTD orders that expire at or before now
+// and returns them so their owners can be notified, with the matches
+// of the bracket exits placed for the entries that partly filled
+func (ob *Orderbook) ExpireOrders(now int64) ([]*Order, []Match) {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+
+	expired := []*Order{}
+	for _, o := range ob.expiring {
+		if o.ExpiresAt <= now {
+			expired = append(expired, o)
+		}
+	}
+
+	sort.Slice(expired, func(i, j int) bool {
+		return expired[i].Sequence < expired[j].Sequence
+	})
+
+	matches := []Match{}
+	for _, o := range expired {
+		ob.closeOrder(o, StatusExpired)
+		matches = append(matches, ob.cancelGroup(o)...)
+	}
+
+	return expired, ob.cascade(matches)
+}
+
+// NextExpiry returns the earliest expiry of the GTD orders resting in the book,
+// ok is false when there are none
+func (ob *Orderbook) NextExpiry() (expiresAt int64, ok bool) {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+
+	for _, o := range ob.expiring {
+		if !ok || o.ExpiresAt < expiresAt {
+			expiresAt, ok = o.ExpiresAt, true
+		}
+	}
+	return expiresAt, ok
+}
+
+// matchLimitOrder fills o against the opposite side for as long as
+// the best opposite price is within the limit price
+func (ob *Orderbook) matchLimitOrder(price decimal.Decimal, o *Order) ([]Match, error) {
+	matches := []Match{}
+
+	for !o.IsFilled() {
+		limit := ob.bestOpposite(o.Bid)
+		if limit == nil {
+			break
+		}
+		if o.Bid && limit.Price.GreaterThan(price) || !o.Bid && limit.Price.LessThan(price) {
+			break
+		}
+		filled, err := ob.fillLimit(limit, o)
+		matches = append(matches, filled...)
+		if err != nil {
+			return matches, err
+		}
+	}
+
+	return matches, nil
+}
+
+// bestOpposite returns the best level o can be matched against
+func (ob *Orderbook) bestOpposite(bid bool) *Limit {
+	if bid {
+		return ob.asks.best()
+	}
+	return ob.bids.best()
+}
+
+// fillLimit matches o against a level of the opposite side and clears the level once empty
+func (ob *Orderbook) fillLimit(limit *Limit, o *Order) ([]Match, error) {
+	matches, err := limit.Fill(o)
+
+	for _, match := range matches {
+		maker := match.Bid
+		if o.Bid {
+			maker = match.Ask
+		}
+		if maker.IsFilled() {
+			ob.removeOrder(maker)
+		}
+	}
+
+	if limit.Len() == 0 {
+		ob.clearLimit(!o.Bid, limit)
+	}
+
+	return matches, err
+}
+
+// recordTrades turns the matches of the taker order o into trades
+// and stamps every match with the id of its trade
+func (ob *Orderbook) recordTrades(o *Order, matches []Match) {
+	if len(matches) == 0 {
+		return
+	}
+
+	low, high := matches[0].Price, matches[0].Price
+	for i, match := range matches {
+		low = decimal.Min(low, match.Price)
+		high = decimal.Max(high, match.Price)
+
+		maker := match.Bid
+		if o.Bid {
+			maker = match.Ask
+		}
+
+		ob.tradeSequence++
+		trade := &Trade{
+			Id:           ob.nextTradeId(),
+			Sequence:     ob.tradeSequence,
+			Price:        match.Price,
+			Size:         match.SizeFilled,
+			Timestamp:    ob.now(),
+			Bid:          o.Bid,
+			MakerOrderId: maker.Id,
+			TakerOrderId: o.Id,
+			MakerUserId:  maker.UserId,
+			TakerUserId:  o.UserId,
 		}
+		ob.trades = append(ob.trades, trade)
+		matches[i].TradeId = trade.Id
+		matches[i].Taker = o
 	}
 
-	fmt.Printf("clearing the limit price level [%.2f]\n", l.Price)
+	ob.trailStops(low, high)
+}
+
+func (ob *Orderbook) clearLimit(bid bool, l *Limit) {
+	if bid {
+		delete(ob.bidLimits, l.Price)
+		ob.bids.remove(l)
+	} else {
+		delete(ob.askLimits, l.Price)
+		ob.asks.remove(l)
+	}
+
+	fmt.Printf("clearing the limit price level [%s]\n", l.Price)
+
+}
+
+// CancelOrder cancels the resting or pending stop order with the given id.
+// Cancelling the entry of a bracket that partly filled places its exits,
+// their matches are returned.
+func (ob *Orderbook) CancelOrder(id int64) ([]Match, error) {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
 
+	o, ok := ob.orders[id]
+	if !ok {
+		return nil, ErrOrderNotFound
+	}
+
+	ob.closeOrder(o, StatusCancelled)
+
+	return ob.cascade(ob.cancelGroup(o)), nil
 }
 
-func (ob *Orderbook) CancelOrder(o *Order) {
+func (ob *Orderbook) cancelOrder(o *Order) {
+	if o.trigger != nil {
+		ob.removeStop(o)
+		ob.removeOrder(o)
+		return
+	}
+
 	limit := o.Limit
+	if limit == nil {
+		return
+	}
 	limit.RemoveOrder(o)
-	delete(ob.Orders, o.Id)
+	ob.removeOrder(o)
 
-	if len(limit.Orders) == 0 {
+	if limit.Len() == 0 {
 		ob.clearLimit(o.Bid, limit)
 	}
 }
 
-func (ob *Orderbook) BidTotalVolume() float64 {
-	totalVolume := 0.0
+func (ob *Orderbook) BidTotalVolume() decimal.Decimal {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
 
-	for i := 0; i < len(ob.bids); i++ {
-		totalVolume += ob.bids[i].TotalVolume
-	}
+	return totalVolume(ob.bids)
+}
+
+func (ob *Orderbook) AskTotalVolume() decimal.Decimal {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+
+	return totalVolume(ob.asks)
+}
+
+func totalVolume(side *limitList) decimal.Decimal {
+	totalVolume := decimal.Zero
+
+	side.each(func(l *Limit) bool {
+		totalVolume = totalVolume.Add(l.TotalVolume)
+		return true
+	})
 
 	return totalVolume
 }
 
-func (ob *Orderbook) AskTotalVolume() float64 {
-	totalVolume := 0.0
+// Level is a copy of a price level of the book
+type Level struct {
+	Price       decimal.Decimal
+	TotalVolume decimal.Decimal // Visible volume, excludes the reserve of iceberg orders
+	Orders      []*Order        // Copies of the orders in time priority
+}
 
-	for i := 0; i < len(ob.asks); i++ {
-		totalVolume += ob.asks[i].TotalVolume
+func newLevel(l *Limit) *Level {
+	level := &Level{
+		Price:       l.Price,
+		TotalVolume: l.TotalVolume,
+		Orders:      make([]*Order, 0, l.Len()),
+	}
+	for o := l.head; o != nil; o = o.next {
+		level.Orders = append(level.Orders, o.snapshot())
 	}
+	return level
+}
 
-	return totalVolume
+func levels(side *limitList) []*Level {
+	levels := []*Level{}
+	side.each(func(l *Limit) bool {
+		levels = append(levels, newLevel(l))
+		return true
+	})
+	return levels
 }
 
-func (ob *Orderbook) Asks() []*Limit {
-	sort.Sort(ByBestAsk{ob.asks})
-	return ob.asks
+// Asks returns the ask levels ordered from the lowest price
+func (ob *Orderbook) Asks() []*Level {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+
+	return levels(ob.asks)
+}
+
+// Bids returns the bid levels ordered from the highest price
+func (ob *Orderbook) Bids() []*Level {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+
+	return levels(ob.bids)
+}
+
+// BestAsk returns the lowest ask level or nil when there are no asks
+func (ob *Orderbook) BestAsk() *Level {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+
+	if best := ob.asks.best(); best != nil {
+		return newLevel(best)
+	}
+	return nil
+}
+
+// BestBid returns the highest bid level or nil when there are no bids
+func (ob *Orderbook) BestBid() *Level {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+
+	if best := ob.bids.best(); best != nil {
+		return newLevel(best)
+	}
+	return nil
+}
+
+// Trades returns the trades of the book, oldest first
+func (ob *Orderbook) Trades() []*Trade {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+
+	trades := make([]*Trade, len(ob.trades))
+	copy(trades, ob.trades)
+	return trades
+}
+
+// TradesSince returns the trades with a sequence greater than sequence, oldest first
+func (ob *Orderbook) TradesSince(sequence uint64) []*Trade {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+
+	i := sort.Search(len(ob.trades), func(i int) bool {
+		return ob.trades[i].Sequence > sequence
+	})
+	trades := make([]*Trade, len(ob.trades)-i)
+	copy(trades, ob.trades[i:])
+	return trades
+}
+
+// LastPrice returns the price of the last trade, ok is false when nothing traded yet
+func (ob *Orderbook) LastPrice() (price decimal.Decimal, ok bool) {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+
+	if len(ob.trades) == 0 {
+		return price, false
+	}
+	return ob.trades[len(ob.trades)-1].Price, true
+}
+
+// Order returns a copy of the resting or pending stop order with the given id
+func (ob *Orderbook) Order(id int64) (*Order, bool) {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+
+	o, ok := ob.orders[id]
+	if !ok {
+		return nil, false
+	}
+	return o.snapshot(), true
+}
+
+// Snapshot returns a copy of o taken while the book is not changing it
+func (ob *Orderbook) Snapshot(o *Order) *Order {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+
+	return o.snapshot()
+}
+
+// SnapshotGroup returns a copy of g and its orders taken while the book is not changing them
+func (ob *Orderbook) SnapshotGroup(g *OrderGroup) *OrderGroup {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+
+	s := *g
+	for _, o := range []**Order{&s.Entry, &s.TakeProfit, &s.StopLoss} {
+		if *o != nil {
+			*o = (*o).snapshot()
+		}
+	}
+	return &s
 }
 
-func (ob *Orderbook) Bids() []*Limit {
-	sort.Sort(ByBestBid{ob.bids})
-	return ob.bids
+// snapshot copies o without its pointers into the book,
+// IsResting and IsStopPending of the copy tell what o was doing
+func (o *Order) snapshot() *Order {
+	s := *o
+	s.copyResting = o.IsResting()
+	s.copyPending = o.IsStopPending()
+	s.Limit = nil
+	s.prev = nil
+	s.next = nil
+	s.trigger = nil
+	s.group = nil
+	return &s
 }
@@ -1,9 +1,15 @@
 package orderbook
 
 import (
+	"errors"
 	"fmt"
+	"math/rand"
 	"reflect"
+	"sort"
 	"testing"
+	"time"
+
+	"github.com/PanGan21/crypto-exchange-poc/decimal"
 )
 
 func assert(t *testing.T, a, b any) {
@@ -14,28 +20,29 @@ func assert(t *testing.T, a, b any) {
 
 func TestLastMarketTrades(t *testing.T) {
 	ob := NewOrderbook()
-	price := 10000.0
+	price := decimal.NewFromInt(10000)
 
-	sellOrder := NewOrder(false, 10, 0)
+	sellOrder := NewOrder(false, decimal.NewFromInt(10), 0)
 	ob.PlaceLimitOrder(price, sellOrder)
 
-	marketOrder := NewOrder(true, 10, 0)
-	matches := ob.PlaceMarketOrder(marketOrder)
+	marketOrder := NewOrder(true, decimal.NewFromInt(10), 0)
+	matches, err := ob.PlaceMarketOrder(marketOrder)
+	assert(t, err, nil)
 	assert(t, len(matches), 1)
 	match := matches[0]
 
-	assert(t, len(ob.Trades), 1)
-	trade := ob.Trades[0]
+	assert(t, len(ob.Trades()), 1)
+	trade := ob.Trades()[0]
 	assert(t, trade.Price, price)
 	assert(t, trade.Bid, marketOrder.Bid)
 	assert(t, trade.Size, match.SizeFilled)
 }
 
 func TestLimit(t *testing.T) {
-	l := NewLimit(10_000)
-	buyOrderA := NewOrder(true, 5, 0)
-	buyOrderB := NewOrder(true, 8, 0)
-	buyOrderC := NewOrder(true, 10, 0)
+	l := NewLimit(decimal.NewFromInt(10_000))
+	buyOrderA := NewOrder(true, decimal.NewFromInt(5), 0)
+	buyOrderB := NewOrder(true, decimal.NewFromInt(8), 0)
+	buyOrderC := NewOrder(true, decimal.NewFromInt(10), 0)
 
 	l.AddOrder(buyOrderA)
 	l.AddOrder(buyOrderB)
@@ -43,99 +50,616 @@ func TestLimit(t *testing.T) {
 
 	l.RemoveOrder(buyOrderB)
 
+	assert(t, l.Len(), 2)
+	assert(t, l.Orders(), []*Order{buyOrderA, buyOrderC})
+	assert(t, l.TotalVolume, decimal.NewFromInt(15))
+	assert(t, buyOrderB.Limit == nil, true)
+
 	fmt.Println(l)
 }
+
+func TestLimitTimePriority(t *testing.T) {
+	ob := NewOrderbook()
+	price := decimal.NewFromInt(10_000)
+
+	sellOrderA := NewOrder(false, decimal.NewFromInt(2), 0)
+	sellOrderB := NewOrder(false, decimal.NewFromInt(2), 1)
+	sellOrderC := NewOrder(false, decimal.NewFromInt(2), 2)
+	// same wall clock time must not change the queue order
+	sellOrderB.Timestamp = sellOrderA.Timestamp
+	sellOrderC.Timestamp = sellOrderA.Timestamp
+
+	ob.PlaceLimitOrder(price, sellOrderA)
+	ob.PlaceLimitOrder(price, sellOrderB)
+	ob.PlaceLimitOrder(price, sellOrderC)
+
+	assert(t, sellOrderA.Sequence < sellOrderB.Sequence, true)
+	assert(t, sellOrderB.Sequence < sellOrderC.Sequence, true)
+
+	ob.CancelOrder(sellOrderB.Id)
+	sellOrderD := NewOrder(false, decimal.NewFromInt(2), 3)
+	ob.PlaceLimitOrder(price, sellOrderD)
+
+	limit := ob.askLimits[price]
+	assert(t, limit.Orders(), []*Order{sellOrderA, sellOrderC, sellOrderD})
+	assert(t, limit.Front(), sellOrderA)
+
+	matches, err := ob.PlaceMarketOrder(NewOrder(true, decimal.NewFromInt(3), 4))
+	assert(t, err, nil)
+	assert(t, len(matches), 2)
+	assert(t, matches[0].Ask, sellOrderA)
+	assert(t, matches[1].Ask, sellOrderC)
+	assert(t, limit.Orders(), []*Order{sellOrderC, sellOrderD})
+	assert(t, limit.TotalVolume, decimal.NewFromInt(3))
+}
 func TestPlaceLimitOrder(t *testing.T) {
 	ob := NewOrderbook()
 
-	sellOrderA := NewOrder(false, 10, 0)
-	sellOrderB := NewOrder(false, 5, 0)
-	ob.PlaceLimitOrder(10_000, sellOrderA)
-	ob.PlaceLimitOrder(9_000, sellOrderB)
+	sellOrderA := NewOrder(false, decimal.NewFromInt(10), 0)
+	sellOrderB := NewOrder(false, decimal.NewFromInt(5), 0)
+	ob.PlaceLimitOrder(decimal.NewFromInt(10_000), sellOrderA)
+	ob.PlaceLimitOrder(decimal.NewFromInt(9_000), sellOrderB)
 
-	assert(t, len(ob.Orders), 2)
-	assert(t, ob.Orders[sellOrderA.Id], sellOrderA)
-	assert(t, ob.Orders[sellOrderB.Id], sellOrderB)
-	assert(t, len(ob.asks), 2)
+	assert(t, len(ob.orders), 2)
+	assert(t, ob.orders[sellOrderA.Id], sellOrderA)
+	assert(t, ob.orders[sellOrderB.Id], sellOrderB)
+	assert(t, ob.asks.len(), 2)
 }
 
 func TestPlaceMarketOrder(t *testing.T) {
 	ob := NewOrderbook()
 
 	// Provide liquidity
-	sellOrder := NewOrder(false, 20, 0)
-	ob.PlaceLimitOrder(10_000, sellOrder)
+	sellOrder := NewOrder(false, decimal.NewFromInt(20), 0)
+	ob.PlaceLimitOrder(decimal.NewFromInt(10_000), sellOrder)
 
-	buyOrder := NewOrder(true, 10, 0)
-	matches := ob.PlaceMarketOrder(buyOrder)
+	buyOrder := NewOrder(true, decimal.NewFromInt(10), 0)
+	matches, err := ob.PlaceMarketOrder(buyOrder)
+	assert(t, err, nil)
 
 	assert(t, len(matches), 1)
-	assert(t, len(ob.asks), 1)
-	assert(t, ob.AskTotalVolume(), 10.0)
+	assert(t, ob.asks.len(), 1)
+	assert(t, ob.AskTotalVolume(), decimal.NewFromInt(10))
 	assert(t, matches[0].Ask, sellOrder)
 	assert(t, matches[0].Bid, buyOrder)
-	assert(t, matches[0].SizeFilled, 10.0)
-	assert(t, matches[0].Price, 10_000.0)
+	assert(t, matches[0].SizeFilled, decimal.NewFromInt(10))
+	assert(t, matches[0].Price, decimal.NewFromInt(10_000))
 	assert(t, buyOrder.IsFilled(), true)
 }
 
 func TestPlaceMarketOrderMultiFill(t *testing.T) {
 	ob := NewOrderbook()
 
-	buydOrderA := NewOrder(true, 5, 0)
-	buydOrderB := NewOrder(true, 8, 0)
-	buydOrderC := NewOrder(true, 1, 0)
-	buydOrderD := NewOrder(true, 1, 0)
+	buydOrderA := NewOrder(true, decimal.NewFromInt(5), 0)
+	buydOrderB := NewOrder(true, decimal.NewFromInt(8), 0)
+	buydOrderC := NewOrder(true, decimal.NewFromInt(1), 0)
+	buydOrderD := NewOrder(true, decimal.NewFromInt(1), 0)
 
-	ob.PlaceLimitOrder(5_000, buydOrderC)
-	ob.PlaceLimitOrder(5_000, buydOrderD)
-	ob.PlaceLimitOrder(9_000, buydOrderB)
-	ob.PlaceLimitOrder(10_000, buydOrderA)
+	ob.PlaceLimitOrder(decimal.NewFromInt(5_000), buydOrderC)
+	ob.PlaceLimitOrder(decimal.NewFromInt(5_000), buydOrderD)
+	ob.PlaceLimitOrder(decimal.NewFromInt(9_000), buydOrderB)
+	ob.PlaceLimitOrder(decimal.NewFromInt(10_000), buydOrderA)
 
-	assert(t, ob.BidTotalVolume(), float64(1+8+5+1))
+	assert(t, ob.BidTotalVolume(), decimal.NewFromInt(1+8+5+1))
 
-	sellOrder := NewOrder(false, 10, 0)
-	matches := ob.PlaceMarketOrder(sellOrder)
+	sellOrder := NewOrder(false, decimal.NewFromInt(10), 0)
+	matches, err := ob.PlaceMarketOrder(sellOrder)
+	assert(t, err, nil)
 
-	assert(t, ob.BidTotalVolume(), 5.00) // (1 + 8 + 5 + 1) - 10 = 5
+	assert(t, ob.BidTotalVolume(), decimal.NewFromInt(5)) // (1 + 8 + 5 + 1) - 10 = 5
 	assert(t, len(matches), 2)
-	assert(t, len(ob.bids), 2)
+	assert(t, ob.bids.len(), 2)
 }
 
 func TestCancelOrderBid(t *testing.T) {
 	ob := NewOrderbook()
 
-	buyOrder := NewOrder(true, 4, 0)
-	price := 10_000.0
+	buyOrder := NewOrder(true, decimal.NewFromInt(4), 0)
+	price := decimal.NewFromInt(10_000)
 
 	ob.PlaceLimitOrder(price, buyOrder)
-	assert(t, ob.BidTotalVolume(), 4.0)
+	assert(t, ob.BidTotalVolume(), decimal.NewFromInt(4))
 
-	ob.CancelOrder(buyOrder)
-	assert(t, ob.BidTotalVolume(), 0.0)
+	ob.CancelOrder(buyOrder.Id)
+	assert(t, ob.BidTotalVolume(), decimal.Zero)
 
-	_, ok := ob.Orders[buyOrder.Id]
+	_, ok := ob.orders[buyOrder.Id]
 	assert(t, ok, false)
 
-	_, ok = ob.BidLimits[price]
+	_, ok = ob.bidLimits[price]
 	assert(t, ok, false)
 }
 
 func TestCancelOrderAsk(t *testing.T) {
 	ob := NewOrderbook()
 
-	sellOrder := NewOrder(false, 4, 0)
-	price := 10_000.0
+	sellOrder := NewOrder(false, decimal.NewFromInt(4), 0)
+	price := decimal.NewFromInt(10_000)
 
 	ob.PlaceLimitOrder(price, sellOrder)
-	assert(t, ob.AskTotalVolume(), 4.0)
+	assert(t, ob.AskTotalVolume(), decimal.NewFromInt(4))
+
+	ob.CancelOrder(sellOrder.Id)
+	assert(t, ob.AskTotalVolume(), decimal.Zero)
+
+	_, ok := ob.orders[sellOrder.Id]
+	assert(t, ok, false)
+
+	_, ok = ob.askLimits[price]
+	assert(t, ok, false)
+}
+
+func TestPlaceLimitOrderCrossingFullFill(t *testing.T) {
+	ob := NewOrderbook()
 
-	ob.CancelOrder(sellOrder)
-	assert(t, ob.AskTotalVolume(), 0.0)
+	sellOrder := NewOrder(false, decimal.NewFromInt(10), 0)
+	ob.PlaceLimitOrder(decimal.NewFromInt(10_000), sellOrder)
 
-	_, ok := ob.Orders[sellOrder.Id]
+	buyOrder := NewOrder(true, decimal.NewFromInt(5), 1)
+	matches, err := ob.PlaceLimitOrder(decimal.NewFromInt(10_500), buyOrder)
+	assert(t, err, nil)
+
+	assert(t, len(matches), 1)
+	assert(t, matches[0].Price, decimal.NewFromInt(10_000))
+	assert(t, matches[0].SizeFilled, decimal.NewFromInt(5))
+	assert(t, buyOrder.IsFilled(), true)
+	assert(t, ob.bids.len(), 0)
+	assert(t, ob.AskTotalVolume(), decimal.NewFromInt(5))
+	assert(t, len(ob.Trades()), 1)
+
+	_, ok := ob.orders[buyOrder.Id]
 	assert(t, ok, false)
+}
+
+func TestPlaceLimitOrderCrossingRestsRemainder(t *testing.T) {
+	ob := NewOrderbook()
+
+	sellOrderA := NewOrder(false, decimal.NewFromInt(5), 0)
+	sellOrderB := NewOrder(false, decimal.NewFromInt(5), 0)
+	sellOrderC := NewOrder(false, decimal.NewFromInt(5), 0)
+	ob.PlaceLimitOrder(decimal.NewFromInt(10_000), sellOrderA)
+	ob.PlaceLimitOrder(decimal.NewFromInt(10_100), sellOrderB)
+	ob.PlaceLimitOrder(decimal.NewFromInt(10_200), sellOrderC)
+
+	buyOrder := NewOrder(true, decimal.NewFromInt(12), 1)
+	matches, err := ob.PlaceLimitOrder(decimal.NewFromInt(10_100), buyOrder)
+	assert(t, err, nil)
+
+	assert(t, len(matches), 2)
+	assert(t, matches[0].Price, decimal.NewFromInt(10_000))
+	assert(t, matches[1].Price, decimal.NewFromInt(10_100))
+	assert(t, buyOrder.Size, decimal.NewFromInt(2))
+	assert(t, ob.BidTotalVolume(), decimal.NewFromInt(2))
+	assert(t, ob.AskTotalVolume(), decimal.NewFromInt(5))
+	assert(t, ob.Bids()[0].Price, decimal.NewFromInt(10_100))
+	assert(t, ob.Asks()[0].Price, decimal.NewFromInt(10_200))
+}
+
+func TestPlaceLimitOrderNotCrossing(t *testing.T) {
+	ob := NewOrderbook()
+
+	ob.PlaceLimitOrder(decimal.NewFromInt(10_000), NewOrder(false, decimal.NewFromInt(5), 0))
+	matches, err := ob.PlaceLimitOrder(decimal.NewFromInt(9_000), NewOrder(true, decimal.NewFromInt(5), 0))
+	assert(t, err, nil)
+
+	assert(t, len(matches), 0)
+	assert(t, ob.BidTotalVolume(), decimal.NewFromInt(5))
+	assert(t, ob.AskTotalVolume(), decimal.NewFromInt(5))
+	assert(t, len(ob.Trades()), 0)
+}
+
+func TestFractionalSizesFillExactly(t *testing.T) {
+	ob := NewOrderbook()
+	price := decimal.RequireFromString("0.3")
+
+	ob.PlaceLimitOrder(decimal.RequireFromString("0.1").Add(decimal.RequireFromString("0.2")), NewOrder(false, decimal.RequireFromString("0.1"), 0))
+	ob.PlaceLimitOrder(price, NewOrder(false, decimal.RequireFromString("0.2"), 0))
+	assert(t, ob.asks.len(), 1)
+	assert(t, ob.AskTotalVolume(), price)
+
+	buyOrder := NewOrder(true, decimal.RequireFromString("0.3"), 0)
+	matches, err := ob.PlaceMarketOrder(buyOrder)
+	assert(t, err, nil)
+
+	assert(t, len(matches), 2)
+	assert(t, buyOrder.IsFilled(), true)
+	assert(t, ob.AskTotalVolume(), decimal.Zero)
+	assert(t, ob.asks.len(), 0)
+}
+
+func TestLimitListOrdering(t *testing.T) {
+	asks := newAskList()
+	bids := newBidList()
+	limits := map[int64]*Limit{}
+
+	rnd := rand.New(rand.NewSource(42))
+	for i := 0; i < 1000; i++ {
+		price := int64(rnd.Intn(500))
+		if _, ok := limits[price]; ok {
+			continue
+		}
+		limits[price] = NewLimit(decimal.NewFromInt(price))
+		asks.insert(limits[price])
+		bids.insert(limits[price])
+	}
+
+	// remove every other level
+	for price, limit := range limits {
+		if price%2 == 0 {
+			assert(t, asks.remove(limit), true)
+			assert(t, bids.remove(limit), true)
+			delete(limits, price)
+		}
+	}
+	assert(t, asks.remove(NewLimit(decimal.NewFromInt(-1))), false)
+	assert(t, asks.len(), len(limits))
+	assert(t, bids.len(), len(limits))
+
+	askLimits := asks.limits()
+	for i := 1; i < len(askLimits); i++ {
+		if !askLimits[i-1].Price.LessThan(askLimits[i].Price) {
+			t.Fatalf("asks not sorted at %d: %s >= %s", i, askLimits[i-1].Price, askLimits[i].Price)
+		}
+	}
+	bidLimits := bids.limits()
+	for i := 1; i < len(bidLimits); i++ {
+		if !bidLimits[i-1].Price.GreaterThan(bidLimits[i].Price) {
+			t.Fatalf("bids not sorted at %d: %s <= %s", i, bidLimits[i-1].Price, bidLimits[i].Price)
+		}
+	}
+
+	assert(t, asks.best(), askLimits[0])
+	assert(t, bids.best(), bidLimits[0])
+}
+
+func TestBestBidAsk(t *testing.T) {
+	ob := NewOrderbook()
+	assert(t, ob.BestAsk() == nil, true)
+	assert(t, ob.BestBid() == nil, true)
+
+	ob.PlaceLimitOrder(decimal.NewFromInt(10_200), NewOrder(false, decimal.NewFromInt(1), 0))
+	ob.PlaceLimitOrder(decimal.NewFromInt(10_100), NewOrder(false, decimal.NewFromInt(1), 0))
+	ob.PlaceLimitOrder(decimal.NewFromInt(9_000), NewOrder(true, decimal.NewFromInt(1), 0))
+	ob.PlaceLimitOrder(decimal.NewFromInt(9_500), NewOrder(true, decimal.NewFromInt(1), 0))
+
+	assert(t, ob.BestAsk().Price, decimal.NewFromInt(10_100))
+	assert(t, ob.BestBid().Price, decimal.NewFromInt(9_500))
+
+	ob.PlaceMarketOrder(NewOrder(true, decimal.NewFromInt(1), 0))
+	assert(t, ob.BestAsk().Price, decimal.NewFromInt(10_200))
+}
+
+const benchLevels = 10_000
+
+func newBenchOrderbook(levels int) *Orderbook {
+	ob := NewOrderbook()
+	for i := 0; i < levels; i++ {
+		ob.PlaceLimitOrder(decimal.NewFromInt(int64(10_000+i)), NewOrder(false, decimal.NewFromInt(1), 0))
+		ob.PlaceLimitOrder(decimal.NewFromInt(int64(9_999-i)), NewOrder(true, decimal.NewFromInt(1), 0))
+	}
+	return ob
+}
+
+func BenchmarkBestAsk(b *testing.B) {
+	ob := newBenchOrderbook(benchLevels)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if ob.BestAsk() == nil {
+			b.Fatal("empty book")
+		}
+	}
+}
+
+// BenchmarkBestAskSortedSlice measures the previous approach of sorting
+// the whole side on every access
+func BenchmarkBestAskSortedSlice(b *testing.B) {
+	limits := newBenchOrderbook(benchLevels).asks.limits()
+	rand.New(rand.NewSource(1)).Shuffle(len(limits), func(i, j int) { limits[i], limits[j] = limits[j], limits[i] })
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		sort.Sort(ByBestAsk{limits})
+		if limits[0] == nil {
+			b.Fatal("empty book")
+		}
+	}
+}
+
+func BenchmarkLimitListInsertRemove(b *testing.B) {
+	asks := newAskList()
+	for i := 0; i < benchLevels; i++ {
+		asks.insert(NewLimit(decimal.NewFromInt(int64(i * 2))))
+	}
+	rnd := rand.New(rand.NewSource(1))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		limit := NewLimit(decimal.NewFromInt(int64(rnd.Intn(benchLevels)*2 + 1)))
+		asks.insert(limit)
+		asks.remove(limit)
+	}
+}
+
+// BenchmarkSortedSliceInsertRemove measures the previous approach of
+// appending a level, sorting and removing it with a linear scan
+func BenchmarkSortedSliceInsertRemove(b *testing.B) {
+	limits := Limits{}
+	for i := 0; i < benchLevels; i++ {
+		limits = append(limits, NewLimit(decimal.NewFromInt(int64(i*2))))
+	}
+	rnd := rand.New(rand.NewSource(1))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		limit := NewLimit(decimal.NewFromInt(int64(rnd.Intn(benchLevels)*2 + 1)))
+		limits = append(limits, limit)
+		sort.Sort(ByBestAsk{limits})
+		for j := 0; j < len(limits); j++ {
+			if limits[j] == limit {
+				limits[j] = limits[len(limits)-1]
+				limits = limits[:len(limits)-1]
+				break
+			}
+		}
+	}
+}
+
+func TestOrderAndTradeIds(t *testing.T) {
+	ids := NewIDGenerator()
+	obA := NewOrderbook(WithIDGenerator(ids))
+	obB := NewOrderbook(WithIDGenerator(ids))
+
+	sellOrderA := NewOrder(false, decimal.NewFromInt(5), 1)
+	sellOrderB := NewOrder(false, decimal.NewFromInt(5), 2)
+	obA.PlaceLimitOrder(decimal.NewFromInt(10_000), sellOrderA)
+	obB.PlaceLimitOrder(decimal.NewFromInt(10_000), sellOrderB)
+	assert(t, sellOrderA.Id, int64(1))
+	assert(t, sellOrderB.Id, int64(2))
+
+	buyOrder := NewOrder(true, decimal.NewFromInt(2), 3)
+	matches, err := obA.PlaceLimitOrder(decimal.NewFromInt(10_000), buyOrder)
+	assert(t, err, nil)
+	assert(t, buyOrder.Id, int64(3))
+	assert(t, len(matches), 1)
+
+	marketOrder := NewOrder(true, decimal.NewFromInt(2), 4)
+	obA.PlaceMarketOrder(marketOrder)
+	assert(t, marketOrder.Id, int64(4))
+
+	assert(t, len(obA.Trades()), 2)
+	for i, trade := range obA.Trades() {
+		assert(t, trade.Id, int64(i+1))
+		assert(t, trade.Sequence, uint64(i+1))
+		assert(t, trade.MakerOrderId, sellOrderA.Id)
+		assert(t, trade.MakerUserId, int64(1))
+	}
+	assert(t, matches[0].TradeId, obA.Trades()[0].Id)
+	assert(t, matches[0].Taker, buyOrder)
+	assert(t, obA.Trades()[0].TakerOrderId, buyOrder.Id)
+	assert(t, obA.Trades()[0].TakerUserId, int64(3))
+	assert(t, obA.Trades()[1].TakerOrderId, marketOrder.Id)
+
+	// the trade sequence is per book while trade ids are shared
+	obB.PlaceMarketOrder(NewOrder(true, decimal.NewFromInt(1), 5))
+	assert(t, obB.Trades()[0].Sequence, uint64(1))
+	assert(t, obB.Trades()[0].Id, int64(3))
+}
+
+func TestPlaceMarketOrderIOCPartialFill(t *testing.T) {
+	ob := NewOrderbook()
+	ob.PlaceLimitOrder(decimal.NewFromInt(10_000), NewOrder(false, decimal.NewFromInt(3), 0))
+	ob.PlaceLimitOrder(decimal.NewFromInt(10_100), NewOrder(false, decimal.NewFromInt(2), 0))
+
+	buyOrder := NewOrder(true, decimal.NewFromInt(8), 1)
+	buyOrder.TimeInForce = IOC
+	matches, err := ob.PlaceMarketOrder(buyOrder)
 
-	_, ok = ob.AskLimits[price]
+	assert(t, err, nil)
+	assert(t, len(matches), 2)
+	assert(t, buyOrder.Size, decimal.NewFromInt(3))
+	assert(t, ob.AskTotalVolume(), decimal.Zero)
+	assert(t, ob.asks.len(), 0)
+	assert(t, len(ob.Trades()), 2)
+}
+
+func TestPlaceMarketOrderFOKRejected(t *testing.T) {
+	ob := NewOrderbook()
+	sellOrder := NewOrder(false, decimal.NewFromInt(3), 0)
+	ob.PlaceLimitOrder(decimal.NewFromInt(10_000), sellOrder)
+
+	buyOrder := NewOrder(true, decimal.NewFromInt(8), 1)
+	buyOrder.TimeInForce = FOK
+	matches, err := ob.PlaceMarketOrder(buyOrder)
+
+	var volumeErr *InsufficientVolumeError
+	assert(t, errors.As(err, &volumeErr), true)
+	assert(t, volumeErr.Requested, decimal.NewFromInt(8))
+	assert(t, volumeErr.Available, decimal.NewFromInt(3))
+	assert(t, len(matches), 0)
+	assert(t, sellOrder.Size, decimal.NewFromInt(3))
+	assert(t, ob.AskTotalVolume(), decimal.NewFromInt(3))
+	assert(t, len(ob.Trades()), 0)
+}
+
+func TestPlaceMarketOrderEmptyBook(t *testing.T) {
+	ob := NewOrderbook()
+
+	matches, err := ob.PlaceMarketOrder(NewOrder(false, decimal.NewFromInt(1), 1))
+
+	var volumeErr *InsufficientVolumeError
+	assert(t, errors.As(err, &volumeErr), true)
+	assert(t, volumeErr.Available, decimal.Zero)
+	assert(t, len(matches), 0)
+}
+
+func TestPlaceLimitOrderIOC(t *testing.T) {
+	ob := NewOrderbook()
+	ob.PlaceLimitOrder(decimal.NewFromInt(10_000), NewOrder(false, decimal.NewFromInt(3), 0))
+	ob.PlaceLimitOrder(decimal.NewFromInt(10_200), NewOrder(false, decimal.NewFromInt(3), 0))
+
+	buyOrder := NewOrder(true, decimal.NewFromInt(5), 1)
+	buyOrder.TimeInForce = IOC
+	matches, err := ob.PlaceLimitOrder(decimal.NewFromInt(10_100), buyOrder)
+
+	assert(t, err, nil)
+	assert(t, len(matches), 1)
+	assert(t, buyOrder.Size, decimal.NewFromInt(2))
+	assert(t, buyOrder.Limit == nil, true)
+	assert(t, ob.bids.len(), 0)
+	_, ok := ob.orders[buyOrder.Id]
 	assert(t, ok, false)
 }
+
+func TestPlaceLimitOrderFOK(t *testing.T) {
+	ob := NewOrderbook()
+	ob.PlaceLimitOrder(decimal.NewFromInt(10_000), NewOrder(false, decimal.NewFromInt(3), 0))
+	ob.PlaceLimitOrder(decimal.NewFromInt(10_200), NewOrder(false, decimal.NewFromInt(3), 0))
+
+	// only 3 are available up to the limit price
+	buyOrder := NewOrder(true, decimal.NewFromInt(5), 1)
+	buyOrder.TimeInForce = FOK
+	matches, err := ob.PlaceLimitOrder(decimal.NewFromInt(10_100), buyOrder)
+
+	var volumeErr *InsufficientVolumeError
+	assert(t, errors.As(err, &volumeErr), true)
+	assert(t, volumeErr.Available, decimal.NewFromInt(3))
+	assert(t, len(matches), 0)
+	assert(t, ob.AskTotalVolume(), decimal.NewFromInt(6))
+
+	buyOrder = NewOrder(true, decimal.NewFromInt(5), 1)
+	buyOrder.TimeInForce = FOK
+	matches, err = ob.PlaceLimitOrder(decimal.NewFromInt(10_200), buyOrder)
+
+	assert(t, err, nil)
+	assert(t, len(matches), 2)
+	assert(t, buyOrder.IsFilled(), true)
+	assert(t, ob.AskTotalVolume(), decimal.NewFromInt(1))
+}
+
+func TestExpireGTDOrders(t *testing.T) {
+	ob := NewOrderbook()
+	now := time.Now().UnixNano()
+
+	expired := NewOrder(true, decimal.NewFromInt(3), 1)
+	expired.TimeInForce = GTD
+	expired.ExpiresAt = now + int64(time.Minute)
+	ob.PlaceLimitOrder(decimal.NewFromInt(9_000), expired)
+
+	later := NewOrder(true, decimal.NewFromInt(3), 1)
+	later.TimeInForce = GTD
+	later.ExpiresAt = now + int64(time.Hour)
+	ob.PlaceLimitOrder(decimal.NewFromInt(9_000), later)
+
+	filled := NewOrder(true, decimal.NewFromInt(3), 1)
+	filled.TimeInForce = GTD
+	filled.ExpiresAt = now + int64(time.Minute)
+	ob.PlaceLimitOrder(decimal.NewFromInt(9_500), filled)
+	ob.PlaceMarketOrder(NewOrder(false, decimal.NewFromInt(3), 2))
+
+	none, _ := ob.ExpireOrders(now)
+	assert(t, none, []*Order{})
+	expiredOrders, matches := ob.ExpireOrders(now + int64(2*time.Minute))
+	assert(t, expiredOrders, []*Order{expired})
+	assert(t, matches, []Match{})
+	assert(t, expired.Limit == nil, true)
+	assert(t, ob.BidTotalVolume(), decimal.NewFromInt(3))
+	none, _ = ob.ExpireOrders(now + int64(2*time.Minute))
+	assert(t, none, []*Order{})
+
+	_, err := ob.PlaceLimitOrder(decimal.NewFromInt(9_000), &Order{Bid: true, Size: decimal.NewFromInt(1), TimeInForce: GTD, ExpiresAt: now})
+	assert(t, err, ErrInvalidExpiry)
+}
+
+func TestPostOnlyReject(t *testing.T) {
+	ob := NewOrderbook()
+	ob.PlaceLimitOrder(decimal.NewFromInt(10_000), NewOrder(false, decimal.NewFromInt(3), 0))
+
+	buyOrder := NewOrder(true, decimal.NewFromInt(1), 1)
+	buyOrder.PostOnly = PostOnlyReject
+	matches, err := ob.PlaceLimitOrder(decimal.NewFromInt(10_000), buyOrder)
+
+	assert(t, err, ErrPostOnlyWouldTake)
+	assert(t, len(matches), 0)
+	assert(t, ob.AskTotalVolume(), decimal.NewFromInt(3))
+	assert(t, ob.bids.len(), 0)
+
+	buyOrder = NewOrder(true, decimal.NewFromInt(1), 1)
+	buyOrder.PostOnly = PostOnlyReject
+	_, err = ob.PlaceLimitOrder(decimal.NewFromInt(9_999), buyOrder)
+
+	assert(t, err, nil)
+	assert(t, ob.BestBid().Price, decimal.NewFromInt(9_999))
+}
+
+func TestPostOnlySlide(t *testing.T) {
+	ob := NewOrderbook(WithTickSize(decimal.RequireFromString("0.5")))
+	ob.PlaceLimitOrder(decimal.NewFromInt(10_000), NewOrder(false, decimal.NewFromInt(3), 0))
+	ob.PlaceLimitOrder(decimal.NewFromInt(9_000), NewOrder(true, decimal.NewFromInt(3), 0))
+
+	buyOrder := NewOrder(true, decimal.NewFromInt(1), 1)
+	buyOrder.PostOnly = PostOnlySlide
+	matches, err := ob.PlaceLimitOrder(decimal.NewFromInt(10_100), buyOrder)
+
+	assert(t, err, nil)
+	assert(t, len(matches), 0)
+	assert(t, buyOrder.Price, decimal.RequireFromString("9999.5"))
+	assert(t, ob.BestBid().Price, decimal.RequireFromString("9999.5"))
+
+	sellOrder := NewOrder(false, decimal.NewFromInt(1), 1)
+	sellOrder.PostOnly = PostOnlySlide
+	_, err = ob.PlaceLimitOrder(decimal.NewFromInt(9_000), sellOrder)
+
+	assert(t, err, nil)
+	assert(t, sellOrder.Price, decimal.NewFromInt(10_000))
+	assert(t, ob.askLimits[decimal.NewFromInt(10_000)].Len(), 2)
+	assert(t, len(ob.Trades()), 0)
+}
+
+func TestOrderCopyHasNoBookPointers(t *testing.T) {
+	ob := NewOrderbook()
+	ask := NewOrder(false, decimal.NewFromInt(2), 1)
+	ob.PlaceLimitOrder(decimal.NewFromInt(100), ask)
+	stop := NewOrder(true, decimal.NewFromInt(1), 1)
+	stop.StopPrice = decimal.NewFromInt(110)
+	assert(t, ob.PlaceStopOrder(stop), nil)
+
+	c := ob.Snapshot(ask)
+	assert(t, c.Limit == nil, true)
+	assert(t, c.IsResting(), true)
+	assert(t, ob.Snapshot(stop).IsStopPending(), true)
+
+	ob.PlaceMarketOrder(NewOrder(true, decimal.NewFromInt(2), 2))
+	assert(t, ob.Snapshot(ask).IsResting(), false)
+	assert(t, c.IsResting(), true)
+}
+
+func TestVolumeOverflow(t *testing.T) {
+	ob := NewOrderbook(WithTickSize(decimal.RequireFromString("0.01")))
+	size := decimal.NewFromInt(5_000_000_000_000_000_000)
+
+	_, err := ob.PlaceLimitOrder(decimal.RequireFromString("0.01"), NewOrder(false, size, 1))
+	assert(t, err, nil)
+
+	// the second order fits on its own but not with the first one, even at another price
+	order := NewOrder(false, size, 1)
+	_, err = ob.PlaceLimitOrder(decimal.RequireFromString("0.02"), order)
+	assert(t, err, ErrVolumeOverflow)
+	assert(t, order.Status, StatusRejected)
+	assert(t, ob.AskTotalVolume(), size)
+
+	small := NewOrder(false, decimal.NewFromInt(1), 1)
+	_, err = ob.PlaceLimitOrder(decimal.RequireFromString("0.02"), small)
+	assert(t, err, nil)
+
+	_, _, err = ob.ModifyOrder(small.Id, 1, small.Price, size)
+	assert(t, err, ErrVolumeOverflow)
+	assert(t, small.Size, decimal.NewFromInt(1))
+
+	// the volume taken out of the book makes room again
+	_, err = ob.PlaceMarketOrder(NewOrder(true, size, 2))
+	assert(t, err, nil)
+	_, err = ob.PlaceLimitOrder(decimal.RequireFromString("0.02"), NewOrder(false, size, 1))
+	assert(t, err, nil)
+	assert(t, ob.AskTotalVolume(), size.Add(decimal.NewFromInt(1)))
+}
@@ -0,0 +1,171 @@
+package orderbook
+
+import (
+	"errors"
+
+	"github.com/PanGan21/crypto-exchange-poc/decimal"
+)
+
+// Peg is the reference price a pegged order tracks
+type Peg string
+
+const (
+	// PegPrimary tracks the best price of the side of the order
+	PegPrimary Peg = "PRIMARY"
+	// PegMid tracks the midpoint between the best bid and the best ask
+	PegMid Peg = "MID"
+)
+
+var (
+	ErrInvalidPeg     = errors.New("pegged orders need a PRIMARY or MID peg, a GTC or GTD time in force and a positive cap")
+	ErrNoPegReference = errors.New("no price in the book for the pegged order to track")
+)
+
+// PlacePeggedOrder rests o at the price of its peg plus o.PegOffset, never beyond o.PegCap
+// (above it for bids, below it for asks). Only orders that are not pegged set the reference prices.
+// Pegged orders never take liquidity: a pegged price that would cross the book is kept one tick away.
+// They are repriced whenever the top of the book moves and lose their time priority when their price changes.
+func (ob *Orderbook) PlacePeggedOrder(o *Order) error {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+
+	if o.Peg != PegPrimary && o.Peg != PegMid ||
+		o.PegCap.IsNegative() ||
+		o.TimeInForce == IOC || o.TimeInForce == FOK {
+		return o.reject(ErrInvalidPeg)
+	}
+
+	if o.TimeInForce == GTD && o.ExpiresAt <= ob.now() {
+		return o.reject(ErrInvalidExpiry)
+	}
+
+	price, ok := ob.pegPrice(o)
+	if !ok {
+		return o.reject(ErrNoPegReference)
+	}
+
+	if err := ob.checkVolume(o.Bid, o.Size); err != nil {
+		return o.reject(err)
+	}
+
+	o.Id = ob.nextOrderId()
+	o.Price = price
+	ob.addOrder(price, o)
+	ob.pegged = append(ob.pegged, o)
+
+	return nil
+}
+
+// pegPrice returns the price o should rest at given the current top of the book
+func (ob *Orderbook) pegPrice(o *Order) (decimal.Decimal, bool) {
+	var price decimal.Decimal
+
+	switch o.Peg {
+	case PegPrimary:
+		best, ok := ob.pegReference(o.Bid)
+		if !ok {
+			return price, false
+		}
+		price = best
+	case PegMid:
+		bestBid, okBid := ob.pegReference(true)
+		bestAsk, okAsk := ob.pegReference(false)
+		if !okBid || !okAsk {
+			return price, false
+		}
+		sum, err := bestBid.CheckedAdd(bestAsk)
+		if err != nil {
+			return price, false
+		}
+		// one decimal more than the tick keeps half a tick, as far as a decimal can hold it
+		scale := ob.tickSize.Scale() + 1
+		if scale > decimal.MaxScale {
+			scale = decimal.MaxScale
+		}
+		price = sum.Div(decimal.NewFromInt(2), scale)
+	default:
+		return price, false
+	}
+
+	price, err := price.CheckedAdd(o.PegOffset)
+	if err != nil {
+		return price, false
+	}
+
+	if o.PegCap.IsPositive() {
+		if o.Bid {
+			price = decimal.Min(price, o.PegCap)
+		} else {
+			price = decimal.Max(price, o.PegCap)
+		}
+	}
+
+	// bids round down and asks round up so the order never rests at a better price than its peg
+	price = ob.roundToTick(price, !o.Bid)
+
+	if best := ob.bestOpposite(o.Bid); best != nil {
+		if o.Bid && price.GreaterThanOrEqual(best.Price) {
+			price = best.Price.Sub(ob.tickSize)
+		}
+		if !o.Bid && price.LessThanOrEqual(best.Price) {
+			price = best.Price.Add(ob.tickSize)
+		}
+	}
+
+	return price, price.IsPositive()
+}
+
+// pegReference returns the best price of a side set by orders that are not pegged
+func (ob *Orderbook) pegReference(bid bool) (decimal.Decimal, bool) {
+	side := ob.asks
+	if bid {
+		side = ob.bids
+	}
+
+	var (
+		price decimal.Decimal
+		found bool
+	)
+
+	side.each(func(l *Limit) bool {
+		if l.Len() > l.pegged {
+			price = l.Price
+			found = true
+			return false
+		}
+		return true
+	})
+
+	return price, found
+}
+
+func (ob *Orderbook) roundToTick(price decimal.Decimal, up bool) decimal.Decimal {
+	rounded := price.Div(ob.tickSize, 0).Mul(ob.tickSize)
+	if up && rounded.LessThan(price) {
+		rounded = rounded.Add(ob.tickSize)
+	}
+	return rounded
+}
+
+// repricePegged moves the pegged orders whose pegged price changed to the back of their new level
+func (ob *Orderbook) repricePegged() {
+	pegged := ob.pegged[:0]
+
+	for _, o := range ob.pegged {
+		if o.Limit == nil {
+			continue
+		}
+		pegged = append(pegged, o)
+
+		price, ok := ob.pegPrice(o)
+		if !ok || price.Equal(o.Price) {
+			continue
+		}
+
+		ob.cancelOrder(o)
+		o.Price = price
+		ob.addOrder(price, o)
+	}
+
+	ob.pegged = pegged
+}
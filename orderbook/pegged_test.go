@@ -0,0 +1,102 @@
+package orderbook
+
+import (
+	"testing"
+
+	"github.com/PanGan21/crypto-exchange-poc/decimal"
+)
+
+func TestPrimaryPegFollowsBestBid(t *testing.T) {
+	ob := NewOrderbook()
+	ob.PlaceLimitOrder(decimal.NewFromInt(9_000), NewOrder(true, decimal.NewFromInt(1), 0))
+	bestBid := NewOrder(true, decimal.NewFromInt(1), 0)
+	ob.PlaceLimitOrder(decimal.NewFromInt(9_500), bestBid)
+
+	pegged := NewOrder(true, decimal.NewFromInt(2), 1)
+	pegged.Peg = PegPrimary
+	assert(t, ob.PlacePeggedOrder(pegged), nil)
+	assert(t, pegged.Price, decimal.NewFromInt(9_500))
+	assert(t, ob.bidLimits[decimal.NewFromInt(9_500)].Orders(), []*Order{bestBid, pegged})
+
+	// a better bid moves the pegged order to the back of the new level
+	ob.PlaceLimitOrder(decimal.NewFromInt(9_600), NewOrder(true, decimal.NewFromInt(1), 0))
+	assert(t, pegged.Price, decimal.NewFromInt(9_600))
+	assert(t, ob.bidLimits[decimal.NewFromInt(9_600)].Len(), 2)
+	assert(t, ob.bidLimits[decimal.NewFromInt(9_500)].Orders(), []*Order{bestBid})
+	assert(t, ob.BidTotalVolume(), decimal.NewFromInt(5))
+
+	// pegged orders don't set the reference, the peg falls back to the best bid left
+	matches, err := ob.PlaceMarketOrder(NewOrder(false, decimal.NewFromInt(1), 2))
+	assert(t, err, nil)
+	assert(t, len(matches), 1)
+	assert(t, pegged.Price, decimal.NewFromInt(9_500))
+	_, ok := ob.bidLimits[decimal.NewFromInt(9_600)]
+	assert(t, ok, false)
+}
+
+func TestMidPegOffsetAndCap(t *testing.T) {
+	ob := NewOrderbook()
+	ob.PlaceLimitOrder(decimal.NewFromInt(100), NewOrder(true, decimal.NewFromInt(1), 0))
+	ob.PlaceLimitOrder(decimal.NewFromInt(105), NewOrder(false, decimal.NewFromInt(1), 0))
+
+	// the midpoint 102.5 rounds down for a bid
+	bid := NewOrder(true, decimal.NewFromInt(1), 1)
+	bid.Peg = PegMid
+	assert(t, ob.PlacePeggedOrder(bid), nil)
+	assert(t, bid.Price, decimal.NewFromInt(102))
+
+	ask := NewOrder(false, decimal.NewFromInt(1), 1)
+	ask.Peg = PegMid
+	ask.PegOffset = decimal.NewFromInt(1)
+	ask.PegCap = decimal.NewFromInt(104)
+	assert(t, ob.PlacePeggedOrder(ask), nil)
+	assert(t, ask.Price, decimal.NewFromInt(104))
+
+	// the new midpoint 101.5 moves the bid while the ask stays at its cap
+	ob.PlaceLimitOrder(decimal.NewFromInt(103), NewOrder(false, decimal.NewFromInt(1), 0))
+
+	assert(t, bid.Price, decimal.NewFromInt(101))
+	assert(t, ask.Price, decimal.NewFromInt(104))
+}
+
+func TestMidPegFinestTick(t *testing.T) {
+	tick := decimal.New(1, decimal.MaxScale)
+	ob := NewOrderbook(WithTickSize(tick))
+	ob.PlaceLimitOrder(decimal.NewFromInt(1), NewOrder(true, decimal.NewFromInt(1), 0))
+	ob.PlaceLimitOrder(decimal.NewFromInt(1).Add(tick), NewOrder(false, decimal.NewFromInt(1), 0))
+
+	// the half tick of the midpoint is beyond the precision of a decimal and is dropped
+	bid := NewOrder(true, decimal.NewFromInt(1), 1)
+	bid.Peg = PegMid
+	assert(t, ob.PlacePeggedOrder(bid), nil)
+	assert(t, bid.Price, decimal.NewFromInt(1))
+}
+
+func TestPeggedOrderNeverCrosses(t *testing.T) {
+	ob := NewOrderbook()
+	ob.PlaceLimitOrder(decimal.NewFromInt(100), NewOrder(true, decimal.NewFromInt(1), 0))
+	ob.PlaceLimitOrder(decimal.NewFromInt(105), NewOrder(false, decimal.NewFromInt(1), 0))
+
+	ask := NewOrder(false, decimal.NewFromInt(1), 1)
+	ask.Peg = PegPrimary
+	ask.PegOffset = decimal.NewFromInt(-10)
+	assert(t, ob.PlacePeggedOrder(ask), nil)
+
+	assert(t, ask.Price, decimal.NewFromInt(101))
+	assert(t, len(ob.Trades()), 0)
+}
+
+func TestPeggedOrderValidation(t *testing.T) {
+	ob := NewOrderbook()
+
+	pegged := NewOrder(true, decimal.NewFromInt(1), 1)
+	pegged.Peg = PegPrimary
+	assert(t, ob.PlacePeggedOrder(pegged), ErrNoPegReference)
+
+	pegged.TimeInForce = IOC
+	assert(t, ob.PlacePeggedOrder(pegged), ErrInvalidPeg)
+
+	pegged.TimeInForce = GTC
+	pegged.Peg = "LAST"
+	assert(t, ob.PlacePeggedOrder(pegged), ErrInvalidPeg)
+}
@@ -0,0 +1,48 @@
+package orderbook
+
+import (
+	"errors"
+
+	"github.com/PanGan21/crypto-exchange-poc/decimal"
+)
+
+// PostOnly decides what happens to a maker only order that would take liquidity
+type PostOnly string
+
+const (
+	// PostOnlyReject rejects the order with ErrPostOnlyWouldTake
+	PostOnlyReject PostOnly = "REJECT"
+	// PostOnlySlide reprices the order one tick away from the opposite best price
+	PostOnlySlide PostOnly = "SLIDE"
+)
+
+var ErrPostOnlyWouldTake = errors.New("post only order would take liquidity")
+
+// postOnlyPrice returns the price a post only order can rest at without crossing the book
+func (ob *Orderbook) postOnlyPrice(price decimal.Decimal, o *Order) (decimal.Decimal, error) {
+	best := ob.bestOpposite(o.Bid)
+	if best == nil {
+		return price, nil
+	}
+
+	crosses := o.Bid && price.GreaterThanOrEqual(best.Price) || !o.Bid && price.LessThanOrEqual(best.Price)
+	if !crosses {
+		return price, nil
+	}
+
+	if o.PostOnly != PostOnlySlide {
+		return price, ErrPostOnlyWouldTake
+	}
+
+	if o.Bid {
+		price = best.Price.Sub(ob.tickSize)
+	} else {
+		price = best.Price.Add(ob.tickSize)
+	}
+
+	if !price.IsPositive() {
+		return price, ErrPostOnlyWouldTake
+	}
+
+	return price, nil
+}
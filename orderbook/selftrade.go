@@ -0,0 +1,102 @@
+package orderbook
+
+import "github.com/PanGan21/crypto-exchange-poc/decimal"
+
+// SelfTradePrevention decides what happens when an incoming order would match
+// a resting order of the same user. The mode of the incoming order applies.
+// Cancelled quantity is moved from the size of an order to its Prevented quantity.
+type SelfTradePrevention string
+
+const (
+	// SelfTradeCancelNewest cancels what is left of the incoming order
+	SelfTradeCancelNewest SelfTradePrevention = "CANCEL_NEWEST"
+	// SelfTradeCancelOldest cancels the resting order and keeps matching the incoming one
+	SelfTradeCancelOldest SelfTradePrevention = "CANCEL_OLDEST"
+	// SelfTradeCancelBoth cancels the resting order and what is left of the incoming order
+	SelfTradeCancelBoth SelfTradePrevention = "CANCEL_BOTH"
+	// SelfTradeDecrementAndCancel takes the smaller size out of both orders,
+	// cancelling the smaller one and decrementing the bigger one
+	SelfTradeDecrementAndCancel SelfTradePrevention = "DECREMENT_AND_CANCEL"
+)
+
+// preventSelfTrade applies the self-trade prevention mode of the incoming order o
+// to the resting order of the same user
+func (l *Limit) preventSelfTrade(resting, o *Order) {
+	switch o.SelfTradePrevention {
+	case SelfTradeCancelOldest:
+		l.cancel(resting)
+	case SelfTradeCancelBoth:
+		l.cancel(resting)
+		o.cancelRemaining()
+	case SelfTradeDecrementAndCancel:
+		quantity := decimal.Min(o.Size, resting.TotalRemaining())
+		o.Size = o.Size.Sub(quantity)
+		o.Prevented = o.Prevented.Add(quantity)
+
+		if quantity.Equal(resting.TotalRemaining()) {
+			l.cancel(resting)
+		} else {
+			l.reduce(resting, quantity)
+			resting.Prevented = resting.Prevented.Add(quantity)
+		}
+	default:
+		// SelfTradeCancelNewest
+		o.cancelRemaining()
+	}
+}
+
+// cancel takes a resting order out of the queue because of self-trade prevention
+func (l *Limit) cancel(o *Order) {
+	l.RemoveOrder(o)
+
+	o.Prevented = o.Prevented.Add(o.TotalRemaining())
+	o.Size = decimal.Zero
+	o.Reserve = decimal.Zero
+	o.close(StatusCancelled)
+
+	if l.cancelled != nil {
+		l.cancelled(o)
+	}
+}
+
+func (o *Order) cancelRemaining() {
+	o.Prevented = o.Prevented.Add(o.Size)
+	o.Size = decimal.Zero
+}
+
+// selfTradeVolume is the volume of the side opposite o that o can trade at prices as good or
+// better than price, or at any price when bounded is false, when self-trade prevention applies.
+// The orders of the user of o don't count, and with every mode but CANCEL_OLDEST o stops
+// or shrinks at the first of them, so the volume behind it doesn't count either.
+func (ob *Orderbook) selfTradeVolume(o *Order, price decimal.Decimal, bounded bool) decimal.Decimal {
+	side := ob.asks
+	if !o.Bid {
+		side = ob.bids
+	}
+
+	volume := decimal.Zero
+	side.each(func(l *Limit) bool {
+		if bounded && o.worse(l.Price, price) {
+			return false
+		}
+
+		visible, reserve := decimal.Zero, decimal.Zero
+		for resting := l.head; resting != nil; resting = resting.next {
+			if resting.UserId != o.UserId {
+				visible = visible.Add(resting.Size)
+				reserve = reserve.Add(resting.Reserve)
+				continue
+			}
+			if o.SelfTradePrevention != SelfTradeCancelOldest {
+				// the reserves of the icebergs ahead are shown behind the order of the user
+				volume = volume.Add(visible)
+				return false
+			}
+		}
+
+		volume = volume.Add(visible).Add(reserve)
+		return true
+	})
+
+	return volume
+}
@@ -0,0 +1,167 @@
+package orderbook
+
+import (
+	"testing"
+
+	"github.com/PanGan21/crypto-exchange-poc/decimal"
+)
+
+func TestSelfTradeCancelNewest(t *testing.T) {
+	ob := NewOrderbook()
+	price := decimal.NewFromInt(10_000)
+
+	ownOrder := NewOrder(false, decimal.NewFromInt(2), 1)
+	ob.PlaceLimitOrder(price, ownOrder)
+	ob.PlaceLimitOrder(price, NewOrder(false, decimal.NewFromInt(2), 2))
+
+	buyOrder := NewOrder(true, decimal.NewFromInt(3), 1)
+	buyOrder.SelfTradePrevention = SelfTradeCancelNewest
+	matches, err := ob.PlaceLimitOrder(price, buyOrder)
+
+	assert(t, err, nil)
+	assert(t, len(matches), 0)
+	assert(t, buyOrder.Prevented, decimal.NewFromInt(3))
+	assert(t, buyOrder.IsFilled(), true)
+	assert(t, buyOrder.Limit, (*Limit)(nil))
+	assert(t, ownOrder.Size, decimal.NewFromInt(2))
+	assert(t, ob.AskTotalVolume(), decimal.NewFromInt(4))
+	assert(t, ob.bids.len(), 0)
+}
+
+func TestSelfTradeCancelOldest(t *testing.T) {
+	ob := NewOrderbook()
+
+	ownOrder := NewOrder(false, decimal.NewFromInt(2), 1)
+	ob.PlaceLimitOrder(decimal.NewFromInt(10_000), ownOrder)
+	ob.PlaceLimitOrder(decimal.NewFromInt(10_100), NewOrder(false, decimal.NewFromInt(5), 2))
+
+	buyOrder := NewOrder(true, decimal.NewFromInt(3), 1)
+	buyOrder.SelfTradePrevention = SelfTradeCancelOldest
+	matches, err := ob.PlaceMarketOrder(buyOrder)
+
+	assert(t, err, nil)
+	assert(t, len(matches), 1)
+	assert(t, matches[0].Price, decimal.NewFromInt(10_100))
+	assert(t, buyOrder.IsFilled(), true)
+	assert(t, buyOrder.Prevented, decimal.Zero)
+	assert(t, ownOrder.Prevented, decimal.NewFromInt(2))
+	assert(t, ownOrder.Limit, (*Limit)(nil))
+	assert(t, ob.asks.len(), 1)
+	assert(t, ob.AskTotalVolume(), decimal.NewFromInt(2))
+
+	_, ok := ob.orders[ownOrder.Id]
+	assert(t, ok, false)
+}
+
+func TestSelfTradeCancelBoth(t *testing.T) {
+	ob := NewOrderbook()
+	price := decimal.NewFromInt(10_000)
+
+	ownOrder := NewOrder(true, decimal.NewFromInt(5), 1)
+	ob.PlaceLimitOrder(price, ownOrder)
+
+	sellOrder := NewOrder(false, decimal.NewFromInt(2), 1)
+	sellOrder.SelfTradePrevention = SelfTradeCancelBoth
+	matches, err := ob.PlaceLimitOrder(price, sellOrder)
+
+	assert(t, err, nil)
+	assert(t, len(matches), 0)
+	assert(t, sellOrder.Prevented, decimal.NewFromInt(2))
+	assert(t, ownOrder.Prevented, decimal.NewFromInt(5))
+	assert(t, ob.bids.len(), 0)
+	assert(t, ob.asks.len(), 0)
+	assert(t, len(ob.orders), 0)
+}
+
+func TestSelfTradeDecrementAndCancel(t *testing.T) {
+	ob := NewOrderbook()
+	price := decimal.NewFromInt(10_000)
+
+	ownOrder := NewOrder(false, decimal.NewFromInt(5), 1)
+	ownOrder.DisplaySize = decimal.NewFromInt(2)
+	ob.PlaceLimitOrder(price, ownOrder)
+	otherOrder := NewOrder(false, decimal.NewFromInt(1), 2)
+	ob.PlaceLimitOrder(price, otherOrder)
+
+	// the resting iceberg is bigger so it is decremented and keeps its place
+	buyOrder := NewOrder(true, decimal.NewFromInt(4), 1)
+	buyOrder.SelfTradePrevention = SelfTradeDecrementAndCancel
+	matches, err := ob.PlaceMarketOrder(buyOrder)
+
+	assert(t, err, nil)
+	assert(t, len(matches), 0)
+	assert(t, buyOrder.Prevented, decimal.NewFromInt(4))
+	assert(t, ownOrder.Prevented, decimal.NewFromInt(4))
+	assert(t, ownOrder.TotalRemaining(), decimal.NewFromInt(1))
+	assert(t, ob.askLimits[price].Orders(), []*Order{ownOrder, otherOrder})
+
+	// the incoming order is bigger so the resting order is cancelled and the rest matches
+	buyOrder = NewOrder(true, decimal.NewFromInt(2), 1)
+	buyOrder.SelfTradePrevention = SelfTradeDecrementAndCancel
+	matches, err = ob.PlaceMarketOrder(buyOrder)
+
+	assert(t, err, nil)
+	assert(t, len(matches), 1)
+	assert(t, matches[0].Ask, otherOrder)
+	assert(t, buyOrder.Prevented, decimal.NewFromInt(1))
+	assert(t, buyOrder.IsFilled(), true)
+	assert(t, ownOrder.Prevented, decimal.NewFromInt(5))
+	assert(t, ob.asks.len(), 0)
+}
+
+func TestSelfTradeWithoutPreventionMatches(t *testing.T) {
+	ob := NewOrderbook()
+	price := decimal.NewFromInt(10_000)
+
+	ob.PlaceLimitOrder(price, NewOrder(false, decimal.NewFromInt(2), 1))
+	matches, err := ob.PlaceMarketOrder(NewOrder(true, decimal.NewFromInt(2), 1))
+
+	assert(t, err, nil)
+	assert(t, len(matches), 1)
+	assert(t, ob.Trades()[0].MakerUserId, ob.Trades()[0].TakerUserId)
+}
+
+func TestSelfTradePreventionFillOrKill(t *testing.T) {
+	ob := NewOrderbook()
+	own := NewOrder(false, decimal.NewFromInt(1), 1)
+	ob.PlaceLimitOrder(decimal.NewFromInt(100), own)
+	ob.PlaceLimitOrder(decimal.NewFromInt(101), NewOrder(false, decimal.NewFromInt(1), 2))
+
+	// only 1 of the 2 asks can be traded, the order is rejected and the own ask stays
+	buyOrder := NewOrder(true, decimal.NewFromInt(2), 1)
+	buyOrder.TimeInForce = FOK
+	buyOrder.SelfTradePrevention = SelfTradeCancelOldest
+	_, err := ob.PlaceLimitOrder(decimal.NewFromInt(101), buyOrder)
+
+	assert(t, err, &InsufficientVolumeError{Requested: decimal.NewFromInt(2), Available: decimal.NewFromInt(1)})
+	assert(t, buyOrder.Status, StatusRejected)
+	assert(t, buyOrder.Filled, decimal.Zero)
+	assert(t, own.Status, StatusNew)
+	assert(t, ob.AskTotalVolume(), decimal.NewFromInt(2))
+
+	// cancelling the own ask frees enough volume behind it
+	ob.PlaceLimitOrder(decimal.NewFromInt(101), NewOrder(false, decimal.NewFromInt(1), 2))
+	buyOrder = NewOrder(true, decimal.NewFromInt(2), 1)
+	buyOrder.TimeInForce = FOK
+	buyOrder.SelfTradePrevention = SelfTradeCancelOldest
+	matches, err := ob.PlaceMarketOrder(buyOrder)
+
+	assert(t, err, nil)
+	assert(t, len(matches), 2)
+	assert(t, buyOrder.Filled, decimal.NewFromInt(2))
+	assert(t, own.Status, StatusCancelled)
+
+	// the other modes stop at the own ask, the volume behind it can't be reached
+	own = NewOrder(false, decimal.NewFromInt(1), 1)
+	ob.PlaceLimitOrder(decimal.NewFromInt(100), NewOrder(false, decimal.NewFromInt(1), 2))
+	ob.PlaceLimitOrder(decimal.NewFromInt(100), own)
+	ob.PlaceLimitOrder(decimal.NewFromInt(101), NewOrder(false, decimal.NewFromInt(1), 2))
+
+	buyOrder = NewOrder(true, decimal.NewFromInt(2), 1)
+	buyOrder.TimeInForce = FOK
+	buyOrder.SelfTradePrevention = SelfTradeCancelNewest
+	_, err = ob.PlaceMarketOrder(buyOrder)
+
+	assert(t, err, &InsufficientVolumeError{Requested: decimal.NewFromInt(2), Available: decimal.NewFromInt(1)})
+	assert(t, ob.AskTotalVolume(), decimal.NewFromInt(3))
+}
@@ -0,0 +1,46 @@
+package orderbook
+
+import (
+	"errors"
+
+	"github.com/PanGan21/crypto-exchange-poc/decimal"
+)
+
+var ErrInvalidSlippage = errors.New("max slippage and worst price can't be negative")
+
+// priceLimit returns the worst price a market order accepts to fill at:
+// MaxSlippage basis points away from the best price when the order arrives
+// or WorstPrice, whichever is closer. ok is false when the order is not bounded.
+// A slippage too large to compute does not bound the order.
+func (ob *Orderbook) priceLimit(o *Order) (limit decimal.Decimal, ok bool) {
+	if o.MaxSlippage.IsPositive() {
+		if best := ob.bestOpposite(o.Bid); best != nil {
+			offset, err := best.Price.CheckedMul(o.MaxSlippage)
+			if err == nil {
+				offset, err = offset.CheckedDiv(decimal.NewFromInt(10_000), ob.tickSize.Scale())
+			}
+			if err == nil {
+				if o.Bid {
+					limit, err = best.Price.CheckedAdd(offset)
+				} else {
+					limit = best.Price.Sub(offset)
+				}
+				ok = err == nil
+			}
+		}
+	}
+
+	if o.WorstPrice.IsPositive() && (!ok || o.worse(limit, o.WorstPrice)) {
+		limit, ok = o.WorstPrice, true
+	}
+
+	return limit, ok
+}
+
+// worse reports whether price is worse than limit for o: higher for a buy and lower for a sell
+func (o *Order) worse(price, limit decimal.Decimal) bool {
+	if o.Bid {
+		return price.GreaterThan(limit)
+	}
+	return price.LessThan(limit)
+}
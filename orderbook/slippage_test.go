@@ -0,0 +1,76 @@
+package orderbook
+
+import (
+	"math"
+	"testing"
+
+	"github.com/PanGan21/crypto-exchange-poc/decimal"
+)
+
+func TestMarketOrderMaxSlippage(t *testing.T) {
+	ob := NewOrderbook()
+	ob.PlaceLimitOrder(decimal.NewFromInt(10_000), NewOrder(false, decimal.NewFromInt(1), 0))
+	ob.PlaceLimitOrder(decimal.NewFromInt(10_050), NewOrder(false, decimal.NewFromInt(1), 0))
+	ob.PlaceLimitOrder(decimal.NewFromInt(10_200), NewOrder(false, decimal.NewFromInt(1), 0))
+
+	// 100 bps of 10_000 lets the order fill up to 10_100
+	buyOrder := NewOrder(true, decimal.NewFromInt(3), 1)
+	buyOrder.MaxSlippage = decimal.NewFromInt(100)
+	matches, err := ob.PlaceMarketOrder(buyOrder)
+
+	assert(t, err, nil)
+	assert(t, len(matches), 2)
+	assert(t, matches[1].Price, decimal.NewFromInt(10_050))
+	assert(t, buyOrder.Size, decimal.NewFromInt(1))
+	assert(t, ob.AskTotalVolume(), decimal.NewFromInt(1))
+}
+
+func TestMarketOrderWorstPrice(t *testing.T) {
+	ob := NewOrderbook()
+	ob.PlaceLimitOrder(decimal.NewFromInt(10_000), NewOrder(true, decimal.NewFromInt(1), 0))
+	ob.PlaceLimitOrder(decimal.NewFromInt(9_900), NewOrder(true, decimal.NewFromInt(1), 0))
+
+	// the closest of the two bounds applies
+	sellOrder := NewOrder(false, decimal.NewFromInt(2), 1)
+	sellOrder.MaxSlippage = decimal.NewFromInt(500)
+	sellOrder.WorstPrice = decimal.NewFromInt(9_950)
+	matches, err := ob.PlaceMarketOrder(sellOrder)
+
+	assert(t, err, nil)
+	assert(t, len(matches), 1)
+	assert(t, sellOrder.Size, decimal.NewFromInt(1))
+
+	// nothing left within the worst price
+	sellOrder = NewOrder(false, decimal.NewFromInt(1), 1)
+	sellOrder.WorstPrice = decimal.NewFromInt(9_950)
+	_, err = ob.PlaceMarketOrder(sellOrder)
+
+	assert(t, err, &InsufficientVolumeError{Requested: decimal.NewFromInt(1), Available: decimal.Zero})
+	assert(t, ob.BidTotalVolume(), decimal.NewFromInt(1))
+}
+
+func TestFOKMarketOrderWithinSlippage(t *testing.T) {
+	ob := NewOrderbook()
+	ob.PlaceLimitOrder(decimal.NewFromInt(100), NewOrder(false, decimal.NewFromInt(1), 0))
+	ob.PlaceLimitOrder(decimal.NewFromInt(110), NewOrder(false, decimal.NewFromInt(1), 0))
+
+	buyOrder := NewOrder(true, decimal.NewFromInt(2), 1)
+	buyOrder.TimeInForce = FOK
+	buyOrder.WorstPrice = decimal.NewFromInt(105)
+	_, err := ob.PlaceMarketOrder(buyOrder)
+
+	assert(t, err, &InsufficientVolumeError{Requested: decimal.NewFromInt(2), Available: decimal.NewFromInt(1)})
+	assert(t, ob.AskTotalVolume(), decimal.NewFromInt(2))
+
+	buyOrder = NewOrder(true, decimal.NewFromInt(1), 1)
+	buyOrder.MaxSlippage = decimal.NewFromInt(-1)
+	_, err = ob.PlaceMarketOrder(buyOrder)
+	assert(t, err, ErrInvalidSlippage)
+
+	// a slippage too large to compute does not bound the order
+	buyOrder = NewOrder(true, decimal.NewFromInt(2), 1)
+	buyOrder.MaxSlippage = decimal.New(math.MaxInt64, 0)
+	matches, err := ob.PlaceMarketOrder(buyOrder)
+	assert(t, err, nil)
+	assert(t, len(matches), 2)
+}
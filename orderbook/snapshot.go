@@ -0,0 +1,258 @@
+package orderbook
+
+import (
+	"fmt"
+
+	"github.com/PanGan21/crypto-exchange-poc/decimal"
+)
+
+// Snapshot is a copy of books and of the orders and groups they share with the caller.
+// Orders and groups are stored once and referenced by their index in Orders and Groups,
+// so an order referenced from several places is restored as a single order.
+// It must be taken while nothing changes the books.
+type Snapshot struct {
+	Orders []*Order
+	Groups []*GroupState
+	Books  map[string]*BookState
+
+	orderRefs map[*Order]int
+	groupRefs map[*OrderGroup]int
+	// orders and groups restored from their references
+	orders []*Order
+	groups []*OrderGroup
+}
+
+// BookState is a book of a Snapshot, orders and groups are references
+type BookState struct {
+	Asks          []LevelState // Best price first
+	Bids          []LevelState
+	BuyStops      []int // Trigger priority
+	SellStops     []int
+	Pegged        []int // Oldest first
+	Trailing      []int
+	Groups        []int // Open groups, oldest first
+	Sequence      uint64
+	TradeSequence uint64
+	LastTrade     *Trade `json:",omitempty"`
+	Ids           IDs
+}
+
+type LevelState struct {
+	Price  decimal.Decimal
+	Orders []int // Time priority
+}
+
+// GroupState is a group of a Snapshot, its orders are references and -1 stands for no order
+type GroupState struct {
+	Id          int64
+	Type        GroupType
+	Status      GroupStatus
+	EntryFilled decimal.Decimal
+	Entry       int
+	TakeProfit  int
+	StopLoss    int
+}
+
+func NewSnapshot() *Snapshot {
+	return &Snapshot{
+		Orders: []*Order{},
+		Groups: []*GroupState{},
+		Books:  make(map[string]*BookState),
+	}
+}
+
+// AddBook copies the book ob under name
+func (s *Snapshot) AddBook(name string, ob *Orderbook) {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+
+	state := &BookState{
+		Asks:          s.levels(ob.asks),
+		Bids:          s.levels(ob.bids),
+		BuyStops:      s.queued(ob.buyStops),
+		SellStops:     s.queued(ob.sellStops),
+		Pegged:        s.orderRefList(ob.pegged),
+		Trailing:      s.orderRefList(ob.trailing),
+		Groups:        []int{},
+		Sequence:      ob.sequence,
+		TradeSequence: ob.tradeSequence,
+		Ids:           ob.ids.last(),
+	}
+	for _, g := range ob.groups {
+		state.Groups = append(state.Groups, s.GroupRef(g))
+	}
+	if len(ob.trades) > 0 {
+		lastTrade := *ob.trades[len(ob.trades)-1]
+		state.LastTrade = &lastTrade
+	}
+
+	s.Books[name] = state
+}
+
+func (s *Snapshot) levels(side *limitList) []LevelState {
+	levels := []LevelState{}
+	side.each(func(l *Limit) bool {
+		levels = append(levels, LevelState{Price: l.Price, Orders: s.orderRefList(l.Orders())})
+		return true
+	})
+	return levels
+}
+
+func (s *Snapshot) queued(side *limitList) []int {
+	refs := []int{}
+	side.each(func(l *Limit) bool {
+		refs = append(refs, s.orderRefList(l.Orders())...)
+		return true
+	})
+	return refs
+}
+
+func (s *Snapshot) orderRefList(orders []*Order) []int {
+	refs := make([]int, 0, len(orders))
+	for _, o := range orders {
+		refs = append(refs, s.OrderRef(o))
+	}
+	return refs
+}
+
+// OrderRef returns the reference of o, adding a copy of o the first time, -1 for nil
+func (s *Snapshot) OrderRef(o *Order) int {
+	if o == nil {
+		return -1
+	}
+	if s.orderRefs == nil {
+		s.orderRefs = make(map[*Order]int)
+	}
+	if ref, ok := s.orderRefs[o]; ok {
+		return ref
+	}
+
+	c := o.snapshot()
+	c.copyResting = false
+	c.copyPending = false
+	s.Orders = append(s.Orders, c)
+	s.orderRefs[o] = len(s.Orders) - 1
+
+	return len(s.Orders) - 1
+}
+
+// GroupRef returns the reference of g, adding a copy of g the first time, -1 for nil
+func (s *Snapshot) GroupRef(g *OrderGroup) int {
+	if g == nil {
+		return -1
+	}
+	if s.groupRefs == nil {
+		s.groupRefs = make(map[*OrderGroup]int)
+	}
+	if ref, ok := s.groupRefs[g]; ok {
+		return ref
+	}
+
+	s.Groups = append(s.Groups, &GroupState{
+		Id:          g.Id,
+		Type:        g.Type,
+		Status:      g.Status,
+		EntryFilled: g.EntryFilled,
+		Entry:       s.OrderRef(g.Entry),
+		TakeProfit:  s.OrderRef(g.TakeProfit),
+		StopLoss:    s.OrderRef(g.StopLoss),
+	})
+	s.groupRefs[g] = len(s.Groups) - 1
+
+	return len(s.Groups) - 1
+}
+
+// Order returns the order restored from ref, the same one every time, nil for -1
+func (s *Snapshot) Order(ref int) *Order {
+	if ref < 0 || ref >= len(s.Orders) {
+		return nil
+	}
+	if s.orders == nil {
+		s.orders = make([]*Order, len(s.Orders))
+	}
+
+	if s.orders[ref] == nil {
+		o := *s.Orders[ref]
+		s.orders[ref] = &o
+	}
+	return s.orders[ref]
+}
+
+// Group returns the group restored from ref with its orders, the same one every time, nil for -1
+func (s *Snapshot) Group(ref int) *OrderGroup {
+	if ref < 0 || ref >= len(s.Groups) {
+		return nil
+	}
+	if s.groups == nil {
+		s.groups = make([]*OrderGroup, len(s.Groups))
+	}
+
+	if s.groups[ref] == nil {
+		state := s.Groups[ref]
+		s.groups[ref] = &OrderGroup{
+			Id:          state.Id,
+			Type:        state.Type,
+			Status:      state.Status,
+			EntryFilled: state.EntryFilled,
+			Entry:       s.Order(state.Entry),
+			TakeProfit:  s.Order(state.TakeProfit),
+			StopLoss:    s.Order(state.StopLoss),
+		}
+	}
+	return s.groups[ref]
+}
+
+// Restore creates a book with opts and the state of the book name of the snapshot.
+// The id generator of the book continues after the ids of the snapshot.
+func (s *Snapshot) Restore(name string, opts ...Option) (*Orderbook, error) {
+	state, ok := s.Books[name]
+	if !ok {
+		return nil, fmt.Errorf("book %s not found in the snapshot", name)
+	}
+
+	ob := NewOrderbook(opts...)
+	ob.ids.restore(state.Ids)
+
+	for _, level := range state.Asks {
+		ob.restoreLevel(level, s)
+	}
+	for _, level := range state.Bids {
+		ob.restoreLevel(level, s)
+	}
+
+	for _, ref := range append(state.BuyStops, state.SellStops...) {
+		o := s.Order(ref)
+		sequence := o.Sequence
+		ob.addStop(o)
+		o.Sequence = sequence
+	}
+
+	for _, ref := range state.Pegged {
+		ob.pegged = append(ob.pegged, s.Order(ref))
+	}
+	for _, ref := range state.Trailing {
+		ob.trailing = append(ob.trailing, s.Order(ref))
+	}
+	for _, ref := range state.Groups {
+		ob.addGroup(s.Group(ref))
+	}
+
+	ob.sequence = state.Sequence
+	ob.tradeSequence = state.TradeSequence
+	if state.LastTrade != nil {
+		lastTrade := *state.LastTrade
+		ob.trades = append(ob.trades, &lastTrade)
+	}
+
+	return ob, nil
+}
+
+// restoreLevel queues the orders of level in their time priority
+func (ob *Orderbook) restoreLevel(level LevelState, s *Snapshot) {
+	for _, ref := range level.Orders {
+		o := s.Order(ref)
+		sequence := o.Sequence
+		ob.addOrder(level.Price, o)
+		o.Sequence = sequence
+	}
+}
@@ -0,0 +1,156 @@
+package orderbook
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/PanGan21/crypto-exchange-poc/decimal"
+)
+
+func clock() int64 {
+	return 1_700_000_000_000_000_000
+}
+
+// fillBook rests limit, iceberg, GTD and pegged orders and queues stops, a trailing stop and groups
+func fillBook(t *testing.T, ob *Orderbook) (*OrderGroup, *OrderGroup) {
+	for _, price := range []int64{10_000, 10_100, 10_100} {
+		ob.PlaceLimitOrder(decimal.NewFromInt(price), NewOrder(false, decimal.NewFromInt(2), 1))
+	}
+	iceberg := NewOrder(false, decimal.NewFromInt(10), 1)
+	iceberg.DisplaySize = decimal.NewFromInt(2)
+	ob.PlaceLimitOrder(decimal.NewFromInt(10_000), iceberg)
+
+	gtd := NewOrder(true, decimal.NewFromInt(1), 2)
+	gtd.TimeInForce = GTD
+	gtd.ExpiresAt = clock() + 10
+	ob.PlaceLimitOrder(decimal.NewFromInt(9_800), gtd)
+	ob.PlaceLimitOrder(decimal.NewFromInt(9_900), NewOrder(true, decimal.NewFromInt(3), 2))
+
+	pegged := NewOrder(true, decimal.NewFromInt(2), 3)
+	pegged.Peg = PegPrimary
+	assert(t, ob.PlacePeggedOrder(pegged), nil)
+
+	_, err := ob.PlaceMarketOrder(NewOrder(true, decimal.NewFromInt(1), 4))
+	assert(t, err, nil)
+
+	stop := NewOrder(true, decimal.NewFromInt(1), 4)
+	stop.StopPrice = decimal.NewFromInt(10_050)
+	assert(t, ob.PlaceStopOrder(stop), nil)
+	trailing := NewOrder(false, decimal.NewFromInt(1), 4)
+	trailing.TrailingAmount = decimal.NewFromInt(200)
+	assert(t, ob.PlaceStopOrder(trailing), nil)
+
+	takeProfit, stopLoss := newExits(false, decimal.NewFromInt(1), 5)
+	oco, _, err := ob.PlaceOCO(takeProfit, stopLoss)
+	assert(t, err, nil)
+
+	entry := NewOrder(true, decimal.NewFromInt(2), 6)
+	entry.Price = decimal.NewFromInt(9_000)
+	takeProfit, stopLoss = newExits(false, decimal.Zero, 6)
+	bracket, _, err := ob.PlaceBracket(entry, takeProfit, stopLoss)
+	assert(t, err, nil)
+
+	return oco, bracket
+}
+
+type bookView struct {
+	Asks      []*Level
+	Bids      []*Level
+	BuyStops  []*Order
+	SellStops []*Order
+}
+
+func viewBook(ob *Orderbook) bookView {
+	view := bookView{Asks: ob.Asks(), Bids: ob.Bids(), BuyStops: ob.StopOrders(true), SellStops: ob.StopOrders(false)}
+
+	// the links of the copies point into the book they were taken from
+	orders := append(view.BuyStops, view.SellStops...)
+	for _, level := range append(view.Asks, view.Bids...) {
+		orders = append(orders, level.Orders...)
+	}
+	for _, o := range orders {
+		o.Limit, o.trigger, o.group = nil, nil, nil
+	}
+	return view
+}
+
+type matchView struct {
+	AskId, BidId int64
+	Price, Size  decimal.Decimal
+	TradeId      int64
+}
+
+// trade moves the book through the orders and groups it restored
+func trade(ob *Orderbook) []matchView {
+	matched := []matchView{}
+	record := func(matches []Match, err error) {
+		for _, m := range matches {
+			matched = append(matched, matchView{m.Ask.Id, m.Bid.Id, m.Price, m.SizeFilled, m.TradeId})
+		}
+	}
+
+	ob.ExpireOrders(clock() + 20)
+	record(ob.PlaceMarketOrder(NewOrder(true, decimal.NewFromInt(5), 7)))
+	record(ob.PlaceMarketOrder(NewOrder(false, decimal.NewFromInt(6), 7)))
+	record(ob.PlaceLimitOrder(decimal.NewFromInt(9_000), NewOrder(false, decimal.NewFromInt(3), 7)))
+	record(ob.PlaceMarketOrder(NewOrder(true, decimal.NewFromInt(20), 7)))
+	return matched
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	ob := NewOrderbook(WithClock(clock))
+	oco, bracket := fillBook(t, ob)
+
+	s := NewSnapshot()
+	s.AddBook("ETH-USDC", ob)
+	ocoRef, bracketRef := s.GroupRef(oco), s.GroupRef(bracket)
+
+	data, err := json.Marshal(s)
+	assert(t, err, nil)
+	var decoded Snapshot
+	assert(t, json.Unmarshal(data, &decoded), nil)
+
+	restored, err := decoded.Restore("ETH-USDC", WithClock(clock))
+	assert(t, err, nil)
+	assert(t, viewBook(restored), viewBook(ob))
+	assert(t, restored.Trades(), ob.Trades()[len(ob.Trades())-1:])
+
+	// groups restored by the caller are the ones of the book
+	restoredOCO := decoded.Group(ocoRef)
+	assert(t, restoredOCO, decoded.Group(ocoRef))
+	assert(t, restoredOCO.StopLoss, restored.orders[oco.StopLoss.Id])
+	assert(t, decoded.Group(bracketRef).Entry, restored.orders[bracket.Entry.Id])
+
+	lastTrade := ob.Trades()[len(ob.Trades())-1].Sequence
+	want := trade(ob)
+	assert(t, len(want) > 5, true)
+	assert(t, trade(restored), want)
+	assert(t, viewBook(restored), viewBook(ob))
+	assert(t, restored.TradesSince(lastTrade), ob.TradesSince(lastTrade))
+	assert(t, restoredOCO.Status, oco.Status)
+	assert(t, decoded.Group(bracketRef).Status, bracket.Status)
+
+	_, err = decoded.Restore("BTC-USDC")
+	assert(t, err == nil, false)
+}
+
+func TestSnapshotRestoreSharedIDs(t *testing.T) {
+	ids := NewIDGenerator()
+	ob := NewOrderbook(WithIDGenerator(ids))
+	ob.PlaceLimitOrder(decimal.NewFromInt(100), NewOrder(false, decimal.NewFromInt(1), 1))
+	ob.PlaceMarketOrder(NewOrder(true, decimal.NewFromInt(1), 2))
+
+	s := NewSnapshot()
+	s.AddBook("ETH-USDC", ob)
+
+	// a generator already ahead of the snapshot is not moved back
+	restoredIds := NewIDGenerator()
+	for i := 0; i < 5; i++ {
+		restoredIds.NextOrderId()
+	}
+	_, err := s.Restore("ETH-USDC", WithIDGenerator(restoredIds))
+	assert(t, err, nil)
+	assert(t, restoredIds.last(), IDs{OrderId: 5, TradeId: 1})
+	assert(t, s.Order(-1), (*Order)(nil))
+	assert(t, s.Group(len(s.Groups)), (*OrderGroup)(nil))
+}
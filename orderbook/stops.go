@@ -0,0 +1,156 @@
+package orderbook
+
+import (
+	"errors"
+
+	"github.com/PanGan21/crypto-exchange-poc/decimal"
+)
+
+var (
+	ErrInvalidStopPrice = errors.New("stop price must be positive")
+	// ErrStopPriceCrossed rejects a stop order that the last trade price would already trigger
+	ErrStopPriceCrossed = errors.New("stop price is already crossed by the last trade")
+)
+
+// IsStopPending reports whether o is a stop order waiting for its trigger
+func (o *Order) IsStopPending() bool {
+	return o.trigger != nil || o.copyPending
+}
+
+// PlaceStopOrder stores a stop order in the trigger book until the last trade
+// price crosses o.StopPrice: at or above it for buy stops, at or below it for sell stops.
+// Once triggered it is executed as a market order when o.Price is zero (stop market)
+// or as a limit order at o.Price (stop limit).
+// A stop the last trade price already crosses is rejected with ErrStopPriceCrossed.
+// A trailing stop sets o.TrailingAmount or o.TrailingPercent instead of a stop price,
+// its stop price follows the last trade price and it is executed as a market order.
+func (ob *Orderbook) PlaceStopOrder(o *Order) error {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+
+	if o.IsTrailing() {
+		if err := ob.initTrailingStop(o); err != nil {
+			return o.reject(err)
+		}
+	}
+
+	if !o.StopPrice.IsPositive() {
+		return o.reject(ErrInvalidStopPrice)
+	}
+	if ob.stopCrossed(o.Bid, o.StopPrice) {
+		return o.reject(ErrStopPriceCrossed)
+	}
+
+	o.Id = ob.nextOrderId()
+	o.Triggered = false
+	ob.addStop(o)
+
+	if o.IsTrailing() {
+		ob.trailing = append(ob.trailing, o)
+	}
+
+	return nil
+}
+
+func (ob *Orderbook) stopSide(bid bool) *limitList {
+	if bid {
+		return ob.buyStops
+	}
+	return ob.sellStops
+}
+
+func (ob *Orderbook) addStop(o *Order) {
+	side := ob.stopSide(o.Bid)
+
+	limit := side.find(o.StopPrice)
+	if limit == nil {
+		limit = NewLimit(o.StopPrice)
+		side.insert(limit)
+	}
+
+	o.Sequence = ob.nextSequence()
+
+	// a pending stop is queued by trigger priority but it is not part of the book
+	ob.orders[o.Id] = o
+	limit.push(o)
+	o.trigger = limit
+}
+
+func (ob *Orderbook) removeStop(o *Order) {
+	limit := o.trigger
+	limit.unlink(o)
+	o.trigger = nil
+
+	if limit.Len() == 0 {
+		ob.stopSide(o.Bid).remove(limit)
+	}
+}
+
+// stopCrossed reports whether the last trade price activates a stop at stopPrice
+func (ob *Orderbook) stopCrossed(bid bool, stopPrice decimal.Decimal) bool {
+	if len(ob.trades) == 0 {
+		return false
+	}
+	lastPrice := ob.trades[len(ob.trades)-1].Price
+
+	if bid {
+		return lastPrice.GreaterThanOrEqual(stopPrice)
+	}
+	return lastPrice.LessThanOrEqual(stopPrice)
+}
+
+// nextTriggeredStop pops the oldest stop order activated by the last trade price
+func (ob *Orderbook) nextTriggeredStop() *Order {
+	if limit := ob.buyStops.best(); limit != nil && ob.stopCrossed(true, limit.Price) {
+		return limit.Front()
+	}
+	if limit := ob.sellStops.best(); limit != nil && ob.stopCrossed(false, limit.Price) {
+		return limit.Front()
+	}
+
+	return nil
+}
+
+// triggerStops executes the stop orders activated by the latest trades.
+// The trades of a triggered order can activate further stops so it keeps
+// going until the last trade price no longer crosses any stop price.
+func (ob *Orderbook) triggerStops() []Match {
+	matches := []Match{}
+
+	for o := ob.nextTriggeredStop(); o != nil; o = ob.nextTriggeredStop() {
+		ob.removeStop(o)
+		ob.removeOrder(o)
+		o.Triggered = true
+
+		if o.Price.IsZero() {
+			// a stop market order that finds no liquidity is cancelled
+			stopMatches, err := ob.placeMarketOrder(o)
+			if err != nil {
+				ob.close(o, StatusCancelled)
+			}
+			matches = append(matches, stopMatches...)
+			continue
+		}
+
+		matches = append(matches, ob.placeLimitOrder(o)...)
+	}
+
+	return matches
+}
+
+// StopOrders returns copies of the pending stop orders of a side ordered by trigger priority
+func (ob *Orderbook) StopOrders(bid bool) []*Order {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+
+	orders := []*Order{}
+
+	ob.stopSide(bid).each(func(l *Limit) bool {
+		for _, o := range l.Orders() {
+			orders = append(orders, o.snapshot())
+		}
+		return true
+	})
+
+	return orders
+}
@@ -0,0 +1,130 @@
+package orderbook
+
+import (
+	"testing"
+
+	"github.com/PanGan21/crypto-exchange-poc/decimal"
+)
+
+func TestStopMarketOrderTriggered(t *testing.T) {
+	ob := NewOrderbook()
+	ob.PlaceLimitOrder(decimal.NewFromInt(10_000), NewOrder(false, decimal.NewFromInt(2), 0))
+	ob.PlaceLimitOrder(decimal.NewFromInt(10_500), NewOrder(false, decimal.NewFromInt(5), 0))
+
+	stopOrder := NewOrder(true, decimal.NewFromInt(3), 1)
+	stopOrder.StopPrice = decimal.NewFromInt(10_000)
+	assert(t, ob.PlaceStopOrder(stopOrder), nil)
+	assert(t, stopOrder.IsStopPending(), true)
+	assert(t, ob.StopOrders(true), []*Order{ob.Snapshot(stopOrder)})
+	assert(t, ob.orders[stopOrder.Id], stopOrder)
+
+	// the last trade at 10_000 activates the stop which buys the rest of the level and 2 at 10_500
+	matches, err := ob.PlaceMarketOrder(NewOrder(true, decimal.NewFromInt(1), 2))
+
+	assert(t, err, nil)
+	assert(t, len(matches), 3)
+	assert(t, matches[1].Bid, stopOrder)
+	assert(t, matches[2].Bid, stopOrder)
+	assert(t, matches[2].Price, decimal.NewFromInt(10_500))
+	assert(t, stopOrder.Triggered, true)
+	assert(t, stopOrder.IsFilled(), true)
+	assert(t, ob.StopOrders(true), []*Order{})
+	assert(t, ob.AskTotalVolume(), decimal.NewFromInt(3))
+	assert(t, ob.Trades()[len(ob.Trades())-1].TakerOrderId, stopOrder.Id)
+
+	_, ok := ob.orders[stopOrder.Id]
+	assert(t, ok, false)
+}
+
+func TestStopLimitOrderTriggeredRests(t *testing.T) {
+	ob := NewOrderbook()
+	ob.PlaceLimitOrder(decimal.NewFromInt(9_000), NewOrder(true, decimal.NewFromInt(1), 0))
+	ob.PlaceLimitOrder(decimal.NewFromInt(8_000), NewOrder(true, decimal.NewFromInt(1), 0))
+
+	stopOrder := NewOrder(false, decimal.NewFromInt(3), 1)
+	stopOrder.StopPrice = decimal.NewFromInt(9_000)
+	stopOrder.Price = decimal.NewFromInt(8_500)
+	assert(t, ob.PlaceStopOrder(stopOrder), nil)
+
+	// a trade above the stop price does not trigger a sell stop
+	ob.PlaceLimitOrder(decimal.NewFromInt(9_500), NewOrder(false, decimal.NewFromInt(1), 2))
+	ob.PlaceMarketOrder(NewOrder(true, decimal.NewFromInt(1), 3))
+	assert(t, stopOrder.IsStopPending(), true)
+
+	matches, err := ob.PlaceMarketOrder(NewOrder(false, decimal.NewFromInt(1), 2))
+
+	assert(t, err, nil)
+	assert(t, len(matches), 1)
+	assert(t, stopOrder.IsStopPending(), false)
+	assert(t, stopOrder.Limit, ob.askLimits[decimal.NewFromInt(8_500)])
+	assert(t, ob.BestAsk().Price, decimal.NewFromInt(8_500))
+	assert(t, ob.BidTotalVolume(), decimal.NewFromInt(1))
+	assert(t, ob.orders[stopOrder.Id], stopOrder)
+}
+
+func TestStopOrdersCascade(t *testing.T) {
+	ob := NewOrderbook()
+	ob.PlaceLimitOrder(decimal.NewFromInt(100), NewOrder(true, decimal.NewFromInt(1), 0))
+	ob.PlaceLimitOrder(decimal.NewFromInt(90), NewOrder(true, decimal.NewFromInt(1), 0))
+	ob.PlaceLimitOrder(decimal.NewFromInt(80), NewOrder(true, decimal.NewFromInt(1), 0))
+
+	stopA := NewOrder(false, decimal.NewFromInt(1), 1)
+	stopA.StopPrice = decimal.NewFromInt(100)
+	stopB := NewOrder(false, decimal.NewFromInt(1), 2)
+	stopB.StopPrice = decimal.NewFromInt(90)
+	ob.PlaceStopOrder(stopB)
+	ob.PlaceStopOrder(stopA)
+	assert(t, ob.StopOrders(false), []*Order{ob.Snapshot(stopA), ob.Snapshot(stopB)})
+
+	matches, err := ob.PlaceMarketOrder(NewOrder(false, decimal.NewFromInt(1), 3))
+
+	assert(t, err, nil)
+	assert(t, len(matches), 3)
+	assert(t, matches[1].Ask, stopA)
+	assert(t, matches[1].Price, decimal.NewFromInt(90))
+	assert(t, matches[2].Ask, stopB)
+	assert(t, matches[2].Price, decimal.NewFromInt(80))
+	assert(t, ob.BidTotalVolume(), decimal.Zero)
+	assert(t, len(ob.Trades()), 3)
+}
+
+func TestCancelStopOrder(t *testing.T) {
+	ob := NewOrderbook()
+
+	stopOrder := NewOrder(true, decimal.NewFromInt(1), 1)
+	stopOrder.StopPrice = decimal.NewFromInt(10_000)
+	ob.PlaceStopOrder(stopOrder)
+	ob.CancelOrder(stopOrder.Id)
+
+	assert(t, ob.StopOrders(true), []*Order{})
+	assert(t, ob.buyStops.len(), 0)
+	_, ok := ob.orders[stopOrder.Id]
+	assert(t, ok, false)
+
+	assert(t, ob.PlaceStopOrder(NewOrder(true, decimal.NewFromInt(1), 1)), ErrInvalidStopPrice)
+}
+
+func TestStopOrderAlreadyCrossed(t *testing.T) {
+	ob := NewOrderbook()
+	ob.PlaceLimitOrder(decimal.NewFromInt(100), NewOrder(false, decimal.NewFromInt(2), 0))
+	ob.PlaceMarketOrder(NewOrder(true, decimal.NewFromInt(1), 1))
+
+	// the last trade at 100 is already at or above a buy stop at 90 or 100
+	for _, price := range []int64{90, 100} {
+		stopOrder := NewOrder(true, decimal.NewFromInt(1), 2)
+		stopOrder.StopPrice = decimal.NewFromInt(price)
+		assert(t, ob.PlaceStopOrder(stopOrder), ErrStopPriceCrossed)
+		assert(t, stopOrder.IsStopPending(), false)
+	}
+
+	sellStop := NewOrder(false, decimal.NewFromInt(1), 2)
+	sellStop.StopPrice = decimal.NewFromInt(110)
+	assert(t, ob.PlaceStopOrder(sellStop), ErrStopPriceCrossed)
+	assert(t, ob.StopOrders(true), []*Order{})
+	assert(t, ob.StopOrders(false), []*Order{})
+
+	buyStop := NewOrder(true, decimal.NewFromInt(1), 2)
+	buyStop.StopPrice = decimal.NewFromInt(110)
+	assert(t, ob.PlaceStopOrder(buyStop), nil)
+	assert(t, ob.StopOrders(true), []*Order{ob.Snapshot(buyStop)})
+}
@@ -0,0 +1,17 @@
+package orderbook
+
+// TimeInForce defines how long an order stays active in the book
+type TimeInForce string
+
+const (
+	// GTC (good till cancelled) orders rest in the book until they are filled or cancelled.
+	// It is the default for limit orders.
+	GTC TimeInForce = "GTC"
+	// GTD (good till date) orders rest in the book until they expire
+	GTD TimeInForce = "GTD"
+	// IOC (immediate or cancel) fills what is available and cancels the rest.
+	// It is the default for market orders.
+	IOC TimeInForce = "IOC"
+	// FOK (fill or kill) is either filled completely or rejected
+	FOK TimeInForce = "FOK"
+)
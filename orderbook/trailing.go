@@ -0,0 +1,96 @@
+package orderbook
+
+import (
+	"errors"
+
+	"github.com/PanGan21/crypto-exchange-poc/decimal"
+)
+
+var (
+	ErrInvalidTrailingStop = errors.New("trailing stops need either a positive trailing amount or percent and no limit price")
+	ErrNoTrailingReference = errors.New("no trade or book price for the trailing stop to follow")
+)
+
+// IsTrailing reports whether the stop price of o follows the last trade price
+func (o *Order) IsTrailing() bool {
+	return o.TrailingAmount.IsPositive() || o.TrailingPercent.IsPositive()
+}
+
+// initTrailingStop sets the first stop price of a trailing stop from the last trade price,
+// or from the best price it would execute against when nothing traded yet
+func (ob *Orderbook) initTrailingStop(o *Order) error {
+	if o.TrailingAmount.IsPositive() == o.TrailingPercent.IsPositive() ||
+		o.TrailingAmount.IsNegative() || o.TrailingPercent.IsNegative() ||
+		!o.Price.IsZero() {
+		return ErrInvalidTrailingStop
+	}
+
+	var price decimal.Decimal
+	if len(ob.trades) > 0 {
+		price = ob.trades[len(ob.trades)-1].Price
+	} else if limit := ob.bestOpposite(o.Bid); limit != nil {
+		price = limit.Price
+	} else {
+		return ErrNoTrailingReference
+	}
+
+	stopPrice, err := ob.trailingStopPrice(o, price)
+	if err != nil || !stopPrice.IsPositive() {
+		return ErrInvalidTrailingStop
+	}
+	o.StopPrice = stopPrice
+
+	return nil
+}
+
+// trailingStopPrice is the stop price of o when the market is at price:
+// above it by the offset for buy stops and below it for sell stops.
+// It fails with decimal.ErrOverflow when the offset is too large to compute.
+func (ob *Orderbook) trailingStopPrice(o *Order, price decimal.Decimal) (decimal.Decimal, error) {
+	offset := o.TrailingAmount
+	if o.TrailingPercent.IsPositive() {
+		var err error
+		if offset, err = price.CheckedMul(o.TrailingPercent); err != nil {
+			return offset, err
+		}
+		if offset, err = offset.CheckedDiv(decimal.NewFromInt(100), ob.tickSize.Scale()); err != nil {
+			return offset, err
+		}
+	}
+
+	if o.Bid {
+		return price.CheckedAdd(offset)
+	}
+	return price.CheckedSub(offset)
+}
+
+// trailStops moves the trailing stops after new trades between low and high.
+// Sell stops only move up following the highest price and buy stops
+// only move down following the lowest price.
+func (ob *Orderbook) trailStops(low, high decimal.Decimal) {
+	pending := ob.trailing[:0]
+
+	for _, o := range ob.trailing {
+		if !o.IsStopPending() {
+			continue
+		}
+		pending = append(pending, o)
+
+		price := high
+		if o.Bid {
+			price = low
+		}
+
+		stopPrice, err := ob.trailingStopPrice(o, price)
+		if err != nil {
+			continue
+		}
+		if o.Bid && stopPrice.LessThan(o.StopPrice) || !o.Bid && stopPrice.GreaterThan(o.StopPrice) {
+			ob.removeStop(o)
+			o.StopPrice = stopPrice
+			ob.addStop(o)
+		}
+	}
+
+	ob.trailing = pending
+}
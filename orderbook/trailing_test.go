@@ -0,0 +1,78 @@
+package orderbook
+
+import (
+	"math"
+	"testing"
+
+	"github.com/PanGan21/crypto-exchange-poc/decimal"
+)
+
+func TestTrailingStopFollowsLastTradePrice(t *testing.T) {
+	ob := NewOrderbook()
+	ob.PlaceLimitOrder(decimal.NewFromInt(10_000), NewOrder(false, decimal.NewFromInt(1), 0))
+	ob.PlaceLimitOrder(decimal.NewFromInt(10_200), NewOrder(false, decimal.NewFromInt(1), 0))
+	ob.PlaceLimitOrder(decimal.NewFromInt(9_800), NewOrder(true, decimal.NewFromInt(5), 0))
+	ob.PlaceMarketOrder(NewOrder(true, decimal.NewFromInt(1), 2))
+
+	stopOrder := NewOrder(false, decimal.NewFromInt(2), 1)
+	stopOrder.TrailingAmount = decimal.NewFromInt(300)
+	assert(t, ob.PlaceStopOrder(stopOrder), nil)
+	assert(t, stopOrder.StopPrice, decimal.NewFromInt(9_700))
+
+	// the price goes up and the stop follows it
+	ob.PlaceMarketOrder(NewOrder(true, decimal.NewFromInt(1), 2))
+	assert(t, stopOrder.StopPrice, decimal.NewFromInt(9_900))
+	assert(t, ob.StopOrders(false), []*Order{ob.Snapshot(stopOrder)})
+
+	// a lower trade does not move a sell stop down but it triggers it
+	matches, err := ob.PlaceMarketOrder(NewOrder(false, decimal.NewFromInt(1), 2))
+
+	assert(t, err, nil)
+	assert(t, len(matches), 2)
+	assert(t, matches[1].Ask, stopOrder)
+	assert(t, stopOrder.StopPrice, decimal.NewFromInt(9_900))
+	assert(t, stopOrder.IsFilled(), true)
+	assert(t, ob.StopOrders(false), []*Order{})
+	assert(t, len(ob.trailing), 0)
+}
+
+func TestTrailingStopPercent(t *testing.T) {
+	ob := NewOrderbook(WithTickSize(decimal.New(1, 2)))
+	ob.PlaceLimitOrder(decimal.NewFromInt(2_000), NewOrder(false, decimal.NewFromInt(1), 0))
+
+	// nothing traded yet so a buy stop trails the best ask
+	stopOrder := NewOrder(true, decimal.NewFromInt(1), 1)
+	stopOrder.TrailingPercent = decimal.RequireFromString("2.5")
+	assert(t, ob.PlaceStopOrder(stopOrder), nil)
+	assert(t, stopOrder.StopPrice, decimal.NewFromInt(2_050))
+
+	ob.PlaceLimitOrder(decimal.NewFromInt(1_900), NewOrder(true, decimal.NewFromInt(1), 0))
+	ob.PlaceMarketOrder(NewOrder(false, decimal.NewFromInt(1), 2))
+
+	assert(t, stopOrder.StopPrice, decimal.RequireFromString("1947.5"))
+	assert(t, stopOrder.IsStopPending(), true)
+}
+
+func TestTrailingStopValidation(t *testing.T) {
+	ob := NewOrderbook()
+
+	stopOrder := NewOrder(false, decimal.NewFromInt(1), 1)
+	stopOrder.TrailingAmount = decimal.NewFromInt(100)
+	assert(t, ob.PlaceStopOrder(stopOrder), ErrNoTrailingReference)
+
+	ob.PlaceLimitOrder(decimal.NewFromInt(100), NewOrder(true, decimal.NewFromInt(1), 0))
+	assert(t, ob.PlaceStopOrder(stopOrder), ErrInvalidTrailingStop)
+
+	stopOrder.TrailingAmount = decimal.NewFromInt(10)
+	stopOrder.TrailingPercent = decimal.NewFromInt(1)
+	assert(t, ob.PlaceStopOrder(stopOrder), ErrInvalidTrailingStop)
+
+	// offsets too large to compute
+	ob.PlaceLimitOrder(decimal.NewFromInt(110), NewOrder(false, decimal.NewFromInt(1), 0))
+	stopOrder = NewOrder(true, decimal.NewFromInt(1), 1)
+	stopOrder.TrailingAmount = decimal.New(math.MaxInt64, 0)
+	assert(t, ob.PlaceStopOrder(stopOrder), ErrInvalidTrailingStop)
+	stopOrder.TrailingAmount = decimal.Zero
+	stopOrder.TrailingPercent = decimal.New(math.MaxInt64, 0)
+	assert(t, ob.PlaceStopOrder(stopOrder), ErrInvalidTrailingStop)
+}
@@ -0,0 +1,157 @@
+package server
+
+import (
+	"errors"
+	"log"
+
+	"github.com/PanGan21/crypto-exchange-poc/decimal"
+	"github.com/PanGan21/crypto-exchange-poc/orderbook"
+)
+
+type Liquidity string
+
+const (
+	LiquidityMaker Liquidity = "MAKER"
+	LiquidityTaker Liquidity = "TAKER"
+)
+
+// Fill is a trade of an order
+type Fill struct {
+	TradeId             int64
+	Price               decimal.Decimal
+	Size                decimal.Decimal
+	CounterpartyOrderId int64
+	Fee                 decimal.Decimal // in the quote asset
+	Liquidity           Liquidity
+}
+
+// Execution describes the prices a market order was filled at
+type Execution struct {
+	WorstPrice decimal.Decimal
+	// Slippage of the average price in basis points of the best price when the order arrived
+	Slippage decimal.Decimal
+}
+
+// newFills returns the fills of order among matches with the fees of spec
+func newFills(order *orderbook.Order, matches []orderbook.Match, spec MarketSpec) []Fill {
+	fills := []Fill{}
+
+	for _, match := range matches {
+		counterparty := match.Bid
+		if match.Bid == order {
+			counterparty = match.Ask
+		} else if match.Ask != order {
+			continue
+		}
+
+		liquidity, feeRate := LiquidityMaker, spec.MakerFee
+		if match.Taker == order {
+			liquidity, feeRate = LiquidityTaker, spec.TakerFee
+		}
+
+		// the book keeps the notional of the orders within range, the fee is reported as zero otherwise
+		fee, err := newFee(match.Price, match.SizeFilled, feeRate)
+		if err != nil {
+			log.Printf("fee of trade %d not computed: %v", match.TradeId, err)
+		}
+
+		fills = append(fills, Fill{
+			TradeId:             match.TradeId,
+			Price:               match.Price,
+			Size:                match.SizeFilled,
+			CounterpartyOrderId: counterparty.Id,
+			Fee:                 fee,
+			Liquidity:           liquidity,
+		})
+	}
+
+	return fills
+}
+
+// newFee returns the fee of feeRate basis points on price * size. It keeps the decimals that fit
+// and at least the ones of the notional.
+func newFee(price, size, feeRate decimal.Decimal) (decimal.Decimal, error) {
+	notional, err := price.CheckedMul(size)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	rate, err := feeRate.CheckedDiv(decimal.NewFromInt(10_000), clampScale(feeRate.Scale()+4))
+	if err != nil {
+		return decimal.Zero, err
+	}
+
+	fee, err := notional.CheckedMul(rate)
+	if errors.Is(err, decimal.ErrOverflow) {
+		fee, err = notional.CheckedMulTruncate(rate, notional.Scale())
+	}
+	return fee, err
+}
+
+// averagePrice is the size weighted average price of fills, with two more decimals than scale.
+// The fills are trades of one order, whose notional the book keeps within range.
+func averagePrice(fills []Fill, scale int32) decimal.Decimal {
+	filled, notional := decimal.Zero, decimal.Zero
+	for _, fill := range fills {
+		fillNotional, err := fill.Price.CheckedMul(fill.Size)
+		if err == nil {
+			notional, err = notional.CheckedAdd(fillNotional)
+		}
+		if err != nil {
+			return decimal.Zero
+		}
+		filled = filled.Add(fill.Size)
+	}
+
+	if filled.IsZero() {
+		return decimal.Zero
+	}
+
+	return notional.Div(filled, clampScale(scale+2))
+}
+
+func clampScale(scale int32) int32 {
+	if scale > decimal.MaxScale {
+		return decimal.MaxScale
+	}
+	return scale
+}
+
+// newExecution sums up the fills of a market order, the first one is at the best price
+// when the order arrived
+func newExecution(order *orderbook.Order, fills []Fill, scale int32) *Execution {
+	execution := &Execution{}
+	if len(fills) == 0 {
+		return execution
+	}
+
+	for _, fill := range fills {
+		if execution.WorstPrice.IsZero() || order.Bid && fill.Price.GreaterThan(execution.WorstPrice) || !order.Bid && fill.Price.LessThan(execution.WorstPrice) {
+			execution.WorstPrice = fill.Price
+		}
+	}
+
+	// basis points with two decimals, the ratio is taken first so the prices are not scaled up
+	bestPrice := fills[0].Price
+	ratio := averagePrice(fills, scale).Sub(bestPrice).Abs().Div(bestPrice, 6)
+	execution.Slippage = ratio.Mul(decimal.NewFromInt(10_000))
+
+	return execution
+}
+
+// newPlaceOrderResponse describes order and what it did in matches
+func newPlaceOrderResponse(m *market, order *orderbook.Order, matches []orderbook.Match) *PlaceOrderResponse {
+	// a resting order can trade again as soon as it is placed
+	snapshot := m.ob.Snapshot(order)
+	fills := newFills(order, matches, m.MarketSpec)
+
+	return &PlaceOrderResponse{
+		OrderId:      snapshot.Id,
+		Status:       snapshot.Status,
+		Filled:       snapshot.Filled,
+		Remaining:    snapshot.TotalRemaining(),
+		Price:        snapshot.Price,
+		AveragePrice: averagePrice(fills, m.TickSize.Scale()),
+		Fills:        fills,
+		Prevented:    snapshot.Prevented,
+	}
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/PanGan21/crypto-exchange-poc/decimal"
+	"github.com/PanGan21/crypto-exchange-poc/orderbook"
+)
+
+// assertDecimal compares the value of d to s whatever their scales
+func assertDecimal(t *testing.T, d decimal.Decimal, s string) {
+	t.Helper()
+	if !d.Equal(decimal.RequireFromString(s)) {
+		t.Errorf("%s != %s", d, s)
+	}
+}
+
+func TestNewFee(t *testing.T) {
+	fee, err := newFee(decimal.NewFromInt(100), decimal.NewFromInt(1), decimal.NewFromInt(5))
+	assert(t, err, nil)
+	assertDecimal(t, fee, "0.05")
+
+	fee, err = newFee(decimal.RequireFromString("101.25"), decimal.RequireFromString("0.001"), decimal.RequireFromString("2.5"))
+	assert(t, err, nil)
+	assertDecimal(t, fee, "0.0000253125")
+
+	fee, err = newFee(decimal.NewFromInt(100), decimal.NewFromInt(1), decimal.Zero)
+	assert(t, err, nil)
+	assertDecimal(t, fee, "0")
+
+	// the decimals that don't fit are truncated down to the ones of the notional
+	fee, err = newFee(decimal.NewFromInt(5_000_000_000_000_000_000), decimal.NewFromInt(1), decimal.NewFromInt(5))
+	assert(t, err, nil)
+	assertDecimal(t, fee, "2500000000000000")
+
+	_, err = newFee(decimal.NewFromInt(5_000_000_000_000_000_000), decimal.NewFromInt(2), decimal.NewFromInt(5))
+	assert(t, err, decimal.ErrOverflow)
+}
+
+func TestNewFills(t *testing.T) {
+	spec := ethUSDC()
+	ob := orderbook.NewOrderbook(orderbook.WithTickSize(spec.TickSize))
+
+	askA := orderbook.NewOrder(false, decimal.NewFromInt(1), 1)
+	askB := orderbook.NewOrder(false, decimal.NewFromInt(2), 1)
+	ob.PlaceLimitOrder(decimal.NewFromInt(100), askA)
+	ob.PlaceLimitOrder(decimal.NewFromInt(101), askB)
+
+	bid := orderbook.NewOrder(true, decimal.RequireFromString("2.5"), 2)
+	matches, err := ob.PlaceMarketOrder(bid)
+	assert(t, err, nil)
+
+	fills := newFills(bid, matches, spec)
+	assert(t, len(fills), 2)
+	assert(t, fills[0].TradeId, matches[0].TradeId)
+	assert(t, fills[0].CounterpartyOrderId, askA.Id)
+	assert(t, fills[0].Liquidity, LiquidityTaker)
+	assertDecimal(t, fills[0].Price, "100")
+	assertDecimal(t, fills[0].Size, "1")
+	assertDecimal(t, fills[0].Fee, "0.05")
+	assert(t, fills[1].CounterpartyOrderId, askB.Id)
+	assert(t, fills[1].Liquidity, LiquidityTaker)
+	assertDecimal(t, fills[1].Price, "101")
+	assertDecimal(t, fills[1].Size, "1.5")
+	assertDecimal(t, fills[1].Fee, "0.07575")
+
+	// the makers pay the maker fee and only see their own trades
+	fills = newFills(askB, matches, spec)
+	assert(t, len(fills), 1)
+	assert(t, fills[0].TradeId, matches[1].TradeId)
+	assert(t, fills[0].CounterpartyOrderId, bid.Id)
+	assert(t, fills[0].Liquidity, LiquidityMaker)
+	assertDecimal(t, fills[0].Fee, "0.0303")
+
+	assert(t, newFills(orderbook.NewOrder(true, decimal.NewFromInt(1), 3), matches, spec), []Fill{})
+}
+
+func TestAveragePrice(t *testing.T) {
+	fills := []Fill{
+		{Price: decimal.NewFromInt(100), Size: decimal.NewFromInt(1)},
+		{Price: decimal.NewFromInt(101), Size: decimal.RequireFromString("1.5")},
+	}
+	assertDecimal(t, averagePrice(fills, 2), "100.6")
+
+	// two decimals more than the tick size
+	fills = []Fill{
+		{Price: decimal.RequireFromString("0.01"), Size: decimal.NewFromInt(1)},
+		{Price: decimal.RequireFromString("0.02"), Size: decimal.NewFromInt(2)},
+	}
+	assert(t, averagePrice(fills, 2).String(), "0.0166")
+
+	assertDecimal(t, averagePrice([]Fill{}, 2), "0")
+
+	// a notional that does not fit is reported as zero
+	fills = []Fill{
+		{Price: decimal.NewFromInt(5_000_000_000), Size: decimal.NewFromInt(1_000_000_000)},
+		{Price: decimal.NewFromInt(5_000_000_000), Size: decimal.NewFromInt(1_000_000_000)},
+	}
+	assertDecimal(t, averagePrice(fills, 2), "0")
+}
+
+func TestNewExecution(t *testing.T) {
+	fills := []Fill{
+		{Price: decimal.NewFromInt(100), Size: decimal.NewFromInt(1)},
+		{Price: decimal.NewFromInt(101), Size: decimal.RequireFromString("1.5")},
+	}
+
+	// the average price of 100.6 is 60 basis points above the best price
+	execution := newExecution(orderbook.NewOrder(true, decimal.RequireFromString("2.5"), 1), fills, 2)
+	assertDecimal(t, execution.WorstPrice, "101")
+	assertDecimal(t, execution.Slippage, "60")
+
+	fills = []Fill{
+		{Price: decimal.NewFromInt(100), Size: decimal.NewFromInt(3)},
+		{Price: decimal.NewFromInt(99), Size: decimal.NewFromInt(1)},
+	}
+	execution = newExecution(orderbook.NewOrder(false, decimal.NewFromInt(4), 1), fills, 2)
+	assertDecimal(t, execution.WorstPrice, "99")
+	assertDecimal(t, execution.Slippage, "25")
+
+	execution = newExecution(orderbook.NewOrder(true, decimal.NewFromInt(1), 1), []Fill{}, 2)
+	assert(t, execution, &Execution{})
+}
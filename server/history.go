@@ -0,0 +1,147 @@
+package server
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/PanGan21/crypto-exchange-poc/decimal"
+	"github.com/PanGan21/crypto-exchange-poc/orderbook"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	defaultHistoryLimit = 100
+	maxHistoryLimit     = 1000
+)
+
+// orderRecord is an order of the history of a user, cursor increases with every order placed
+type orderRecord struct {
+	cursor int64
+	market Market
+	order  *orderbook.Order
+}
+
+type HistoryOrder struct {
+	Cursor int64
+	Market Market
+	Order
+	Status       orderbook.OrderStatus
+	Filled       decimal.Decimal
+	Remaining    decimal.Decimal
+	AveragePrice decimal.Decimal
+	Prevented    decimal.Decimal
+}
+
+type OrderHistoryResponse struct {
+	Orders []HistoryOrder
+	// NextCursor is passed as the cursor to get the next page, zero on the last page
+	NextCursor int64 `json:",omitempty"`
+}
+
+// recordOrders adds orders to the history of their users, they are kept forever
+func (ex *Exchange) recordOrders(market Market, orders ...*orderbook.Order) {
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+
+	for _, o := range orders {
+		if o == nil {
+			continue
+		}
+		ex.historyCursor++
+		ex.history[o.UserId] = append(ex.history[o.UserId], orderRecord{
+			cursor: ex.historyCursor,
+			market: market,
+			order:  o,
+		})
+		ex.orderMarkets[o] = market
+	}
+}
+
+// handleGetOrderHistory lists every order of a user oldest first.
+// The optional query parameters market, status, from and to (Unix nanoseconds of the
+// order creation, inclusive) filter the orders, cursor and limit page through them.
+func (ex *Exchange) handleGetOrderHistory(c echo.Context) error {
+	userId, err := strconv.ParseInt(c.Param("userId"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, APIError{Code: CodeInvalidRequest, Error: "invalid user id"})
+	}
+
+	var (
+		market = Market(c.QueryParam("market"))
+		status = orderbook.OrderStatus(c.QueryParam("status"))
+		from   int64
+		to     int64
+		cursor int64
+		limit  = int64(defaultHistoryLimit)
+	)
+
+	for _, param := range []struct {
+		name  string
+		value *int64
+	}{
+		{"from", &from},
+		{"to", &to},
+		{"cursor", &cursor},
+		{"limit", &limit},
+	} {
+		raw := c.QueryParam(param.name)
+		if raw == "" {
+			continue
+		}
+		v, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || v < 0 {
+			return c.JSON(http.StatusBadRequest, APIError{Code: CodeInvalidRequest, Error: "invalid " + param.name})
+		}
+		*param.value = v
+	}
+
+	if limit == 0 || limit > maxHistoryLimit {
+		return c.JSON(http.StatusBadRequest, APIError{Code: CodeInvalidRequest, Error: "limit must be between 1 and " + strconv.Itoa(maxHistoryLimit)})
+	}
+
+	if market != "" {
+		if _, ok := ex.market(market); !ok {
+			return c.JSON(http.StatusBadRequest, APIError{Code: CodeMarketNotFound, Error: "market not found"})
+		}
+	}
+
+	resp := &OrderHistoryResponse{Orders: []HistoryOrder{}}
+
+	ex.mu.RLock()
+	defer ex.mu.RUnlock()
+
+	records := ex.history[userId]
+	start := sort.Search(len(records), func(i int) bool {
+		return records[i].cursor > cursor
+	})
+
+	for _, record := range records[start:] {
+		m, _ := ex.market(record.market)
+		o := m.ob.Snapshot(record.order)
+		if market != "" && record.market != market ||
+			status != "" && o.Status != status ||
+			from > 0 && o.Timestamp < from ||
+			to > 0 && o.Timestamp > to {
+			continue
+		}
+
+		if int64(len(resp.Orders)) == limit {
+			resp.NextCursor = resp.Orders[len(resp.Orders)-1].Cursor
+			break
+		}
+
+		resp.Orders = append(resp.Orders, HistoryOrder{
+			Cursor:       record.cursor,
+			Market:       record.market,
+			Order:        newOrder(o),
+			Status:       o.Status,
+			Filled:       o.Filled,
+			Remaining:    o.TotalRemaining(),
+			AveragePrice: o.AveragePrice(m.TickSize.Scale() + 2),
+			Prevented:    o.Prevented,
+		})
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
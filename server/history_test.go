@@ -0,0 +1,135 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/PanGan21/crypto-exchange-poc/decimal"
+	"github.com/PanGan21/crypto-exchange-poc/engine"
+	"github.com/PanGan21/crypto-exchange-poc/orderbook"
+)
+
+// placeLimit places a limit order created at timestamp straight on the engine of market
+func placeLimit(t *testing.T, ex *Exchange, market Market, bid bool, price, size, userId, timestamp int64) *orderbook.Order {
+	o := orderbook.NewOrder(bid, decimal.NewFromInt(size), userId)
+	o.Price = decimal.NewFromInt(price)
+	o.Timestamp = timestamp
+	res := ex.submit(market, &engine.Command{Type: engine.CommandPlace, Kind: engine.KindLimit, Order: o})
+	assert(t, res.Err, nil)
+	return o
+}
+
+// history returns the order history of user 1 with the query string query
+func history(t *testing.T, ex *Exchange, query string) (int, OrderHistoryResponse) {
+	var resp OrderHistoryResponse
+	rec := serve(ex.handleGetOrderHistory, httptest.NewRequest(http.MethodGet, "/?"+query, nil), "userId", "1")
+	if rec.Code == http.StatusOK {
+		assert(t, json.Unmarshal(rec.Body.Bytes(), &resp), nil)
+	}
+	return rec.Code, resp
+}
+
+func historyIds(resp OrderHistoryResponse) []int64 {
+	ids := []int64{}
+	for _, o := range resp.Orders {
+		ids = append(ids, o.Id)
+	}
+	return ids
+}
+
+func TestOrderHistoryFilters(t *testing.T) {
+	ex, err := NewExchange(testPrivateKey, nil, DefaultMarkets(), nil)
+	assert(t, err, nil)
+
+	filled := placeLimit(t, ex, "ETH-USDC", false, 100, 1, 1, 1_000)
+	resting := placeLimit(t, ex, "WBTC-ETH", false, 15, 1, 1, 2_000)
+	cancelled := placeLimit(t, ex, "ETH-USDC", true, 90, 1, 1, 3_000)
+	assert(t, ex.submit("ETH-USDC", &engine.Command{Type: engine.CommandCancel, OrderId: cancelled.Id}).Err, nil)
+	placeLimit(t, ex, "ETH-USDC", true, 100, 1, 2, 4_000)
+
+	code, resp := history(t, ex, "")
+	assert(t, code, http.StatusOK)
+	assert(t, historyIds(resp), []int64{filled.Id, resting.Id, cancelled.Id})
+	assert(t, resp.Orders[0].Status, orderbook.StatusFilled)
+	assert(t, resp.Orders[0].Market, Market("ETH-USDC"))
+	assert(t, resp.Orders[0].AveragePrice.Equal(decimal.NewFromInt(100)), true)
+	assert(t, resp.Orders[1].Status, orderbook.StatusNew)
+	assert(t, resp.Orders[1].Remaining, decimal.NewFromInt(1))
+	assert(t, resp.Orders[2].Status, orderbook.StatusCancelled)
+	assert(t, resp.NextCursor, int64(0))
+
+	for _, tc := range []struct {
+		query string
+		ids   []int64
+	}{
+		{"market=WBTC-ETH", []int64{resting.Id}},
+		{"market=ETH-USDC", []int64{filled.Id, cancelled.Id}},
+		{"status=FILLED", []int64{filled.Id}},
+		{"status=CANCELLED", []int64{cancelled.Id}},
+		{"status=EXPIRED", []int64{}},
+		{"market=ETH-USDC&status=NEW", []int64{}},
+		// from and to are inclusive
+		{"from=2000", []int64{resting.Id, cancelled.Id}},
+		{"to=2000", []int64{filled.Id, resting.Id}},
+		{"from=1001&to=2999", []int64{resting.Id}},
+		{"from=5000", []int64{}},
+	} {
+		code, resp := history(t, ex, tc.query)
+		assert(t, code, http.StatusOK)
+		if ids := historyIds(resp); !reflect.DeepEqual(ids, tc.ids) {
+			t.Errorf("%s: %v != %v", tc.query, ids, tc.ids)
+		}
+	}
+
+	for _, query := range []string{"market=BTC-USDC", "from=-1", "to=x", "cursor=-5", "limit=0", "limit=1001"} {
+		code, _ := history(t, ex, query)
+		assert(t, code, http.StatusBadRequest)
+	}
+}
+
+func TestOrderHistoryPages(t *testing.T) {
+	ex, err := NewExchange(testPrivateKey, nil, DefaultMarkets(), nil)
+	assert(t, err, nil)
+
+	placed := []int64{}
+	for i := int64(0); i < 7; i++ {
+		market := Market("ETH-USDC")
+		if i%2 == 1 {
+			market = "WBTC-ETH"
+		}
+		placed = append(placed, placeLimit(t, ex, market, true, 10+i, 1, 1, 1_000+i).Id)
+		// the orders of other users take cursors in between
+		placeLimit(t, ex, market, true, 10+i, 1, 2, 1_000+i)
+	}
+
+	// walk the pages of 3 orders until there is no next cursor
+	pages := [][]int64{}
+	query := "limit=3"
+	for {
+		code, resp := history(t, ex, query)
+		assert(t, code, http.StatusOK)
+		pages = append(pages, historyIds(resp))
+		if resp.NextCursor == 0 {
+			break
+		}
+		query = "limit=3&cursor=" + strconv.FormatInt(resp.NextCursor, 10)
+	}
+	assert(t, pages, [][]int64{placed[0:3], placed[3:6], placed[6:7]})
+
+	// the filters apply before paging
+	pages = [][]int64{}
+	query = "limit=2&market=WBTC-ETH"
+	for {
+		_, resp := history(t, ex, query)
+		pages = append(pages, historyIds(resp))
+		if resp.NextCursor == 0 {
+			break
+		}
+		query = "limit=2&market=WBTC-ETH&cursor=" + strconv.FormatInt(resp.NextCursor, 10)
+	}
+	assert(t, pages, [][]int64{{placed[1], placed[3]}, {placed[5]}})
+}
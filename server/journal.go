@@ -0,0 +1,169 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/PanGan21/crypto-exchange-poc/engine"
+	"github.com/PanGan21/crypto-exchange-poc/orderbook"
+)
+
+// journalRecord is a change of the exchange journaled next to the commands of the engines
+type journalRecord struct {
+	AddMarket *MarketConfig `json:",omitempty"`
+	Halted    *bool         `json:",omitempty"`
+	// SelfTradePrevention is the mode set for the account of UserId
+	UserId              int64                          `json:",omitempty"`
+	SelfTradePrevention *orderbook.SelfTradePrevention `json:",omitempty"`
+}
+
+// journalRecord appends record about market to the journal of the exchange
+func (ex *Exchange) journalRecord(market Market, record journalRecord) error {
+	if ex.journal == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	return ex.journal.Append(&engine.Entry{Market: string(market), Data: data})
+}
+
+// update runs f, which changes the exchange and journals the change, so that
+// a snapshot is taken either before or after both
+func (ex *Exchange) update(f func() error) error {
+	if ex.journal == nil {
+		return f()
+	}
+	return ex.journal.Update(f)
+}
+
+// Recover rebuilds the markets, their books and the orders of the users from
+// the latest snapshot, nil when there is none, and the journal entries that follow it
+func (ex *Exchange) Recover(snapshot *exchangeSnapshot, entries []*engine.Entry) error {
+	if snapshot != nil {
+		if err := ex.restoreSnapshot(snapshot); err != nil {
+			return fmt.Errorf("snapshot: %w", err)
+		}
+	}
+
+	for i, entry := range entries {
+		market := Market(entry.Market)
+
+		if entry.Command == nil {
+			if err := ex.recoverRecord(market, entry.Data); err != nil {
+				return fmt.Errorf("journal entry %d: %w", i, err)
+			}
+			continue
+		}
+
+		m, ok := ex.market(market)
+		if !ok {
+			return fmt.Errorf("journal entry %d: market %s not found", i, market)
+		}
+
+		if _, err := m.engine.Replay(entry); err != nil {
+			return fmt.Errorf("journal entry %d: %w", i, err)
+		}
+	}
+
+	log.Printf("recovered %d journal entries", len(entries))
+
+	return nil
+}
+
+func (ex *Exchange) recoverRecord(market Market, data json.RawMessage) error {
+	var record journalRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return err
+	}
+
+	if record.AddMarket != nil {
+		if _, ok := ex.market(market); ok {
+			// added to the markets config since
+			return nil
+		}
+		if err := ex.addMarket(*record.AddMarket, false); err != nil {
+			return err
+		}
+	}
+
+	if record.Halted != nil {
+		m, ok := ex.market(market)
+		if !ok {
+			return fmt.Errorf("market %s not found", market)
+		}
+		m.halted.Store(*record.Halted)
+	}
+
+	if record.SelfTradePrevention != nil {
+		if err := ex.setSelfTradePrevention(record.UserId, *record.SelfTradePrevention); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// setSelfTradePrevention restores the self-trade prevention mode of the account of userId
+func (ex *Exchange) setSelfTradePrevention(userId int64, mode orderbook.SelfTradePrevention) error {
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+
+	user, ok := ex.Users[userId]
+	if !ok {
+		return fmt.Errorf("user %d not found", userId)
+	}
+	user.SelfTradePrevention = mode
+
+	return nil
+}
+
+// applied keeps track of the orders of the commands applied to the book of market,
+// whether they come from the handlers or from the journal
+func (ex *Exchange) applied(market Market) func(cmd *engine.Command, res engine.Result) {
+	return func(cmd *engine.Command, res engine.Result) {
+		switch cmd.Type {
+		case engine.CommandPlace:
+			ex.recordPlaced(market, cmd, res)
+		case engine.CommandExpire:
+			ex.recordExpired(market, res.Expired)
+		}
+
+		if len(res.Matches) > 0 || len(res.Cancelled) > 0 {
+			ex.removeClosedOrders(res)
+		}
+	}
+}
+
+// recordPlaced tracks the orders and group placed by cmd and adds them to the order history,
+// only the rejected ones are kept when the book refused them. It runs on the engine of market,
+// the only one changing the orders.
+func (ex *Exchange) recordPlaced(market Market, cmd *engine.Command, res engine.Result) {
+	if res.Err != nil {
+		for _, o := range []*orderbook.Order{cmd.Order, cmd.StopLoss} {
+			if o != nil && o.Status == orderbook.StatusRejected {
+				ex.recordOrders(market, o)
+			}
+		}
+		return
+	}
+
+	orders := []*orderbook.Order{cmd.Order, cmd.TakeProfit, cmd.StopLoss}
+
+	ex.mu.Lock()
+	if res.Group != nil {
+		ex.Groups[cmd.Order.UserId] = append(ex.Groups[cmd.Order.UserId], res.Group)
+	}
+	for _, o := range orders {
+		if o != nil && (o.IsResting() || o.IsStopPending()) {
+			ex.Orders[o.UserId] = append(ex.Orders[o.UserId], o)
+		}
+	}
+	ex.mu.Unlock()
+
+	ex.recordOrders(market, orders...)
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/PanGan21/crypto-exchange-poc/decimal"
+	"github.com/PanGan21/crypto-exchange-poc/engine"
+	"github.com/PanGan21/crypto-exchange-poc/orderbook"
+)
+
+// journaledExchange runs markets for users 1 and 2 with the journal in dir
+// and recovers what the journal holds
+func journaledExchange(t *testing.T, dir string, markets []MarketConfig) *Exchange {
+	journal, entries, err := engine.OpenJournal(dir, engine.SyncNever, 0)
+	assert(t, err, nil)
+	t.Cleanup(func() { journal.Close() })
+
+	ex, err := NewExchange(testPrivateKey, nil, markets, journal)
+	assert(t, err, nil)
+	for _, id := range []int64{1, 2} {
+		ex.Users[id] = NewUser(testPrivateKey, id)
+	}
+	ex.adminKey = "secret"
+
+	assert(t, ex.Recover(nil, entries), nil)
+	return ex
+}
+
+func TestRecoverExchangeRecords(t *testing.T) {
+	dir := t.TempDir()
+	ex := journaledExchange(t, dir, DefaultMarkets())
+
+	rec := adminCall(ex, ex.handleAddMarket, "secret", `{"Base": "SOL", "Quote": "USDC", "TickSize": "0.01", "LotSize": "0.1"}`)
+	assert(t, rec.Code, http.StatusOK)
+	rec = call(ex.handlePlaceOrder, `{"UserId": 1, "Type": "LIMIT", "Market": "SOL-USDC", "Size": "1.5", "Price": "20"}`)
+	assert(t, rec.Code, http.StatusOK)
+
+	// the last record of a market or a user wins
+	for _, halted := range []bool{true, false, true} {
+		rec = adminCall(ex, ex.handleHaltMarket(halted), "secret", "", "market", "WBTC-ETH")
+		assert(t, rec.Code, http.StatusOK)
+	}
+	rec = adminCall(ex, ex.handleHaltMarket(true), "secret", "", "market", "SOL-USDC")
+	assert(t, rec.Code, http.StatusOK)
+	rec = adminCall(ex, ex.handleHaltMarket(false), "secret", "", "market", "SOL-USDC")
+	assert(t, rec.Code, http.StatusOK)
+
+	for _, req := range []struct{ userId, body string }{
+		{"1", `{"Mode": "CANCEL_OLDEST"}`},
+		{"2", `{"Mode": "CANCEL_BOTH"}`},
+		{"1", `{"Mode": "DECREMENT_AND_CANCEL"}`},
+		{"2", `{"Mode": ""}`},
+	} {
+		rec = call(ex.handleSetSelfTradePrevention, req.body, "id", req.userId)
+		assert(t, rec.Code, http.StatusOK)
+	}
+	assert(t, ex.journal.Close(), nil)
+
+	recovered := journaledExchange(t, dir, DefaultMarkets())
+
+	assert(t, exchangeState(t, recovered), exchangeState(t, ex))
+	m, ok := recovered.market("SOL-USDC")
+	assert(t, ok, true)
+	assert(t, m.TickSize, decimal.RequireFromString("0.01"))
+	assert(t, m.halted.Load(), false)
+	m, _ = recovered.market("WBTC-ETH")
+	assert(t, m.halted.Load(), true)
+	m, _ = recovered.market("ETH-USDC")
+	assert(t, m.halted.Load(), false)
+	assert(t, recovered.Users[1].SelfTradePrevention, orderbook.SelfTradeDecrementAndCancel)
+	assert(t, recovered.Users[2].SelfTradePrevention, orderbook.SelfTradePrevention(""))
+
+	book := func(ex *Exchange) string {
+		return serve(ex.handleGetBook, httptest.NewRequest(http.MethodGet, "/", nil), "market", "SOL-USDC").Body.String()
+	}
+	assert(t, book(recovered), book(ex))
+
+	// the orders of the new market are recovered with it
+	var resp GetOrdersResponse
+	rec = call(recovered.handleGetOrders, "", "userId", "1")
+	assert(t, json.Unmarshal(rec.Body.Bytes(), &resp), nil)
+	assert(t, len(resp.Asks), 1)
+}
+
+func TestRecoverAddedMarketInConfig(t *testing.T) {
+	dir := t.TempDir()
+	ex := journaledExchange(t, dir, DefaultMarkets())
+
+	rec := adminCall(ex, ex.handleAddMarket, "secret", `{"Base": "SOL", "Quote": "USDC", "TickSize": "0.01", "LotSize": "0.1"}`)
+	assert(t, rec.Code, http.StatusOK)
+	rec = adminCall(ex, ex.handleHaltMarket(true), "secret", "", "market", "SOL-USDC")
+	assert(t, rec.Code, http.StatusOK)
+	assert(t, ex.journal.Close(), nil)
+
+	// a market added to the config since keeps the config and replays the records that follow
+	cfg := MarketConfig{Base: "SOL", Quote: "USDC", MarketSpec: ethUSDC()}
+	recovered := journaledExchange(t, dir, append(DefaultMarkets(), cfg))
+
+	m, ok := recovered.market("SOL-USDC")
+	assert(t, ok, true)
+	assert(t, m.MarketSpec, ethUSDC())
+	assert(t, m.halted.Load(), true)
+
+	// a record of a market that is gone fails the recovery
+	dir = t.TempDir()
+	ex = journaledExchange(t, dir, DefaultMarkets())
+	rec = adminCall(ex, ex.handleHaltMarket(true), "secret", "", "market", "WBTC-ETH")
+	assert(t, rec.Code, http.StatusOK)
+	assert(t, ex.journal.Close(), nil)
+
+	journal, entries, err := engine.OpenJournal(dir, engine.SyncNever, 0)
+	assert(t, err, nil)
+	defer journal.Close()
+	ex, err = NewExchange(testPrivateKey, nil, DefaultMarkets()[:1], journal)
+	assert(t, err, nil)
+	assert(t, ex.Recover(nil, entries) == nil, false)
+}
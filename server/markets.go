@@ -0,0 +1,465 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"sync/atomic"
+
+	"github.com/PanGan21/crypto-exchange-poc/decimal"
+	"github.com/PanGan21/crypto-exchange-poc/engine"
+	"github.com/PanGan21/crypto-exchange-poc/orderbook"
+	"github.com/labstack/echo/v4"
+)
+
+// ErrorCode is the machine readable reason of an APIError
+type ErrorCode string
+
+const (
+	CodeInvalidRequest ErrorCode = "INVALID_REQUEST"
+	CodeMarketNotFound ErrorCode = "MARKET_NOT_FOUND"
+	CodeMarketHalted   ErrorCode = "MARKET_HALTED"
+	CodeNotFound       ErrorCode = "NOT_FOUND"
+	CodeForbidden      ErrorCode = "FORBIDDEN"
+	CodeOrderRejected  ErrorCode = "ORDER_REJECTED"
+	CodeInvalidPrice   ErrorCode = "INVALID_PRICE"
+	CodeInvalidSize    ErrorCode = "INVALID_SIZE"
+	CodeTickSize       ErrorCode = "TICK_SIZE"
+	CodeLotSize        ErrorCode = "LOT_SIZE"
+	CodeMinSize        ErrorCode = "MIN_SIZE"
+	CodeMaxSize        ErrorCode = "MAX_SIZE"
+	CodeMinNotional    ErrorCode = "MIN_NOTIONAL"
+	CodeMaxNotional    ErrorCode = "MAX_NOTIONAL"
+	CodePriceBand      ErrorCode = "PRICE_BAND"
+)
+
+// MarketSpec holds the trading rules of a market
+type MarketSpec struct {
+	TickSize    decimal.Decimal // prices are multiples of the tick size
+	LotSize     decimal.Decimal // sizes are multiples of the lot size
+	MinSize     decimal.Decimal
+	MaxSize     decimal.Decimal
+	MinNotional decimal.Decimal // smallest price * size of a limit order
+	// MaxNotional is the largest price * size of an order, it keeps the notional of the fills
+	// within what a decimal holds at the scale of the tick size plus the lot size
+	MaxNotional decimal.Decimal
+	// PriceBand is how far in percent a limit price can be from the last trade price
+	PriceBand decimal.Decimal
+	// MakerFee and TakerFee are charged in basis points of the notional of the fills
+	MakerFee decimal.Decimal
+	TakerFee decimal.Decimal
+}
+
+// MarketConfig defines a market trading the Base asset against the Quote asset
+type MarketConfig struct {
+	Base  string
+	Quote string
+	MarketSpec
+}
+
+// Name is how the market is referred to in the API, e.g. ETH-USDC
+func (cfg MarketConfig) Name() Market {
+	return Market(cfg.Base + "-" + cfg.Quote)
+}
+
+// DefaultMarkets are listed when there is no markets configuration file
+func DefaultMarkets() []MarketConfig {
+	return []MarketConfig{
+		{
+			Base:  "ETH",
+			Quote: "USDC",
+			MarketSpec: MarketSpec{
+				TickSize:    decimal.RequireFromString("0.01"),
+				LotSize:     decimal.RequireFromString("0.00000001"),
+				MinSize:     decimal.RequireFromString("0.0001"),
+				MaxSize:     decimal.NewFromInt(1_000_000),
+				MinNotional: decimal.NewFromInt(10),
+				MaxNotional: decimal.NewFromInt(100_000_000),
+				PriceBand:   decimal.NewFromInt(20),
+				MakerFee:    decimal.NewFromInt(2),
+				TakerFee:    decimal.NewFromInt(5),
+			},
+		},
+		{
+			Base:  "WBTC",
+			Quote: "ETH",
+			MarketSpec: MarketSpec{
+				TickSize:    decimal.RequireFromString("0.0001"),
+				LotSize:     decimal.RequireFromString("0.00000001"),
+				MinSize:     decimal.RequireFromString("0.0001"),
+				MaxSize:     decimal.NewFromInt(1_000),
+				MinNotional: decimal.RequireFromString("0.01"),
+				MaxNotional: decimal.NewFromInt(1_000_000),
+				PriceBand:   decimal.NewFromInt(20),
+				MakerFee:    decimal.NewFromInt(2),
+				TakerFee:    decimal.NewFromInt(5),
+			},
+		},
+	}
+}
+
+// LoadMarkets reads a JSON list of MarketConfig from path
+func LoadMarkets(path string) ([]MarketConfig, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	markets := []MarketConfig{}
+	if err := json.NewDecoder(f).Decode(&markets); err != nil {
+		return nil, fmt.Errorf("decoding markets %s: %w", path, err)
+	}
+
+	return markets, nil
+}
+
+// market is a market of the registry with the engine running its book
+type market struct {
+	MarketConfig
+	// halted markets reject new orders and amendments, cancels are still accepted
+	halted atomic.Bool
+	engine *engine.Engine
+	ob     *orderbook.Orderbook // read only, every change goes through the engine
+}
+
+// addMarket registers a new market with an empty book, a journaled market
+// only shows up once its record is in the journal
+func (ex *Exchange) addMarket(cfg MarketConfig, journaled bool) error {
+	if cfg.Base == "" || cfg.Quote == "" || cfg.Base == cfg.Quote {
+		return errors.New("a market needs different base and quote assets")
+	}
+	if !cfg.TickSize.IsPositive() || !cfg.LotSize.IsPositive() {
+		return errors.New("tick size and lot size must be positive")
+	}
+	if cfg.MakerFee.IsNegative() || cfg.TakerFee.IsNegative() {
+		return errors.New("fees can't be negative")
+	}
+
+	ex.marketsMu.Lock()
+	defer ex.marketsMu.Unlock()
+
+	if _, ok := ex.markets[cfg.Name()]; ok {
+		return fmt.Errorf("market %s already exists", cfg.Name())
+	}
+
+	if journaled {
+		if err := ex.journalRecord(cfg.Name(), journalRecord{AddMarket: &cfg}); err != nil {
+			return fmt.Errorf("%w: %v", engine.ErrJournal, err)
+		}
+	}
+
+	e := engine.New(string(cfg.Name()), ex.engineOptions(cfg)...)
+	ex.markets[cfg.Name()] = &market{
+		MarketConfig: cfg,
+		engine:       e,
+		ob:           e.Book(),
+	}
+
+	return nil
+}
+
+// restoreMarket registers the market cfg with its book restored from a snapshot,
+// in place of the market with the same name
+func (ex *Exchange) restoreMarket(cfg MarketConfig, books *orderbook.Snapshot, state engine.State) error {
+	e, err := engine.Restore(string(cfg.Name()), books, state, ex.engineOptions(cfg)...)
+	if err != nil {
+		return err
+	}
+
+	ex.marketsMu.Lock()
+	defer ex.marketsMu.Unlock()
+
+	if m, ok := ex.markets[cfg.Name()]; ok {
+		m.engine.Stop()
+	}
+	ex.markets[cfg.Name()] = &market{
+		MarketConfig: cfg,
+		engine:       e,
+		ob:           e.Book(),
+	}
+
+	return nil
+}
+
+// engineOptions are the options of the engine running the book of the market cfg
+func (ex *Exchange) engineOptions(cfg MarketConfig) []engine.Option {
+	return []engine.Option{
+		engine.WithEvents(ex.events),
+		engine.WithJournal(ex.journal),
+		engine.WithApplied(ex.applied(cfg.Name())),
+		engine.WithBookOptions(
+			orderbook.WithIDGenerator(ex.ids),
+			orderbook.WithTickSize(cfg.TickSize),
+		),
+	}
+}
+
+func (ex *Exchange) market(name Market) (*market, bool) {
+	ex.marketsMu.RLock()
+	defer ex.marketsMu.RUnlock()
+
+	m, ok := ex.markets[name]
+	return m, ok
+}
+
+// submit runs cmd on the engine of a market that is known to exist
+func (ex *Exchange) submit(name Market, cmd *engine.Command) engine.Result {
+	m, _ := ex.market(name)
+	return m.engine.Submit(cmd)
+}
+
+// listMarkets returns the markets ordered by name
+func (ex *Exchange) listMarkets() []*market {
+	ex.marketsMu.RLock()
+	defer ex.marketsMu.RUnlock()
+
+	markets := make([]*market, 0, len(ex.markets))
+	for _, m := range ex.markets {
+		markets = append(markets, m)
+	}
+
+	sort.Slice(markets, func(i, j int) bool {
+		return markets[i].Name() < markets[j].Name()
+	})
+
+	return markets
+}
+
+// findOrder looks for the book an order is resting or waiting in and returns a copy of it,
+// ids are unique across markets so there is at most one
+func (ex *Exchange) findOrder(id int64) (Market, *orderbook.Order, bool) {
+	for _, m := range ex.listMarkets() {
+		if o, ok := m.ob.Order(id); ok {
+			return m.Name(), o, true
+		}
+	}
+	return "", nil, false
+}
+
+type MarketResponse struct {
+	Market Market
+	Base   string
+	Quote  string
+	Halted bool
+	MarketSpec
+}
+
+func newMarketResponse(m *market) MarketResponse {
+	return MarketResponse{
+		Market:     m.Name(),
+		Base:       m.Base,
+		Quote:      m.Quote,
+		Halted:     m.halted.Load(),
+		MarketSpec: m.MarketSpec,
+	}
+}
+
+func (ex *Exchange) handleGetMarket(c echo.Context) error {
+	m, ok := ex.market(Market(c.Param("market")))
+	if !ok {
+		return c.JSON(http.StatusNotFound, APIError{Code: CodeMarketNotFound, Error: "market not found"})
+	}
+
+	return c.JSON(http.StatusOK, newMarketResponse(m))
+}
+
+func (ex *Exchange) handleListMarkets(c echo.Context) error {
+	markets := []MarketResponse{}
+	for _, m := range ex.listMarkets() {
+		markets = append(markets, newMarketResponse(m))
+	}
+
+	return c.JSON(http.StatusOK, markets)
+}
+
+func (ex *Exchange) handleAddMarket(c echo.Context) error {
+	var cfg MarketConfig
+	if err := json.NewDecoder(c.Request().Body).Decode(&cfg); err != nil {
+		return c.JSON(http.StatusBadRequest, APIError{Code: CodeInvalidRequest, Error: err.Error()})
+	}
+
+	err := ex.update(func() error {
+		return ex.addMarket(cfg, true)
+	})
+	if errors.Is(err, engine.ErrJournal) {
+		return err
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, APIError{Code: CodeInvalidRequest, Error: err.Error()})
+	}
+
+	m, _ := ex.market(cfg.Name())
+	return c.JSON(http.StatusOK, newMarketResponse(m))
+}
+
+// handleHaltMarket halts or resumes trading in a market
+func (ex *Exchange) handleHaltMarket(halted bool) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		m, ok := ex.market(Market(c.Param("market")))
+		if !ok {
+			return c.JSON(http.StatusNotFound, APIError{Code: CodeMarketNotFound, Error: "market not found"})
+		}
+
+		err := ex.update(func() error {
+			if err := ex.journalRecord(m.Name(), journalRecord{Halted: &halted}); err != nil {
+				return err
+			}
+			m.halted.Store(halted)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusOK, newMarketResponse(m))
+	}
+}
+
+// requireAdmin only lets through requests carrying the admin key in the X-Admin-Key header,
+// the admin API is disabled when the exchange has no admin key
+func (ex *Exchange) requireAdmin(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		key := c.Request().Header.Get("X-Admin-Key")
+		if ex.adminKey == "" || subtle.ConstantTimeCompare([]byte(key), []byte(ex.adminKey)) != 1 {
+			return c.JSON(http.StatusForbidden, APIError{Code: CodeForbidden, Error: "admin key required"})
+		}
+		return next(c)
+	}
+}
+
+type namedPrice struct {
+	name  string
+	price decimal.Decimal
+}
+
+// validateOrder checks the prices and sizes of an order against the rules of its market,
+// lastPrice is zero when the market did not trade yet
+func (s MarketSpec) validateOrder(req PlaceOrderRequest, lastPrice decimal.Decimal) *APIError {
+	if apiErr := s.validateSize("size", req.Size); apiErr != nil {
+		return apiErr
+	}
+
+	if !req.DisplaySize.IsZero() && !req.DisplaySize.Mod(s.LotSize).IsZero() {
+		return &APIError{Code: CodeLotSize, Error: fmt.Sprintf("display size must be a multiple of the lot size %s", s.LotSize)}
+	}
+
+	prices := []namedPrice{
+		{"price", req.Price},
+		{"stop price", req.StopPrice},
+		{"trailing amount", req.TrailingAmount},
+		{"peg cap", req.PegCap},
+		{"worst price", req.WorstPrice},
+	}
+	if req.Group != nil {
+		prices = append(prices,
+			namedPrice{"take profit price", req.Group.TakeProfitPrice},
+			namedPrice{"stop loss price", req.Group.StopLossPrice},
+			namedPrice{"stop loss limit price", req.Group.StopLossLimitPrice},
+		)
+	}
+
+	for _, p := range prices {
+		if p.price.IsNegative() {
+			return &APIError{Code: CodeInvalidPrice, Error: fmt.Sprintf("%s can't be negative", p.name)}
+		}
+		if !p.price.Mod(s.TickSize).IsZero() {
+			return &APIError{Code: CodeTickSize, Error: fmt.Sprintf("%s must be a multiple of the tick size %s", p.name, s.TickSize)}
+		}
+	}
+
+	// the offset of a pegged order can go both ways
+	if !req.PegOffset.Mod(s.TickSize).IsZero() {
+		return &APIError{Code: CodeTickSize, Error: fmt.Sprintf("peg offset must be a multiple of the tick size %s", s.TickSize)}
+	}
+
+	if req.Type == LimitOrder && !req.Price.IsPositive() {
+		return &APIError{Code: CodeInvalidPrice, Error: "limit orders need a positive price"}
+	}
+
+	if req.Price.IsPositive() {
+		return s.validateLimitPrice(req.Price, req.Size, lastPrice)
+	}
+
+	// orders without a limit price are held to the max notional at their stop price or the last trade price
+	reference := req.StopPrice
+	if reference.IsZero() {
+		reference = lastPrice
+	}
+	if reference.IsPositive() {
+		notional, err := reference.CheckedMul(req.Size)
+		return s.validateMaxNotional(notional, err)
+	}
+
+	return nil
+}
+
+// validateAmend checks the new price and size of an amended order
+func (s MarketSpec) validateAmend(price, size, lastPrice decimal.Decimal) *APIError {
+	if apiErr := s.validateSize("size", size); apiErr != nil {
+		return apiErr
+	}
+
+	if !price.IsPositive() {
+		return &APIError{Code: CodeInvalidPrice, Error: "price must be positive"}
+	}
+
+	if !price.Mod(s.TickSize).IsZero() {
+		return &APIError{Code: CodeTickSize, Error: fmt.Sprintf("price must be a multiple of the tick size %s", s.TickSize)}
+	}
+
+	return s.validateLimitPrice(price, size, lastPrice)
+}
+
+func (s MarketSpec) validateSize(name string, size decimal.Decimal) *APIError {
+	if !size.IsPositive() {
+		return &APIError{Code: CodeInvalidSize, Error: fmt.Sprintf("%s must be positive", name)}
+	}
+
+	if !size.Mod(s.LotSize).IsZero() {
+		return &APIError{Code: CodeLotSize, Error: fmt.Sprintf("%s must be a multiple of the lot size %s", name, s.LotSize)}
+	}
+
+	if size.LessThan(s.MinSize) {
+		return &APIError{Code: CodeMinSize, Error: fmt.Sprintf("%s must be at least %s", name, s.MinSize)}
+	}
+
+	if s.MaxSize.IsPositive() && size.GreaterThan(s.MaxSize) {
+		return &APIError{Code: CodeMaxSize, Error: fmt.Sprintf("%s must be at most %s", name, s.MaxSize)}
+	}
+
+	return nil
+}
+
+// validateLimitPrice checks the price band and the notional of a limit order
+func (s MarketSpec) validateLimitPrice(price, size, lastPrice decimal.Decimal) *APIError {
+	if s.PriceBand.IsPositive() && lastPrice.IsPositive() {
+		band := lastPrice.Mul(s.PriceBand).Div(decimal.NewFromInt(100), s.TickSize.Scale())
+		if price.LessThan(lastPrice.Sub(band)) || price.GreaterThan(lastPrice.Add(band)) {
+			return &APIError{Code: CodePriceBand, Error: fmt.Sprintf("price must be within %s%% of the last trade price %s", s.PriceBand, lastPrice)}
+		}
+	}
+
+	// a notional too large to compute is above any minimum
+	notional, err := price.CheckedMul(size)
+	if s.MinNotional.IsPositive() && err == nil && notional.LessThan(s.MinNotional) {
+		return &APIError{Code: CodeMinNotional, Error: fmt.Sprintf("price * size must be at least %s", s.MinNotional)}
+	}
+
+	return s.validateMaxNotional(notional, err)
+}
+
+// validateMaxNotional checks the notional of an order, err is the overflow of its computation
+func (s MarketSpec) validateMaxNotional(notional decimal.Decimal, err error) *APIError {
+	if err != nil {
+		return &APIError{Code: CodeMaxNotional, Error: "price * size is too large"}
+	}
+	if s.MaxNotional.IsPositive() && notional.GreaterThan(s.MaxNotional) {
+		return &APIError{Code: CodeMaxNotional, Error: fmt.Sprintf("price * size must be at most %s", s.MaxNotional)}
+	}
+	return nil
+}
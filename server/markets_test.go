@@ -0,0 +1,278 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/PanGan21/crypto-exchange-poc/decimal"
+	"github.com/labstack/echo/v4"
+)
+
+func ethUSDC() MarketSpec {
+	return DefaultMarkets()[0].MarketSpec
+}
+
+func TestValidateOrder(t *testing.T) {
+	limit := func(price, size string) PlaceOrderRequest {
+		return PlaceOrderRequest{Type: LimitOrder, Price: decimal.RequireFromString(price), Size: decimal.RequireFromString(size)}
+	}
+	lastPrice := decimal.NewFromInt(100)
+
+	for _, tc := range []struct {
+		name string
+		req  PlaceOrderRequest
+		code ErrorCode
+	}{
+		{"valid", limit("100", "1"), ""},
+		{"zero size", limit("100", "0"), CodeInvalidSize},
+		{"negative size", limit("100", "-1"), CodeInvalidSize},
+		{"lot size", limit("100", "1.000000001"), CodeLotSize},
+		{"min size", limit("100", "0.00001"), CodeMinSize},
+		{"max size", limit("100", "1000001"), CodeMaxSize},
+		{"tick size", limit("100.001", "1"), CodeTickSize},
+		{"no limit price", limit("0", "1"), CodeInvalidPrice},
+		{"negative price", limit("-100", "1"), CodeInvalidPrice},
+		{"min notional", limit("100", "0.09"), CodeMinNotional},
+		{"max notional", limit("110", "1000000"), CodeMaxNotional},
+		{"price band low", limit("79.99", "1"), CodePriceBand},
+		{"price band high", limit("120.01", "1"), CodePriceBand},
+		{"price band edge", limit("120", "1"), ""},
+		{"stop price tick size", PlaceOrderRequest{Type: StopMarketOrder, Size: decimal.NewFromInt(1), StopPrice: decimal.RequireFromString("110.001")}, CodeTickSize},
+		// orders without a price are held to the max notional at their stop price or the last trade price
+		{"stop max notional", PlaceOrderRequest{Type: StopMarketOrder, Size: decimal.NewFromInt(1_000_000), StopPrice: decimal.NewFromInt(110)}, CodeMaxNotional},
+		{"market max size", PlaceOrderRequest{Type: MarketOrder, Size: decimal.NewFromInt(1_000_001)}, CodeMaxSize},
+		{"market notional at last price", PlaceOrderRequest{Type: MarketOrder, Size: decimal.NewFromInt(1_000_000)}, ""},
+		{"display size lot size", PlaceOrderRequest{Type: LimitOrder, Price: lastPrice, Size: decimal.NewFromInt(1), DisplaySize: decimal.RequireFromString("0.000000001")}, CodeLotSize},
+		{"take profit tick size", PlaceOrderRequest{Type: LimitOrder, Price: lastPrice, Size: decimal.NewFromInt(1), Group: &OrderGroupRequest{TakeProfitPrice: decimal.RequireFromString("110.005")}}, CodeTickSize},
+	} {
+		apiErr := ethUSDC().validateOrder(tc.req, lastPrice)
+		if tc.code == "" {
+			if apiErr != nil {
+				t.Errorf("%s: unexpected %+v", tc.name, apiErr)
+			}
+			continue
+		}
+		if apiErr == nil || apiErr.Code != tc.code {
+			t.Errorf("%s: %+v != %s", tc.name, apiErr, tc.code)
+		}
+	}
+}
+
+func TestValidateOrderNotionalOverflow(t *testing.T) {
+	spec := unboundedMarket().MarketSpec
+
+	// a notional too large to compute fails the max notional even without one
+	req := PlaceOrderRequest{Type: LimitOrder, Price: decimal.NewFromInt(1_000_000_000_000), Size: decimal.NewFromInt(1_000_000_000_000)}
+	assert(t, spec.validateOrder(req, decimal.Zero).Code, CodeMaxNotional)
+
+	// and so is a market order at the last trade price
+	req = PlaceOrderRequest{Type: MarketOrder, Size: decimal.NewFromInt(1_000_000_000_000)}
+	assert(t, spec.validateOrder(req, decimal.NewFromInt(1_000_000_000_000)).Code, CodeMaxNotional)
+	assert(t, spec.validateOrder(req, decimal.Zero), (*APIError)(nil))
+}
+
+func TestValidateAmend(t *testing.T) {
+	lastPrice := decimal.NewFromInt(100)
+
+	for _, tc := range []struct {
+		price string
+		size  string
+		code  ErrorCode
+	}{
+		{"100", "2", ""},
+		{"100", "0", CodeInvalidSize},
+		{"100", "0.000000001", CodeLotSize},
+		{"100", "0.00001", CodeMinSize},
+		{"100", "1000001", CodeMaxSize},
+		{"0", "1", CodeInvalidPrice},
+		{"100.001", "1", CodeTickSize},
+		{"100", "0.05", CodeMinNotional},
+		{"110", "1000000", CodeMaxNotional},
+		{"121", "1", CodePriceBand},
+	} {
+		apiErr := ethUSDC().validateAmend(decimal.RequireFromString(tc.price), decimal.RequireFromString(tc.size), lastPrice)
+		if tc.code == "" {
+			if apiErr != nil {
+				t.Errorf("%s @ %s: unexpected %+v", tc.size, tc.price, apiErr)
+			}
+			continue
+		}
+		if apiErr == nil || apiErr.Code != tc.code {
+			t.Errorf("%s @ %s: %+v != %s", tc.size, tc.price, apiErr, tc.code)
+		}
+	}
+}
+
+func TestPlaceOrderValidation(t *testing.T) {
+	ex, err := NewExchange(testPrivateKey, nil, DefaultMarkets(), nil)
+	assert(t, err, nil)
+
+	var apiErr APIError
+	rec := call(ex.handlePlaceOrder, `{"UserId": 1, "Type": "LIMIT", "Market": "ETH-USDC", "Size": "1", "Price": "100.001"}`)
+	assert(t, rec.Code, http.StatusBadRequest)
+	assert(t, json.Unmarshal(rec.Body.Bytes(), &apiErr), nil)
+	assert(t, apiErr.Code, CodeTickSize)
+
+	rec = call(ex.handlePlaceOrder, `{"UserId": 1, "Type": "LIMIT", "Market": "BTC-USDC", "Size": "1", "Price": "100"}`)
+	assert(t, rec.Code, http.StatusBadRequest)
+	assert(t, json.Unmarshal(rec.Body.Bytes(), &apiErr), nil)
+	assert(t, apiErr.Code, CodeMarketNotFound)
+}
+
+func TestLoadMarkets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "markets.json")
+	assert(t, os.WriteFile(path, []byte(`[{"Base": "SOL", "Quote": "USDC", "TickSize": "0.001", "LotSize": "0.1", "MaxSize": "1000", "TakerFee": "5"}]`), 0o644), nil)
+
+	markets, err := LoadMarkets(path)
+	assert(t, err, nil)
+	assert(t, markets, []MarketConfig{{
+		Base:  "SOL",
+		Quote: "USDC",
+		MarketSpec: MarketSpec{
+			TickSize: decimal.RequireFromString("0.001"),
+			LotSize:  decimal.RequireFromString("0.1"),
+			MaxSize:  decimal.NewFromInt(1000),
+			TakerFee: decimal.NewFromInt(5),
+		},
+	}})
+	assert(t, markets[0].Name(), Market("SOL-USDC"))
+
+	_, err = LoadMarkets(filepath.Join(t.TempDir(), "missing.json"))
+	assert(t, errors.Is(err, os.ErrNotExist), true)
+
+	assert(t, os.WriteFile(path, []byte(`[{"Base": "SOL", "TickSize": 1e3}]`), 0o644), nil)
+	_, err = LoadMarkets(path)
+	assert(t, err == nil, false)
+}
+
+func TestNewExchangeInvalidMarkets(t *testing.T) {
+	_, err := NewExchange(testPrivateKey, nil, append(DefaultMarkets(), DefaultMarkets()[0]), nil)
+	assert(t, err == nil, false)
+
+	cfg := unboundedMarket()
+	cfg.TickSize = decimal.Zero
+	_, err = NewExchange(testPrivateKey, nil, []MarketConfig{cfg}, nil)
+	assert(t, err == nil, false)
+}
+
+// adminCall runs handler behind the admin key check with key in the X-Admin-Key header
+func adminCall(ex *Exchange, handler echo.HandlerFunc, key, body string, params ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	if key != "" {
+		req.Header.Set("X-Admin-Key", key)
+	}
+	return serve(ex.requireAdmin(handler), req, params...)
+}
+
+func TestAdminKey(t *testing.T) {
+	ex, err := NewExchange(testPrivateKey, nil, DefaultMarkets(), nil)
+	assert(t, err, nil)
+
+	// the admin API is disabled without an admin key
+	rec := adminCall(ex, ex.handleListMarkets, "secret", "")
+	assert(t, rec.Code, http.StatusForbidden)
+
+	ex.adminKey = "secret"
+	for _, key := range []string{"", "wrong", "secre"} {
+		var apiErr APIError
+		rec = adminCall(ex, ex.handleListMarkets, key, "")
+		assert(t, rec.Code, http.StatusForbidden)
+		assert(t, json.Unmarshal(rec.Body.Bytes(), &apiErr), nil)
+		assert(t, apiErr.Code, CodeForbidden)
+	}
+
+	rec = adminCall(ex, ex.handleListMarkets, "secret", "")
+	assert(t, rec.Code, http.StatusOK)
+}
+
+func TestAddMarket(t *testing.T) {
+	ex, err := NewExchange(testPrivateKey, nil, DefaultMarkets(), nil)
+	assert(t, err, nil)
+	ex.adminKey = "secret"
+
+	rec := adminCall(ex, ex.handleAddMarket, "", `{"Base": "SOL", "Quote": "USDC", "TickSize": "0.01", "LotSize": "0.1"}`)
+	assert(t, rec.Code, http.StatusForbidden)
+	_, ok := ex.market("SOL-USDC")
+	assert(t, ok, false)
+
+	var resp MarketResponse
+	rec = adminCall(ex, ex.handleAddMarket, "secret", `{"Base": "SOL", "Quote": "USDC", "TickSize": "0.01", "LotSize": "0.1"}`)
+	assert(t, rec.Code, http.StatusOK)
+	assert(t, json.Unmarshal(rec.Body.Bytes(), &resp), nil)
+	assert(t, resp.Market, Market("SOL-USDC"))
+	assert(t, resp.TickSize, decimal.RequireFromString("0.01"))
+	assert(t, len(ex.listMarkets()), 3)
+
+	rec = call(ex.handlePlaceOrder, `{"UserId": 1, "Type": "LIMIT", "Market": "SOL-USDC", "Size": "1.5", "Price": "20"}`)
+	assert(t, rec.Code, http.StatusOK)
+
+	for _, body := range []string{
+		`{"Base": "SOL", "Quote": "USDC", "TickSize": "0.01", "LotSize": "0.1"}`,
+		`{"Base": "SOL", "Quote": "SOL", "TickSize": "0.01", "LotSize": "0.1"}`,
+		`{"Base": "SOL", "TickSize": "0.01", "LotSize": "0.1"}`,
+		`{"Base": "ADA", "Quote": "USDC", "TickSize": "0", "LotSize": "0.1"}`,
+		`{"Base": "ADA", "Quote": "USDC", "TickSize": "0.01", "LotSize": "0.1", "MakerFee": "-1"}`,
+		`{"Base": "ADA", "Quote": "USDC", "TickSize": 0.01}`,
+	} {
+		var apiErr APIError
+		rec = adminCall(ex, ex.handleAddMarket, "secret", body)
+		assert(t, rec.Code, http.StatusBadRequest)
+		assert(t, json.Unmarshal(rec.Body.Bytes(), &apiErr), nil)
+		assert(t, apiErr.Code, CodeInvalidRequest)
+	}
+	assert(t, len(ex.listMarkets()), 3)
+}
+
+func TestHaltMarket(t *testing.T) {
+	ex, err := NewExchange(testPrivateKey, nil, DefaultMarkets(), nil)
+	assert(t, err, nil)
+	ex.adminKey = "secret"
+
+	rec := call(ex.handlePlaceOrder, `{"UserId": 1, "Type": "LIMIT", "Market": "ETH-USDC", "Size": "1", "Price": "100"}`)
+	assert(t, rec.Code, http.StatusOK)
+	var placed PlaceOrderResponse
+	assert(t, json.Unmarshal(rec.Body.Bytes(), &placed), nil)
+
+	rec = adminCall(ex, ex.handleHaltMarket(true), "wrong", "", "market", "ETH-USDC")
+	assert(t, rec.Code, http.StatusForbidden)
+
+	var resp MarketResponse
+	rec = adminCall(ex, ex.handleHaltMarket(true), "secret", "", "market", "ETH-USDC")
+	assert(t, rec.Code, http.StatusOK)
+	assert(t, json.Unmarshal(rec.Body.Bytes(), &resp), nil)
+	assert(t, resp.Halted, true)
+
+	rec = adminCall(ex, ex.handleHaltMarket(true), "secret", "", "market", "BTC-USDC")
+	assert(t, rec.Code, http.StatusNotFound)
+
+	// a halted market rejects new orders and amendments but accepts cancels
+	var apiErr APIError
+	rec = call(ex.handlePlaceOrder, `{"UserId": 1, "Type": "LIMIT", "Market": "ETH-USDC", "Size": "1", "Price": "100"}`)
+	assert(t, rec.Code, http.StatusConflict)
+	assert(t, json.Unmarshal(rec.Body.Bytes(), &apiErr), nil)
+	assert(t, apiErr.Code, CodeMarketHalted)
+
+	rec = call(ex.handleAmendOrder, `{"Price": "100", "Size": "2"}`, "id", strconv.FormatInt(placed.OrderId, 10))
+	assert(t, rec.Code, http.StatusConflict)
+
+	// the other markets keep trading
+	rec = call(ex.handlePlaceOrder, `{"UserId": 1, "Type": "LIMIT", "Market": "WBTC-ETH", "Size": "1", "Price": "15"}`)
+	assert(t, rec.Code, http.StatusOK)
+
+	rec = adminCall(ex, ex.handleHaltMarket(false), "secret", "", "market", "ETH-USDC")
+	assert(t, rec.Code, http.StatusOK)
+	rec = call(ex.handlePlaceOrder, `{"UserId": 1, "Type": "LIMIT", "Market": "ETH-USDC", "Size": "1", "Price": "100"}`)
+	assert(t, rec.Code, http.StatusOK)
+
+	rec = adminCall(ex, ex.handleHaltMarket(true), "secret", "", "market", "ETH-USDC")
+	assert(t, rec.Code, http.StatusOK)
+	rec = call(ex.handleCancelOrder, "", "id", strconv.FormatInt(placed.OrderId, 10))
+	assert(t, rec.Code, http.StatusOK)
+}
@@ -4,13 +4,18 @@ import (
 	"context"
 	"crypto/ecdsa"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"math/big"
 	"net/http"
+	"os"
 	"strconv"
 	"sync"
+	"time"
 
+	"github.com/PanGan21/crypto-exchange-poc/decimal"
+	"github.com/PanGan21/crypto-exchange-poc/engine"
 	"github.com/PanGan21/crypto-exchange-poc/orderbook"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
@@ -20,10 +25,14 @@ import (
 )
 
 const (
-	MarketOrder OrderType = "MARKET"
-	LimitOrder  OrderType = "LIMIT"
-
-	MarketETH Market = "ETH"
+	MarketOrder     OrderType = "MARKET"
+	LimitOrder      OrderType = "LIMIT"
+	StopMarketOrder OrderType = "STOP_MARKET"
+	StopLimitOrder  OrderType = "STOP_LIMIT"
+	// TrailingStopOrder is a stop market order whose stop price follows the last trade price
+	TrailingStopOrder OrderType = "TRAILING_STOP"
+	// PeggedOrder is a limit order whose price tracks the top of the book
+	PeggedOrder OrderType = "PEGGED"
 
 	// Just for development fake private key
 	exchangePrivateKey = "4f3edf983ac636a65a842ce7c78d9aa706d3b113bce9c46f30d7d21715b23b1d"
@@ -34,38 +43,93 @@ type (
 	Market    string
 
 	PlaceOrderRequest struct {
-		Type   OrderType // limit or market
+		Type   OrderType // limit, market, stop market or stop limit
 		UserId int64
 		Bid    bool
-		Size   float64
-		Price  float64
+		Size   decimal.Decimal
+		Price  decimal.Decimal
 		Market Market
+		// GTC (default), GTD, IOC or FOK for limit orders, IOC (default) or FOK for market orders
+		TimeInForce orderbook.TimeInForce
+		ExpiresAt   int64 // Unix nanoseconds, required for GTD orders
+		// REJECT or SLIDE makes a limit order maker only
+		PostOnly orderbook.PostOnly
+		// StopPrice is the last trade price that activates stop orders
+		StopPrice decimal.Decimal
+		// TrailingAmount or TrailingPercent is the distance of a trailing stop to the last trade price
+		TrailingAmount  decimal.Decimal
+		TrailingPercent decimal.Decimal
+		// Peg is PRIMARY or MID for pegged orders, PegOffset is added to the peg price
+		// and PegCap is the highest price of a pegged bid or the lowest of a pegged ask
+		Peg       orderbook.Peg
+		PegOffset decimal.Decimal
+		PegCap    decimal.Decimal
+		// MaxSlippage in basis points of the best price at arrival or WorstPrice stop a market
+		// order from filling further, the remainder is cancelled
+		MaxSlippage decimal.Decimal
+		WorstPrice  decimal.Decimal
+		// DisplaySize turns a limit order into an iceberg that only shows this much in the book
+		DisplaySize decimal.Decimal
+		// SelfTradePrevention overrides the self-trade prevention mode of the account
+		SelfTradePrevention orderbook.SelfTradePrevention
+		// Group links exit orders to this order
+		Group *OrderGroupRequest `json:",omitempty"`
+	}
+
+	// OrderGroupRequest describes the exits of a group.
+	// An OCO group is placed with a LIMIT order that is its take profit and a stop loss
+	// on the same side, a BRACKET group with a LIMIT or MARKET entry order that
+	// activates a take profit and a stop loss on the opposite side once filled.
+	OrderGroupRequest struct {
+		Type orderbook.GroupType
+		// TakeProfitPrice is the limit price of the take profit of a bracket
+		TakeProfitPrice decimal.Decimal
+		// StopLossPrice is the stop price of the stop loss
+		StopLossPrice decimal.Decimal
+		// StopLossLimitPrice makes the stop loss a stop limit order
+		StopLossLimitPrice decimal.Decimal
+	}
+
+	SelfTradePreventionRequest struct {
+		// Mode applies to the orders of the user that don't set their own, empty turns it off
+		Mode orderbook.SelfTradePrevention
 	}
 
 	Order struct {
-		Id        int64
-		UserId    int64
-		Price     float64
-		Size      float64
-		Bid       bool
-		Timestamp int64
+		Id          int64
+		UserId      int64
+		Price       decimal.Decimal
+		Size        decimal.Decimal
+		Bid         bool
+		Timestamp   int64
+		TimeInForce orderbook.TimeInForce `json:",omitempty"`
+		ExpiresAt   int64                 `json:",omitempty"`
+		StopPrice   decimal.Decimal       // current trigger of trailing stops
+		// TotalRemaining includes the hidden reserve of iceberg orders, only shown to the owner
+		TotalRemaining  *decimal.Decimal `json:",omitempty"`
+		TrailingAmount  *decimal.Decimal `json:",omitempty"`
+		TrailingPercent *decimal.Decimal `json:",omitempty"`
+		Peg             orderbook.Peg    `json:",omitempty"`
+		PegOffset       *decimal.Decimal `json:",omitempty"`
+		PegCap          *decimal.Decimal `json:",omitempty"`
 	}
 
 	OrderbookData struct {
-		TotalBidVolume float64
-		TotalAskVolume float64
+		TotalBidVolume decimal.Decimal
+		TotalAskVolume decimal.Decimal
 		Asks           []*Order
 		Bids           []*Order
 	}
 
 	MatchedOrder struct {
 		UserId int64
-		Price  float64
-		Size   float64
+		Price  decimal.Decimal
+		Size   decimal.Decimal
 		Id     int64
 	}
 
 	APIError struct {
+		Code  ErrorCode
 		Error string
 	}
 )
@@ -79,11 +143,54 @@ func Start() {
 		log.Fatal(err)
 	}
 
-	ex, err := NewExchange(exchangePrivateKey, client)
+	marketsConfig := os.Getenv("MARKETS_CONFIG")
+	if marketsConfig == "" {
+		marketsConfig = "markets.json"
+	}
+
+	markets, err := LoadMarkets(marketsConfig)
+	if errors.Is(err, os.ErrNotExist) {
+		markets = DefaultMarkets()
+	} else if err != nil {
+		log.Fatal(err)
+	}
+
+	journalDir := os.Getenv("JOURNAL_DIR")
+	if journalDir == "" {
+		journalDir = "journal"
+	}
+
+	journalSync := engine.SyncPolicy(os.Getenv("JOURNAL_SYNC"))
+	if journalSync == "" {
+		journalSync = engine.SyncAlways
+	}
+
+	snapshotInterval := time.Minute
+	if interval := os.Getenv("SNAPSHOT_INTERVAL"); interval != "" {
+		snapshotInterval, err = time.ParseDuration(interval)
+		if err != nil || snapshotInterval <= 0 {
+			log.Fatalf("invalid snapshot interval [%s]", interval)
+		}
+	}
+
+	// only the journal after the latest snapshot is replayed
+	var snapshot *exchangeSnapshot
+	segment, _, err := engine.LoadSnapshot(journalDir, &snapshot)
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	journal, entries, err := engine.OpenJournal(journalDir, journalSync, segment)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ex, err := NewExchange(exchangePrivateKey, client, markets, journal)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ex.adminKey = os.Getenv("ADMIN_KEY")
+
 	pk5 := "395df67f0c2d2d9fe1ad08d1bc8b6627011959b79c53d7dd6a3536a33ab8a4fd"
 	user5 := NewUser(pk5, 5)
 	ex.Users[user5.Id] = user5
@@ -96,16 +203,37 @@ func Start() {
 	user6 := NewUser(pk6, 6)
 	ex.Users[user6.Id] = user6
 
+	// the journal holds the settings of the users
+	if err := ex.Recover(snapshot, entries); err != nil {
+		log.Fatal(err)
+	}
+
+	e.GET("/markets", ex.handleListMarkets)
+	e.GET("/markets/:market", ex.handleGetMarket)
 	e.GET("/trades/:market", ex.handleGetTrades)
 	e.GET("/order/:userId", ex.handleGetOrders)
+	e.GET("/orders/:userId/history", ex.handleGetOrderHistory)
 	e.GET("/book/:market", ex.handleGetBook)
 	e.GET("/book/:market/bid", ex.handleGetBestBid)
 	e.GET("/book/:market/ask", ex.handleGetBestAsk)
 
 	e.POST("/order", ex.handlePlaceOrder)
 
+	e.PATCH("/order/:id", ex.handleAmendOrder)
+
 	e.DELETE("/order/:id", ex.handleCancelOrder)
 
+	e.PUT("/user/:id/self-trade-prevention", ex.handleSetSelfTradePrevention)
+
+	admin := e.Group("/admin", ex.requireAdmin)
+	admin.GET("/markets", ex.handleListMarkets)
+	admin.POST("/markets", ex.handleAddMarket)
+	admin.POST("/markets/:market/halt", ex.handleHaltMarket(true))
+	admin.POST("/markets/:market/resume", ex.handleHaltMarket(false))
+
+	go ex.expireOrders(time.Second)
+	go ex.snapshots(snapshotInterval)
+
 	buyerAddress := common.HexToAddress("0x28a8746e75304c0780E011BEd21C72cD78cd535E")
 	buyerBalance, err := client.BalanceAt(context.Background(), buyerAddress, nil)
 	if err != nil {
@@ -134,6 +262,8 @@ func Start() {
 type User struct {
 	Id         int64
 	PrivateKey *ecdsa.PrivateKey
+	// SelfTradePrevention is the default mode for the orders of the user
+	SelfTradePrevention orderbook.SelfTradePrevention
 }
 
 func NewUser(privateKey string, id int64) *User {
@@ -153,46 +283,134 @@ func httpErrorHandler(err error, c echo.Context) {
 }
 
 type Exchange struct {
-	Client     *ethclient.Client
-	mu         sync.RWMutex
-	Users      map[int64]*User
-	Orders     map[int64][]*orderbook.Order // user to his orders
-	PrivateKey *ecdsa.PrivateKey
-	orderbooks map[Market]*orderbook.Orderbook
+	Client  *ethclient.Client
+	mu      sync.RWMutex
+	Users   map[int64]*User
+	Orders  map[int64][]*orderbook.Order // user to his orders
+	Expired map[int64][]*orderbook.Order // user to his GTD orders that expired
+	Groups  map[int64][]*orderbook.OrderGroup
+	// history of every order placed by a user, historyCursor is the cursor of the last order
+	history       map[int64][]orderRecord
+	historyCursor int64
+	// orderMarkets is the market of every order of the history, the book of the market
+	// keeps changing an open order and only copies of it can be read
+	orderMarkets map[*orderbook.Order]Market
+	PrivateKey   *ecdsa.PrivateKey
+	marketsMu    sync.RWMutex
+	markets      map[Market]*market
+	// ids are shared by all the books so they are unique across markets
+	ids *orderbook.IDGenerator
+	// events of the engines of all the markets, in order within a market
+	events chan engine.Event
+	// journal keeps the commands of the engines, nil keeps everything in memory only
+	journal *engine.Journal
+	// snapshotSegment is the journal segment of the last snapshot
+	snapshotSegment uint64
+	// adminKey protects the admin API, which is disabled when it is empty
+	adminKey string
 }
 
-func NewExchange(privateKey string, client *ethclient.Client) (*Exchange, error) {
-	orderbooks := make(map[Market]*orderbook.Orderbook)
-	orderbooks[MarketETH] = orderbook.NewOrderbook()
-
+func NewExchange(privateKey string, client *ethclient.Client, markets []MarketConfig, journal *engine.Journal) (*Exchange, error) {
 	pk, err := crypto.HexToECDSA(privateKey)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Exchange{
-		Client:     client,
-		Users:      make(map[int64]*User),
-		Orders:     make(map[int64][]*orderbook.Order),
-		PrivateKey: pk,
-		orderbooks: orderbooks,
-	}, nil
+	ex := &Exchange{
+		Client:       client,
+		Users:        make(map[int64]*User),
+		Orders:       make(map[int64][]*orderbook.Order),
+		Expired:      make(map[int64][]*orderbook.Order),
+		Groups:       make(map[int64][]*orderbook.OrderGroup),
+		history:      make(map[int64][]orderRecord),
+		orderMarkets: make(map[*orderbook.Order]Market),
+		PrivateKey:   pk,
+		markets:      make(map[Market]*market),
+		ids:          orderbook.NewIDGenerator(),
+		events:       make(chan engine.Event, 1024),
+		journal:      journal,
+	}
+
+	go ex.handleEvents()
+
+	for _, cfg := range markets {
+		if err := ex.addMarket(cfg, false); err != nil {
+			return nil, err
+		}
+	}
+
+	return ex, nil
 }
 
 func (ex *Exchange) handleGetTrades(c echo.Context) error {
-	market := Market(c.Param("market"))
-
-	ob, ok := ex.orderbooks[market]
+	m, ok := ex.market(Market(c.Param("market")))
 	if !ok {
-		return c.JSON(http.StatusBadRequest, APIError{Error: "orderbook not found"})
+		return c.JSON(http.StatusBadRequest, APIError{Code: CodeMarketNotFound, Error: "orderbook not found"})
 	}
 
-	return c.JSON(http.StatusOK, ob.Trades)
+	return c.JSON(http.StatusOK, m.ob.Trades())
 }
 
 type GetOrdersResponse struct {
-	Asks []Order
-	Bids []Order
+	Asks    []Order
+	Bids    []Order
+	Expired []Order
+	Stops   []Order // stop orders waiting for their trigger
+	Groups  []OrderGroup
+}
+
+type OrderGroup struct {
+	Id         int64
+	Type       orderbook.GroupType
+	Status     orderbook.GroupStatus
+	Entry      *Order `json:",omitempty"`
+	TakeProfit Order  // the exits of a bracket have no id until the entry is filled
+	StopLoss   Order
+}
+
+func newOrderGroup(g *orderbook.OrderGroup) OrderGroup {
+	group := OrderGroup{
+		Id:         g.Id,
+		Type:       g.Type,
+		Status:     g.Status,
+		TakeProfit: newOrder(g.TakeProfit),
+		StopLoss:   newOrder(g.StopLoss),
+	}
+
+	if g.Entry != nil {
+		entry := newOrder(g.Entry)
+		group.Entry = &entry
+	}
+
+	return group
+}
+
+func newOrder(o *orderbook.Order) Order {
+	order := Order{
+		Id:          o.Id,
+		UserId:      o.UserId,
+		Price:       o.Price,
+		Size:        o.Size,
+		Timestamp:   o.Timestamp,
+		Bid:         o.Bid,
+		TimeInForce: o.TimeInForce,
+		ExpiresAt:   o.ExpiresAt,
+		StopPrice:   o.StopPrice,
+	}
+
+	if o.TrailingAmount.IsPositive() {
+		order.TrailingAmount = &o.TrailingAmount
+	}
+	if o.TrailingPercent.IsPositive() {
+		order.TrailingPercent = &o.TrailingPercent
+	}
+	if o.Peg != "" {
+		order.Peg = o.Peg
+		order.PegOffset = &o.PegOffset
+		order.PegCap = &o.PegCap
+	}
+
+	return order
 }
 
 func (ex *Exchange) handleGetOrders(c echo.Context) error {
@@ -203,24 +421,26 @@ func (ex *Exchange) handleGetOrders(c echo.Context) error {
 	}
 
 	ex.mu.RLock()
-	orderbookOrders := ex.Orders[int64(userId)]
 	ordersResponse := &GetOrdersResponse{
-		Asks: []Order{},
-		Bids: []Order{},
+		Asks:    []Order{},
+		Bids:    []Order{},
+		Expired: []Order{},
+		Stops:   []Order{},
+		Groups:  []OrderGroup{},
 	}
-	for i := 0; i < len(orderbookOrders); i++ {
-		if orderbookOrders[i].Limit == nil {
-			fmt.Printf("the limit of the order is nil %+v\n", orderbookOrders[i])
+	for _, o := range ex.Orders[int64(userId)] {
+		snapshot := ex.orderSnapshot(o)
+		if snapshot.IsStopPending() {
+			ordersResponse.Stops = append(ordersResponse.Stops, newOrder(snapshot))
 			continue
 		}
-		order := Order{
-			Id:        orderbookOrders[i].Id,
-			UserId:    orderbookOrders[i].UserId,
-			Price:     orderbookOrders[i].Limit.Price,
-			Size:      orderbookOrders[i].Size,
-			Timestamp: orderbookOrders[i].Timestamp,
-			Bid:       orderbookOrders[i].Bid,
+		if !snapshot.IsResting() {
+			// closed orders are only listed in the order history
+			continue
 		}
+		order := newOrder(snapshot)
+		totalRemaining := snapshot.TotalRemaining()
+		order.TotalRemaining = &totalRemaining
 
 		if order.Bid {
 			ordersResponse.Bids = append(ordersResponse.Bids, order)
@@ -229,59 +449,72 @@ func (ex *Exchange) handleGetOrders(c echo.Context) error {
 		}
 
 	}
+	for _, expired := range ex.Expired[int64(userId)] {
+		ordersResponse.Expired = append(ordersResponse.Expired, newOrder(ex.orderSnapshot(expired)))
+	}
+	for _, group := range ex.Groups[int64(userId)] {
+		ordersResponse.Groups = append(ordersResponse.Groups, newOrderGroup(ex.groupSnapshot(group)))
+	}
 	ex.mu.RUnlock()
 
 	return c.JSON(http.StatusOK, ordersResponse)
 }
 
+// orderSnapshot returns a copy of the order o of the history taken from the book of its market, ex.mu must be held
+func (ex *Exchange) orderSnapshot(o *orderbook.Order) *orderbook.Order {
+	m, _ := ex.market(ex.orderMarkets[o])
+	return m.ob.Snapshot(o)
+}
+
+// groupSnapshot returns a copy of the group g of the orders of the history, ex.mu must be held
+func (ex *Exchange) groupSnapshot(g *orderbook.OrderGroup) *orderbook.OrderGroup {
+	m, _ := ex.market(ex.orderMarkets[g.TakeProfit])
+	return m.ob.SnapshotGroup(g)
+}
+
 func (ex *Exchange) handleGetBook(c echo.Context) error {
-	market := Market(c.Param("market"))
-	ob, ok := ex.orderbooks[market]
+	m, ok := ex.market(Market(c.Param("market")))
 	if !ok {
-		return c.JSON(http.StatusBadRequest, map[string]any{"msg": "market not found"})
+		return c.JSON(http.StatusBadRequest, APIError{Code: CodeMarketNotFound, Error: "market not found"})
 	}
 
 	orderbookData := &OrderbookData{
-		TotalBidVolume: ob.BidTotalVolume(),
-		TotalAskVolume: ob.AskTotalVolume(),
-		Asks:           []*Order{},
-		Bids:           []*Order{},
-	}
-	for _, limit := range ob.Asks() {
-		for _, order := range limit.Orders {
-			o := Order{
-				Id:        order.Id,
-				UserId:    order.UserId,
-				Price:     order.Limit.Price,
-				Size:      order.Size,
-				Bid:       order.Bid,
-				Timestamp: order.Timestamp,
+		Asks: []*Order{},
+		Bids: []*Order{},
+	}
+
+	// both sides are read between the same two commands
+	err := m.engine.Query(func(ob *orderbook.Orderbook) {
+		orderbookData.TotalBidVolume = ob.BidTotalVolume()
+		orderbookData.TotalAskVolume = ob.AskTotalVolume()
+
+		for _, level := range ob.Asks() {
+			for _, order := range level.Orders {
+				o := newOrder(order)
+				orderbookData.Asks = append(orderbookData.Asks, &o)
 			}
-			orderbookData.Asks = append(orderbookData.Asks, &o)
 		}
-	}
 
-	for _, limit := range ob.Bids() {
-		for _, order := range limit.Orders {
-			o := Order{
-				Id:        order.Id,
-				UserId:    order.UserId,
-				Price:     order.Limit.Price,
-				Size:      order.Size,
-				Bid:       order.Bid,
-				Timestamp: order.Timestamp,
+		for _, level := range ob.Bids() {
+			for _, order := range level.Orders {
+				o := newOrder(order)
+				orderbookData.Bids = append(orderbookData.Bids, &o)
 			}
-			orderbookData.Bids = append(orderbookData.Bids, &o)
 		}
+	})
+	if err != nil {
+		return err
 	}
 
 	return c.JSON(http.StatusOK, orderbookData)
 }
 
-func (ex *Exchange) handlePlaceMarketOrder(market Market, order *orderbook.Order) ([]orderbook.Match, []*MatchedOrder) {
-	ob := ex.orderbooks[market]
-
-	matches := ob.PlaceMarketOrder(order)
+func (ex *Exchange) handlePlaceMarketOrder(market Market, order *orderbook.Order) (engine.Result, []*MatchedOrder, error) {
+	res := ex.submit(market, &engine.Command{Type: engine.CommandPlace, Kind: engine.KindMarket, Order: order})
+	if res.Err != nil {
+		return res, nil, res.Err
+	}
+	matches := res.Matches
 
 	matchedOrders := make([]*MatchedOrder, len(matches))
 
@@ -290,8 +523,7 @@ func (ex *Exchange) handlePlaceMarketOrder(market Market, order *orderbook.Order
 		isBid = true
 	}
 
-	totalSizeFilled := 0.0
-	sumPrice := 0.0
+	totalSizeFilled := decimal.Zero
 	for i := 0; i < len(matchedOrders); i++ {
 		id := matches[i].Bid.Id
 		limitUserId := matches[i].Bid.UserId
@@ -308,117 +540,503 @@ func (ex *Exchange) handlePlaceMarketOrder(market Market, order *orderbook.Order
 			Price:  matches[i].Price,
 		}
 
-		totalSizeFilled += matches[i].SizeFilled
-		sumPrice += matches[i].Price
+		totalSizeFilled = totalSizeFilled.Add(matches[i].SizeFilled)
 	}
 
-	avgPrice := sumPrice / float64(len(matches))
+	m, _ := ex.market(market)
+	fills := newFills(order, matches, m.MarketSpec)
 
-	log.Printf("filled market order => %d | size [%.2f] | avgPrice [%.2f]", order.Id, totalSizeFilled, avgPrice)
+	log.Printf("filled market order => %d | size [%s] | avgPrice [%s] | cancelled size [%s]", order.Id, totalSizeFilled, averagePrice(fills, m.TickSize.Scale()), order.Size)
+
+	return res, matchedOrders, nil
+}
+
+// removeClosedOrders stops tracking the orders res filled or cancelled,
+// it runs on the engine of their market which is the only one changing them
+func (ex *Exchange) removeClosedOrders(res engine.Result) {
+	closed := make(map[*orderbook.Order]bool)
+	for _, match := range res.Matches {
+		closed[match.Bid] = true
+		closed[match.Ask] = true
+	}
+	for _, o := range res.Cancelled {
+		closed[o] = true
+	}
+	// keep the orders still resting in the book or waiting for their trigger
+	for o := range closed {
+		if o.IsResting() || o.IsStopPending() {
+			delete(closed, o)
+		}
+	}
+
+	users := make(map[int64]bool)
+	for o := range closed {
+		users[o.UserId] = true
+	}
 
-	newOrderMap := make(map[int64][]*orderbook.Order)
 	ex.mu.Lock()
-	for userId, orderbookOrders := range ex.Orders {
-		for i := 0; i < len(orderbookOrders); i++ {
-			// if the order is not filled place it in the map copy
-			// this means that size of the order is 0
-			if !orderbookOrders[i].IsFilled() {
-				newOrderMap[userId] = append(newOrderMap[userId], orderbookOrders[i])
+	defer ex.mu.Unlock()
+
+	for userId := range users {
+		orders := []*orderbook.Order{}
+		for _, o := range ex.Orders[userId] {
+			if !closed[o] {
+				orders = append(orders, o)
 			}
 		}
+		if len(orders) == 0 {
+			delete(ex.Orders, userId)
+			continue
+		}
+		ex.Orders[userId] = orders
+	}
+}
+
+func (ex *Exchange) handlePlaceLimitOrder(market Market, price decimal.Decimal, order *orderbook.Order) (engine.Result, error) {
+	order.Price = price
+	res := ex.submit(market, &engine.Command{Type: engine.CommandPlace, Kind: engine.KindLimit, Order: order})
+	if res.Err != nil {
+		return res, res.Err
 	}
-	ex.Orders = newOrderMap
-	ex.mu.Unlock()
+	matches := res.Matches
+
+	// a resting order can trade again as soon as it is placed
+	m, _ := ex.market(market)
+	snapshot := m.ob.Snapshot(order)
+
+	if len(matches) > 0 {
+		if err := ex.handleMatches(matches); err != nil {
+			return res, err
+		}
 
-	return matches, matchedOrders
+		log.Printf("matched LIMIT order => %d | matches [%d] | remaining size [%s]", order.Id, len(matches), snapshot.Size)
+	}
+
+	if !snapshot.IsResting() {
+		return res, nil
+	}
+
+	log.Printf("new LIMIT order => type [%t] | price [%s] | size [%s]", order.Bid, price, snapshot.Size)
+	return res, nil
 }
 
-func (ex *Exchange) handlePlaceLimitOrder(market Market, price float64, order *orderbook.Order) error {
-	ob := ex.orderbooks[market]
-	ob.PlaceLimitOrder(price, order)
+func (ex *Exchange) handlePlaceStopOrder(market Market, order *orderbook.Order) (engine.Result, error) {
+	res := ex.submit(market, &engine.Command{Type: engine.CommandPlace, Kind: engine.KindStop, Order: order})
+	if res.Err != nil {
+		return res, res.Err
+	}
 
-	// keep track of the user orders
-	ex.mu.Lock()
-	ex.Orders[order.UserId] = append(ex.Orders[order.UserId], order)
-	ex.mu.Unlock()
+	// trailing stops move with every trade
+	m, _ := ex.market(market)
+	snapshot := m.ob.Snapshot(order)
+	log.Printf("new STOP order => type [%t] | stop price [%s] | price [%s] | size [%s]", order.Bid, snapshot.StopPrice, snapshot.Price, snapshot.Size)
+	return res, nil
+}
 
-	log.Printf("new LIMIT order => type [%t] | price [%.2f] | size [%.2f]", order.Bid, order.Limit.Price, order.Size)
-	return nil
+func (ex *Exchange) handlePlacePeggedOrder(market Market, order *orderbook.Order) (engine.Result, error) {
+	res := ex.submit(market, &engine.Command{Type: engine.CommandPlace, Kind: engine.KindPegged, Order: order})
+	if res.Err != nil {
+		return res, res.Err
+	}
+
+	// pegged orders move with the top of the book
+	m, _ := ex.market(market)
+	snapshot := m.ob.Snapshot(order)
+	log.Printf("new PEGGED order => type [%t] | peg [%s] | offset [%s] | price [%s] | size [%s]", order.Bid, order.Peg, order.PegOffset, snapshot.Price, snapshot.Size)
+	return res, nil
 }
 
 type PlaceOrderResponse struct {
-	OrderId int64
+	OrderId   int64
+	Status    orderbook.OrderStatus
+	Filled    decimal.Decimal
+	Remaining decimal.Decimal // resting in the book for limit orders, cancelled for market orders
+	// Price the limit order was placed at, it differs from the requested one when a post only order slid
+	Price decimal.Decimal
+	// AveragePrice is the size weighted average price of the fills
+	AveragePrice decimal.Decimal
+	Fills        []Fill
+	PostOnly     PostOnlyResult `json:",omitempty"`
+	// Prevented is the size of the order cancelled by self-trade prevention
+	Prevented decimal.Decimal
+	// CancelledOrders are the resting orders of the user cancelled by self-trade prevention
+	CancelledOrders []int64 `json:",omitempty"`
+	GroupId         int64   `json:",omitempty"`
+	// Execution is set for market orders
+	Execution *Execution `json:",omitempty"`
 }
 
+type PostOnlyResult string
+
+const (
+	PostOnlyAccepted PostOnlyResult = "ACCEPTED"
+	PostOnlyRepriced PostOnlyResult = "REPRICED"
+)
+
 func (ex *Exchange) handlePlaceOrder(c echo.Context) error {
 	var placeOrderData PlaceOrderRequest
 
 	if err := json.NewDecoder(c.Request().Body).Decode(&placeOrderData); err != nil {
-		return err
+		return c.JSON(http.StatusBadRequest, APIError{Code: CodeInvalidRequest, Error: err.Error()})
 	}
 
 	market := Market(placeOrderData.Market)
+	m, ok := ex.market(market)
+	if !ok {
+		return c.JSON(http.StatusBadRequest, APIError{Code: CodeMarketNotFound, Error: "market not found"})
+	}
+
+	if m.halted.Load() {
+		return c.JSON(http.StatusConflict, APIError{Code: CodeMarketHalted, Error: fmt.Sprintf("market %s is halted", market)})
+	}
+
+	if apiErr := m.validateOrder(placeOrderData, ex.lastPrice(market)); apiErr != nil {
+		return c.JSON(http.StatusBadRequest, apiErr)
+	}
+
+	if placeOrderData.Type != LimitOrder && placeOrderData.Type != MarketOrder && placeOrderData.Type != StopMarketOrder && placeOrderData.Type != StopLimitOrder && placeOrderData.Type != TrailingStopOrder && placeOrderData.Type != PeggedOrder {
+		return c.JSON(http.StatusBadRequest, APIError{Code: CodeInvalidRequest, Error: fmt.Sprintf("order type [%s] not supported", placeOrderData.Type)})
+	}
+
+	if !validTimeInForce(placeOrderData.Type, placeOrderData.TimeInForce) {
+		return c.JSON(http.StatusBadRequest, APIError{Code: CodeInvalidRequest, Error: fmt.Sprintf("time in force [%s] not supported for %s orders", placeOrderData.TimeInForce, placeOrderData.Type)})
+	}
+
+	if !placeOrderData.DisplaySize.IsZero() && !validIceberg(placeOrderData) {
+		return c.JSON(http.StatusBadRequest, APIError{Code: CodeInvalidRequest, Error: "display size must be positive and is only supported for GTC and GTD limit orders"})
+	}
+
+	if !validPostOnly(placeOrderData) {
+		return c.JSON(http.StatusBadRequest, APIError{Code: CodeInvalidRequest, Error: fmt.Sprintf("post only [%s] is only supported for GTC and GTD limit orders", placeOrderData.PostOnly)})
+	}
+
+	if !validSlippage(placeOrderData) {
+		return c.JSON(http.StatusBadRequest, APIError{Code: CodeInvalidRequest, Error: "max slippage and worst price can't be negative and are only supported for market orders"})
+	}
+
+	if !validSelfTradePrevention(placeOrderData.SelfTradePrevention) {
+		return c.JSON(http.StatusBadRequest, APIError{Code: CodeInvalidRequest, Error: fmt.Sprintf("self-trade prevention [%s] not supported", placeOrderData.SelfTradePrevention)})
+	}
+
+	var res engine.Result
+
 	order := orderbook.NewOrder(placeOrderData.Bid, placeOrderData.Size, placeOrderData.UserId)
+	order.TimeInForce = placeOrderData.TimeInForce
+	order.ExpiresAt = placeOrderData.ExpiresAt
+	order.PostOnly = placeOrderData.PostOnly
+	order.StopPrice = placeOrderData.StopPrice
+	order.DisplaySize = placeOrderData.DisplaySize
+	order.SelfTradePrevention = placeOrderData.SelfTradePrevention
+	if order.SelfTradePrevention == "" {
+		ex.mu.RLock()
+		if user, ok := ex.Users[order.UserId]; ok {
+			order.SelfTradePrevention = user.SelfTradePrevention
+		}
+		ex.mu.RUnlock()
+	}
+
+	if placeOrderData.Group != nil {
+		return ex.handlePlaceGroup(c, market, order, placeOrderData)
+	}
 
 	// limit orders
 	if placeOrderData.Type == LimitOrder {
-		if err := ex.handlePlaceLimitOrder(market, placeOrderData.Price, order); err != nil {
-			return err
+		if order.TimeInForce == "" {
+			order.TimeInForce = orderbook.GTC
+		}
+		limitRes, err := ex.handlePlaceLimitOrder(market, placeOrderData.Price, order)
+		if err != nil {
+			return handleOrderError(c, err)
+		}
+		res = limitRes
+	}
+
+	// stop orders
+	if placeOrderData.Type == StopMarketOrder || placeOrderData.Type == StopLimitOrder || placeOrderData.Type == TrailingStopOrder {
+		if placeOrderData.Type == TrailingStopOrder {
+			order.TrailingAmount = placeOrderData.TrailingAmount
+			order.TrailingPercent = placeOrderData.TrailingPercent
+		}
+		if placeOrderData.Type == StopLimitOrder {
+			if !placeOrderData.Price.IsPositive() {
+				return c.JSON(http.StatusBadRequest, APIError{Code: CodeInvalidRequest, Error: "stop limit orders need a price"})
+			}
+			order.Price = placeOrderData.Price
+		}
+		stopRes, err := ex.handlePlaceStopOrder(market, order)
+		if err != nil {
+			return handleOrderError(c, err)
 		}
+		res = stopRes
+	}
+
+	// pegged orders
+	if placeOrderData.Type == PeggedOrder {
+		order.Peg = placeOrderData.Peg
+		order.PegOffset = placeOrderData.PegOffset
+		order.PegCap = placeOrderData.PegCap
+		if order.TimeInForce == "" {
+			order.TimeInForce = orderbook.GTC
+		}
+		peggedRes, err := ex.handlePlacePeggedOrder(market, order)
+		if err != nil {
+			return handleOrderError(c, err)
+		}
+		res = peggedRes
 	}
 
 	// market orders
 	if placeOrderData.Type == MarketOrder {
-		matches, matchedOrders := ex.handlePlaceMarketOrder(market, order)
-		if err := ex.handleMatches(matches); err != nil {
+		if order.TimeInForce == "" {
+			order.TimeInForce = orderbook.IOC
+		}
+		order.MaxSlippage = placeOrderData.MaxSlippage
+		order.WorstPrice = placeOrderData.WorstPrice
+		marketRes, matchedOrders, err := ex.handlePlaceMarketOrder(market, order)
+		if err != nil {
+			return handleOrderError(c, err)
+		}
+		res = marketRes
+
+		if err := ex.handleMatches(res.Matches); err != nil {
 			return err
 		}
 
-		// Delete the orders of the user when filled
 		for _, matchedOrder := range matchedOrders {
-			fmt.Printf("Deleting => %+v\n", matchedOrder)
-			userOrders := ex.Orders[matchedOrder.UserId]
-			// if size is 0 order can be deleted
-			for i := 0; i < len(userOrders); i++ {
-				if userOrders[i].IsFilled() {
-					if matchedOrder.Id == userOrders[i].Id {
-						userOrders[i] = userOrders[len(userOrders)-1]
-						userOrders = userOrders[:len(userOrders)-1]
-					}
-
-				}
-			}
+			fmt.Printf("Matched => %+v\n", matchedOrder)
 		}
+	}
 
-		for _, userOrders := range ex.Orders {
-			for i := 0; i < len(userOrders); i++ {
-				userOrders[i] = userOrders[len(userOrders)-1]
-				userOrders = userOrders[:len(userOrders)-1]
-			}
-		}
+	resp := newPlaceOrderResponse(m, order, res.Matches)
+	if placeOrderData.Type == MarketOrder {
+		resp.Execution = newExecution(order, resp.Fills, m.TickSize.Scale())
+	}
 
+	if order.SelfTradePrevention != "" {
+		resp.CancelledOrders = selfTradeCancelled(order, res.Cancelled)
 	}
 
-	resp := &PlaceOrderResponse{
-		OrderId: order.Id,
+	if order.PostOnly != "" {
+		resp.PostOnly = PostOnlyAccepted
+		if !resp.Price.Equal(placeOrderData.Price) {
+			resp.PostOnly = PostOnlyRepriced
+		}
 	}
 
 	return c.JSON(200, resp)
 }
 
+// handlePlaceGroup places order as the take profit of an OCO group or the entry of a bracket
+func (ex *Exchange) handlePlaceGroup(c echo.Context, market Market, order *orderbook.Order, req PlaceOrderRequest) error {
+	groupReq := req.Group
+
+	stopLoss := orderbook.NewOrder(order.Bid, order.Size, order.UserId)
+	stopLoss.StopPrice = groupReq.StopLossPrice
+	stopLoss.Price = groupReq.StopLossLimitPrice
+	stopLoss.SelfTradePrevention = order.SelfTradePrevention
+
+	var cmd *engine.Command
+
+	switch groupReq.Type {
+	case orderbook.GroupOCO:
+		if req.Type != LimitOrder || !groupReq.TakeProfitPrice.IsZero() {
+			return c.JSON(http.StatusBadRequest, APIError{Code: CodeInvalidRequest, Error: "OCO groups are placed with a LIMIT order as their take profit"})
+		}
+		order.Price = req.Price
+		cmd = &engine.Command{Type: engine.CommandPlace, Kind: engine.KindOCO, Order: order, StopLoss: stopLoss}
+	case orderbook.GroupBracket:
+		if req.Type != LimitOrder && req.Type != MarketOrder {
+			return c.JSON(http.StatusBadRequest, APIError{Code: CodeInvalidRequest, Error: "BRACKET groups are placed with a LIMIT or MARKET entry order"})
+		}
+		if req.Type == LimitOrder {
+			order.Price = req.Price
+		} else if order.TimeInForce == "" {
+			order.TimeInForce = orderbook.IOC
+		}
+
+		takeProfit := orderbook.NewOrder(!order.Bid, decimal.Zero, order.UserId)
+		takeProfit.Price = groupReq.TakeProfitPrice
+		takeProfit.SelfTradePrevention = order.SelfTradePrevention
+		stopLoss.Bid = !order.Bid
+
+		cmd = &engine.Command{Type: engine.CommandPlace, Kind: engine.KindBracket, Order: order, TakeProfit: takeProfit, StopLoss: stopLoss}
+	default:
+		return c.JSON(http.StatusBadRequest, APIError{Code: CodeInvalidRequest, Error: fmt.Sprintf("group type [%s] not supported", groupReq.Type)})
+	}
+
+	res := ex.submit(market, cmd)
+	if res.Err != nil {
+		return handleOrderError(c, res.Err)
+	}
+	group, matches := res.Group, res.Matches
+
+	if len(matches) > 0 {
+		if err := ex.handleMatches(matches); err != nil {
+			return err
+		}
+	}
+
+	m, _ := ex.market(market)
+	log.Printf("new %s group => %d | order [%d] | status [%s]", group.Type, group.Id, order.Id, m.ob.SnapshotGroup(group).Status)
+
+	resp := newPlaceOrderResponse(m, order, matches)
+	resp.GroupId = group.Id
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+func validTimeInForce(orderType OrderType, tif orderbook.TimeInForce) bool {
+	if orderType == StopMarketOrder || orderType == StopLimitOrder || orderType == TrailingStopOrder {
+		// triggered stop orders execute as plain market (IOC) or limit (GTC) orders
+		return tif == ""
+	}
+
+	switch tif {
+	case "", orderbook.IOC, orderbook.FOK:
+		return true
+	case orderbook.GTC, orderbook.GTD:
+		return orderType == LimitOrder || orderType == PeggedOrder
+	default:
+		return false
+	}
+}
+
+func validPostOnly(req PlaceOrderRequest) bool {
+	switch req.PostOnly {
+	case "":
+		return true
+	case orderbook.PostOnlyReject, orderbook.PostOnlySlide:
+		return req.Type == LimitOrder && req.TimeInForce != orderbook.IOC && req.TimeInForce != orderbook.FOK
+	default:
+		return false
+	}
+}
+
+func validSlippage(req PlaceOrderRequest) bool {
+	if req.MaxSlippage.IsNegative() || req.WorstPrice.IsNegative() {
+		return false
+	}
+	return req.Type == MarketOrder || req.MaxSlippage.IsZero() && req.WorstPrice.IsZero()
+}
+
+func validSelfTradePrevention(mode orderbook.SelfTradePrevention) bool {
+	switch mode {
+	case "",
+		orderbook.SelfTradeCancelNewest,
+		orderbook.SelfTradeCancelOldest,
+		orderbook.SelfTradeCancelBoth,
+		orderbook.SelfTradeDecrementAndCancel:
+		return true
+	default:
+		return false
+	}
+}
+
+// selfTradeCancelled returns the ids of the resting orders of the user of order that
+// self-trade prevention took out of the book among the orders cancelled while placing it
+func selfTradeCancelled(order *orderbook.Order, cancelled []*orderbook.Order) []int64 {
+	ids := []int64{}
+	for _, o := range cancelled {
+		if o != order && o.UserId == order.UserId && o.Prevented.IsPositive() {
+			ids = append(ids, o.Id)
+		}
+	}
+
+	return ids
+}
+
+func (ex *Exchange) handleSetSelfTradePrevention(c echo.Context) error {
+	idStr := c.Param("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		return err
+	}
+
+	var selfTradePreventionData SelfTradePreventionRequest
+	if err := json.NewDecoder(c.Request().Body).Decode(&selfTradePreventionData); err != nil {
+		return c.JSON(http.StatusBadRequest, APIError{Code: CodeInvalidRequest, Error: err.Error()})
+	}
+
+	if !validSelfTradePrevention(selfTradePreventionData.Mode) {
+		return c.JSON(http.StatusBadRequest, APIError{Code: CodeInvalidRequest, Error: fmt.Sprintf("self-trade prevention [%s] not supported", selfTradePreventionData.Mode)})
+	}
+
+	ex.mu.RLock()
+	user, ok := ex.Users[int64(id)]
+	ex.mu.RUnlock()
+	if !ok {
+		return c.JSON(http.StatusNotFound, APIError{Code: CodeNotFound, Error: "user not found"})
+	}
+
+	mode := selfTradePreventionData.Mode
+	err = ex.update(func() error {
+		if err := ex.journalRecord("", journalRecord{UserId: user.Id, SelfTradePrevention: &mode}); err != nil {
+			return err
+		}
+		ex.mu.Lock()
+		user.SelfTradePrevention = mode
+		ex.mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, map[string]any{"msg": "self-trade prevention updated"})
+}
+
+func validIceberg(req PlaceOrderRequest) bool {
+	return req.Type == LimitOrder &&
+		req.DisplaySize.IsPositive() &&
+		req.TimeInForce != orderbook.IOC &&
+		req.TimeInForce != orderbook.FOK
+}
+
+// handleOrderError answers with a 400 when the orderbook rejected the order
+func handleOrderError(c echo.Context, err error) error {
+	var volumeErr *orderbook.InsufficientVolumeError
+	if errors.As(err, &volumeErr) ||
+		errors.Is(err, orderbook.ErrInvalidExpiry) ||
+		errors.Is(err, orderbook.ErrPostOnlyWouldTake) ||
+		errors.Is(err, orderbook.ErrInvalidStopPrice) ||
+		errors.Is(err, orderbook.ErrStopPriceCrossed) ||
+		errors.Is(err, orderbook.ErrInvalidDisplaySize) ||
+		errors.Is(err, orderbook.ErrVolumeOverflow) ||
+		errors.Is(err, orderbook.ErrInvalidGroup) ||
+		errors.Is(err, orderbook.ErrInvalidTrailingStop) ||
+		errors.Is(err, orderbook.ErrNoTrailingReference) ||
+		errors.Is(err, orderbook.ErrInvalidPeg) ||
+		errors.Is(err, orderbook.ErrNoPegReference) ||
+		errors.Is(err, orderbook.ErrInvalidSlippage) {
+		return c.JSON(http.StatusBadRequest, APIError{Code: CodeOrderRejected, Error: err.Error()})
+	}
+
+	return err
+}
+
+// lastPrice returns the price of the last trade of market, zero when it did not trade yet
+func (ex *Exchange) lastPrice(market Market) decimal.Decimal {
+	m, _ := ex.market(market)
+	price, _ := m.ob.LastPrice()
+	return price
+}
+
 type PriceResponse struct {
-	Price float64
+	Price decimal.Decimal
 }
 
 func (ex *Exchange) handleGetBestBid(c echo.Context) error {
-	market := Market(c.Param("market"))
-	ob := ex.orderbooks[market]
+	m, ok := ex.market(Market(c.Param("market")))
+	if !ok {
+		return c.JSON(http.StatusBadRequest, APIError{Code: CodeMarketNotFound, Error: "market not found"})
+	}
 
-	if len(ob.Bids()) == 0 {
+	bestBid := m.ob.BestBid()
+	if bestBid == nil {
 		return fmt.Errorf("the bids are empty")
 	}
 
-	bestBidPrice := ob.Bids()[0].Price
+	bestBidPrice := bestBid.Price
 
 	pr := PriceResponse{
 		Price: bestBidPrice,
@@ -429,14 +1047,17 @@ func (ex *Exchange) handleGetBestBid(c echo.Context) error {
 }
 
 func (ex *Exchange) handleGetBestAsk(c echo.Context) error {
-	market := Market(c.Param("market"))
-	ob := ex.orderbooks[market]
+	m, ok := ex.market(Market(c.Param("market")))
+	if !ok {
+		return c.JSON(http.StatusBadRequest, APIError{Code: CodeMarketNotFound, Error: "market not found"})
+	}
 
-	if len(ob.Asks()) == 0 {
+	bestAsk := m.ob.BestAsk()
+	if bestAsk == nil {
 		return fmt.Errorf("the asks are empty")
 	}
 
-	bestAskPrice := ob.Asks()[0].Price
+	bestAskPrice := bestAsk.Price
 
 	pr := PriceResponse{
 		Price: bestAskPrice,
@@ -446,6 +1067,93 @@ func (ex *Exchange) handleGetBestAsk(c echo.Context) error {
 
 }
 
+type AmendOrderRequest struct {
+	UserId int64
+	Market Market // optional, found from the order id when empty
+	Price  decimal.Decimal
+	Size   decimal.Decimal // new remaining size of the order
+}
+
+type AmendOrderResponse struct {
+	Amendment *orderbook.Amendment
+	Filled    decimal.Decimal // filled right away when the new price crossed the book
+}
+
+func (ex *Exchange) handleAmendOrder(c echo.Context) error {
+	idStr := c.Param("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		return err
+	}
+
+	var amendOrderData AmendOrderRequest
+	if err := json.NewDecoder(c.Request().Body).Decode(&amendOrderData); err != nil {
+		return c.JSON(http.StatusBadRequest, APIError{Code: CodeInvalidRequest, Error: err.Error()})
+	}
+
+	if amendOrderData.Market == "" {
+		market, _, ok := ex.findOrder(int64(id))
+		if !ok {
+			return c.JSON(http.StatusNotFound, APIError{Code: CodeNotFound, Error: orderbook.ErrOrderNotFound.Error()})
+		}
+		amendOrderData.Market = market
+	}
+
+	m, ok := ex.market(amendOrderData.Market)
+	if !ok {
+		return c.JSON(http.StatusBadRequest, APIError{Code: CodeMarketNotFound, Error: "market not found"})
+	}
+
+	if m.halted.Load() {
+		return c.JSON(http.StatusConflict, APIError{Code: CodeMarketHalted, Error: fmt.Sprintf("market %s is halted", amendOrderData.Market)})
+	}
+
+	if apiErr := m.validateAmend(amendOrderData.Price, amendOrderData.Size, ex.lastPrice(amendOrderData.Market)); apiErr != nil {
+		return c.JSON(http.StatusBadRequest, apiErr)
+	}
+
+	res := ex.submit(amendOrderData.Market, &engine.Command{
+		Type:    engine.CommandAmend,
+		OrderId: int64(id),
+		UserId:  amendOrderData.UserId,
+		Price:   amendOrderData.Price,
+		Size:    amendOrderData.Size,
+	})
+	amendment, matches, err := res.Amendment, res.Matches, res.Err
+	if err != nil {
+		switch {
+		case errors.Is(err, orderbook.ErrOrderNotFound):
+			return c.JSON(http.StatusNotFound, APIError{Code: CodeNotFound, Error: err.Error()})
+		case errors.Is(err, orderbook.ErrNotOrderOwner):
+			return c.JSON(http.StatusForbidden, APIError{Code: CodeForbidden, Error: err.Error()})
+		case errors.Is(err, orderbook.ErrInvalidAmendment):
+			return c.JSON(http.StatusBadRequest, APIError{Code: CodeInvalidRequest, Error: err.Error()})
+		}
+		return handleOrderError(c, err)
+	}
+
+	filled := decimal.Zero
+	if len(matches) > 0 {
+		if err := ex.handleMatches(matches); err != nil {
+			return err
+		}
+
+		for _, match := range matches {
+			if match.Bid.Id == amendment.OrderId || match.Ask.Id == amendment.OrderId {
+				filled = filled.Add(match.SizeFilled)
+			}
+		}
+	}
+
+	log.Printf("order amended => %d | price [%s -> %s] | size [%s -> %s] | priority kept [%t]",
+		amendment.OrderId, amendment.OldPrice, amendment.NewPrice, amendment.OldSize, amendment.NewSize, amendment.PriorityKept)
+
+	return c.JSON(http.StatusOK, AmendOrderResponse{
+		Amendment: amendment,
+		Filled:    filled,
+	})
+}
+
 func (ex *Exchange) handleCancelOrder(c echo.Context) error {
 	idStr := c.Param("id")
 	id, err := strconv.Atoi(idStr)
@@ -453,15 +1161,82 @@ func (ex *Exchange) handleCancelOrder(c echo.Context) error {
 		return err
 	}
 
-	ob := ex.orderbooks[MarketETH]
-	order := ob.Orders[int64(id)]
-	ob.CancelOrder(order)
+	// cancels are accepted in halted markets
+	market, order, ok := ex.findOrder(int64(id))
+	if !ok {
+		return c.JSON(http.StatusNotFound, APIError{Code: CodeNotFound, Error: orderbook.ErrOrderNotFound.Error()})
+	}
+	res := ex.submit(market, &engine.Command{Type: engine.CommandCancel, OrderId: order.Id})
+	if res.Err != nil {
+		// filled or cancelled since it was found
+		return c.JSON(http.StatusNotFound, APIError{Code: CodeNotFound, Error: res.Err.Error()})
+	}
+	// cancelling the entry of a bracket that partly filled places its exits, they can trade
+	if err := ex.handleMatches(res.Matches); err != nil {
+		return err
+	}
 
 	log.Println("order cancelled id =>", id)
 
 	return c.JSON(200, map[string]any{"msg": "order deleted"})
 }
 
+// expireOrders periodically cancels the GTD orders that expired
+// and keeps them so their owners can see what happened
+func (ex *Exchange) expireOrders(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	for now := range ticker.C {
+		for _, m := range ex.listMarkets() {
+			// only journal expiries that cancel something
+			if expiresAt, ok := m.ob.NextExpiry(); !ok || expiresAt > now.UnixNano() {
+				continue
+			}
+			res := m.engine.Submit(&engine.Command{Type: engine.CommandExpire})
+			if err := ex.handleMatches(res.Matches); err != nil {
+				log.Printf("matches of the orders expired in %s not settled: %v", m.Name(), err)
+			}
+		}
+	}
+}
+
+// recordExpired moves the GTD orders that expired in market to the expired orders of their users
+func (ex *Exchange) recordExpired(market Market, expired []*orderbook.Order) {
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+
+	for _, order := range expired {
+		userOrders := ex.Orders[order.UserId]
+		for i := 0; i < len(userOrders); i++ {
+			if userOrders[i] == order {
+				userOrders = append(userOrders[:i], userOrders[i+1:]...)
+				break
+			}
+		}
+		ex.Orders[order.UserId] = userOrders
+		ex.Expired[order.UserId] = append(ex.Expired[order.UserId], order)
+
+		log.Printf("expired GTD order => %d | market [%s] | user [%d]", order.Id, market, order.UserId)
+	}
+}
+
+// handleEvents logs the events of the engines
+func (ex *Exchange) handleEvents() {
+	for event := range ex.events {
+		if event.Trade != nil {
+			log.Printf("event %d => %s | market [%s] | command [%d] | trade [%d] | price [%s] | size [%s]",
+				event.Sequence, event.Type, event.Market, event.Command, event.Trade.Id, event.Trade.Price, event.Trade.Size)
+			continue
+		}
+		if event.Type == engine.EventRejected {
+			log.Printf("event %d => %s | market [%s] | command [%d] | order [%d] | user [%d] | reason [%s]",
+				event.Sequence, event.Type, event.Market, event.Command, event.OrderId, event.UserId, event.Reason)
+			continue
+		}
+		log.Printf("event %d => %s | market [%s] | command [%d] | order [%d] | user [%d]",
+			event.Sequence, event.Type, event.Market, event.Command, event.OrderId, event.UserId)
+	}
+}
+
 func (ex *Exchange) handleMatches(matches []orderbook.Match) error {
 	for _, match := range matches {
 		fromUser, ok := ex.Users[match.Ask.UserId]
@@ -483,7 +1258,7 @@ func (ex *Exchange) handleMatches(matches []orderbook.Match) error {
 		// }
 		// toAddress := crypto.PubkeyToAddress(*publicKeyECDSA)
 
-		amount := big.NewInt(int64(match.SizeFilled))
+		amount := big.NewInt(match.SizeFilled.IntPart())
 
 		transferETH(ex.Client, fromUser.PrivateKey, toAddress, amount)
 	}
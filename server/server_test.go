@@ -0,0 +1,174 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/PanGan21/crypto-exchange-poc/decimal"
+	"github.com/PanGan21/crypto-exchange-poc/engine"
+	"github.com/PanGan21/crypto-exchange-poc/orderbook"
+	"github.com/labstack/echo/v4"
+)
+
+const testPrivateKey = "4f3edf983ac636a65a842ce7c78d9aa706d3b113bce9c46f30d7d21715b23b1d"
+
+func assert(t *testing.T, a, b any) {
+	if !reflect.DeepEqual(a, b) {
+		t.Errorf("%+v != %+v", a, b)
+	}
+}
+
+// call runs handler with body and the path parameters given as name, value pairs
+func call(handler echo.HandlerFunc, body string, params ...string) *httptest.ResponseRecorder {
+	return serve(handler, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)), params...)
+}
+
+// serve runs handler with req and the path parameters given as name, value pairs
+func serve(handler echo.HandlerFunc, req *http.Request, params ...string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+
+	names, values := []string{}, []string{}
+	for i := 0; i < len(params); i += 2 {
+		names = append(names, params[i])
+		values = append(values, params[i+1])
+	}
+	c.SetParamNames(names...)
+	c.SetParamValues(values...)
+
+	handler(c)
+	return rec
+}
+
+// TestGetOrdersWhileTrading lists the orders of a user while the engines fill them, run it with -race
+func TestGetOrdersWhileTrading(t *testing.T) {
+	ex, err := NewExchange(testPrivateKey, nil, DefaultMarkets(), nil)
+	assert(t, err, nil)
+
+	for _, cfg := range DefaultMarkets() {
+		rec := call(ex.handlePlaceOrder, `{"UserId": 1, "Type": "LIMIT", "Market": "`+string(cfg.Name())+`", "Size": "100", "Price": "100"}`)
+		assert(t, rec.Code, http.StatusOK)
+		rec = call(ex.handlePlaceOrder, `{"UserId": 1, "Type": "STOP_MARKET", "Bid": true, "Market": "`+string(cfg.Name())+`", "Size": "1", "StopPrice": "110"}`)
+		assert(t, rec.Code, http.StatusOK)
+	}
+
+	var (
+		trading sync.WaitGroup
+		reading sync.WaitGroup
+		done    = make(chan struct{})
+	)
+	for _, cfg := range DefaultMarkets() {
+		trading.Add(1)
+		go func(market Market) {
+			defer trading.Done()
+			for i := 0; i < 50; i++ {
+				o := orderbook.NewOrder(true, decimal.NewFromInt(1), 2)
+				o.Price = decimal.NewFromInt(100)
+				res := ex.submit(market, &engine.Command{Type: engine.CommandPlace, Kind: engine.KindLimit, Order: o})
+				assert(t, res.Err, nil)
+			}
+		}(cfg.Name())
+	}
+
+	reading.Add(1)
+	go func() {
+		defer reading.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			call(ex.handleGetOrders, "", "userId", "1")
+			call(ex.handleGetOrderHistory, "", "userId", "1")
+		}
+	}()
+
+	trading.Wait()
+	close(done)
+	reading.Wait()
+
+	var resp GetOrdersResponse
+	rec := call(ex.handleGetOrders, "", "userId", "1")
+	assert(t, json.Unmarshal(rec.Body.Bytes(), &resp), nil)
+	assert(t, len(resp.Asks), 2)
+	assert(t, len(resp.Stops), 2)
+	for _, ask := range resp.Asks {
+		assert(t, *ask.TotalRemaining, decimal.NewFromInt(50))
+	}
+
+	// the orders the buyer filled are closed and only in the history
+	rec = call(ex.handleGetOrders, "", "userId", "2")
+	assert(t, json.Unmarshal(rec.Body.Bytes(), &resp), nil)
+	assert(t, len(resp.Bids), 0)
+}
+
+// unboundedMarket has no size or notional limits
+func unboundedMarket() MarketConfig {
+	return MarketConfig{
+		Base:  "ETH",
+		Quote: "USDC",
+		MarketSpec: MarketSpec{
+			TickSize: decimal.RequireFromString("0.01"),
+			LotSize:  decimal.NewFromInt(1),
+		},
+	}
+}
+
+func TestBookVolumeOverflow(t *testing.T) {
+	ex, err := NewExchange(testPrivateKey, nil, []MarketConfig{unboundedMarket()}, nil)
+	assert(t, err, nil)
+
+	order := `{"UserId": 1, "Type": "LIMIT", "Market": "ETH-USDC", "Size": "5000000000000000000", "Price": "0.01"}`
+	rec := call(ex.handlePlaceOrder, order)
+	assert(t, rec.Code, http.StatusOK)
+
+	var apiErr APIError
+	rec = call(ex.handlePlaceOrder, order)
+	assert(t, rec.Code, http.StatusBadRequest)
+	assert(t, json.Unmarshal(rec.Body.Bytes(), &apiErr), nil)
+	assert(t, apiErr.Code, CodeOrderRejected)
+}
+
+func TestBracketEntryFillsNothingHistory(t *testing.T) {
+	ex, err := NewExchange(testPrivateKey, nil, DefaultMarkets(), nil)
+	assert(t, err, nil)
+
+	rec := call(ex.handlePlaceOrder, `{"UserId": 1, "Type": "LIMIT", "Bid": true, "TimeInForce": "IOC", "Market": "ETH-USDC", "Size": "1", "Price": "100",
+		"Group": {"Type": "BRACKET", "TakeProfitPrice": "110", "StopLossPrice": "90"}}`)
+	assert(t, rec.Code, http.StatusOK)
+
+	// the exits were never placed, they are closed with the group
+	var resp OrderHistoryResponse
+	rec = call(ex.handleGetOrderHistory, "", "userId", "1")
+	assert(t, json.Unmarshal(rec.Body.Bytes(), &resp), nil)
+	assert(t, len(resp.Orders), 3)
+	for _, o := range resp.Orders {
+		assert(t, o.Status, orderbook.StatusCancelled)
+	}
+}
+
+func TestInvalidRequestBody(t *testing.T) {
+	ex, err := NewExchange(testPrivateKey, nil, DefaultMarkets(), nil)
+	assert(t, err, nil)
+
+	for _, size := range []string{`"NaN"`, `"0.0000000000000000001"`, `1e3`} {
+		for _, rec := range []*httptest.ResponseRecorder{
+			call(ex.handlePlaceOrder, `{"UserId": 1, "Type": "LIMIT", "Market": "ETH-USDC", "Price": "100", "Size": `+size+`}`),
+			call(ex.handleAmendOrder, `{"Price": "100", "Size": `+size+`}`, "id", "1"),
+		} {
+			var apiErr APIError
+			assert(t, rec.Code, http.StatusBadRequest)
+			assert(t, json.Unmarshal(rec.Body.Bytes(), &apiErr), nil)
+			assert(t, apiErr.Code, CodeInvalidRequest)
+		}
+	}
+
+	rec := call(ex.handleSetSelfTradePrevention, `{"Mode": 1}`, "id", "1")
+	assert(t, rec.Code, http.StatusBadRequest)
+}
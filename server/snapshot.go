@@ -0,0 +1,197 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/PanGan21/crypto-exchange-poc/engine"
+	"github.com/PanGan21/crypto-exchange-poc/orderbook"
+)
+
+// exchangeSnapshot is the state of the exchange at the start of a segment of its journal.
+// Orders and groups are references into the orders and groups of Books.
+type exchangeSnapshot struct {
+	Segment       uint64
+	Markets       []marketSnapshot
+	Books         *orderbook.Snapshot
+	HistoryCursor int64
+	History       []historySnapshot // Oldest first
+	Orders        map[int64][]int
+	Expired       map[int64][]int
+	Groups        map[int64][]int
+	// SelfTradePrevention is the mode of the accounts that set one
+	SelfTradePrevention map[int64]orderbook.SelfTradePrevention
+}
+
+type marketSnapshot struct {
+	Config MarketConfig
+	Halted bool
+	Engine engine.State
+}
+
+type historySnapshot struct {
+	Cursor int64
+	Market Market
+	Order  int
+}
+
+// snapshots saves a snapshot of the exchange every interval
+func (ex *Exchange) snapshots(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	for range ticker.C {
+		if err := ex.snapshot(); err != nil {
+			log.Println("snapshot failed:", err)
+		}
+	}
+}
+
+// snapshot saves the state of the exchange when the journal grew since the last snapshot,
+// the journal segments it covers are removed
+func (ex *Exchange) snapshot() error {
+	var snapshot *exchangeSnapshot
+
+	// no command is applied while the state is copied
+	err := ex.journal.Checkpoint(func(segment uint64) error {
+		if segment != ex.snapshotSegment {
+			snapshot = ex.takeSnapshot(segment)
+		}
+		return nil
+	})
+	if err != nil || snapshot == nil {
+		return err
+	}
+
+	if err := ex.journal.SaveSnapshot(snapshot.Segment, snapshot); err != nil {
+		return err
+	}
+	ex.snapshotSegment = snapshot.Segment
+
+	log.Printf("snapshot saved => segment [%d] | markets [%d] | orders [%d]", snapshot.Segment, len(snapshot.Markets), len(snapshot.Books.Orders))
+
+	return nil
+}
+
+func (ex *Exchange) takeSnapshot(segment uint64) *exchangeSnapshot {
+	snapshot := &exchangeSnapshot{
+		Segment: segment,
+		Markets: []marketSnapshot{},
+		Books:   orderbook.NewSnapshot(),
+		History: []historySnapshot{},
+		Orders:  make(map[int64][]int),
+		Expired: make(map[int64][]int),
+		Groups:  make(map[int64][]int),
+
+		SelfTradePrevention: make(map[int64]orderbook.SelfTradePrevention),
+	}
+
+	for _, m := range ex.listMarkets() {
+		snapshot.Markets = append(snapshot.Markets, marketSnapshot{
+			Config: m.MarketConfig,
+			Halted: m.halted.Load(),
+			Engine: m.engine.State(),
+		})
+		snapshot.Books.AddBook(string(m.Name()), m.ob)
+	}
+
+	ex.mu.RLock()
+	defer ex.mu.RUnlock()
+
+	snapshot.HistoryCursor = ex.historyCursor
+	for _, records := range ex.history {
+		for _, record := range records {
+			snapshot.History = append(snapshot.History, historySnapshot{
+				Cursor: record.cursor,
+				Market: record.market,
+				Order:  snapshot.Books.OrderRef(record.order),
+			})
+		}
+	}
+	sort.Slice(snapshot.History, func(i, j int) bool {
+		return snapshot.History[i].Cursor < snapshot.History[j].Cursor
+	})
+
+	for userId, orders := range ex.Orders {
+		for _, o := range orders {
+			snapshot.Orders[userId] = append(snapshot.Orders[userId], snapshot.Books.OrderRef(o))
+		}
+	}
+	for userId, orders := range ex.Expired {
+		for _, o := range orders {
+			snapshot.Expired[userId] = append(snapshot.Expired[userId], snapshot.Books.OrderRef(o))
+		}
+	}
+	for userId, groups := range ex.Groups {
+		for _, g := range groups {
+			snapshot.Groups[userId] = append(snapshot.Groups[userId], snapshot.Books.GroupRef(g))
+		}
+	}
+	for userId, user := range ex.Users {
+		if user.SelfTradePrevention != "" {
+			snapshot.SelfTradePrevention[userId] = user.SelfTradePrevention
+		}
+	}
+
+	return snapshot
+}
+
+// restoreSnapshot replaces the markets, the orders and the settings of the users with the ones of snapshot.
+// The markets of the config keep their config.
+func (ex *Exchange) restoreSnapshot(snapshot *exchangeSnapshot) error {
+	for _, ms := range snapshot.Markets {
+		cfg := ms.Config
+		if m, ok := ex.market(cfg.Name()); ok {
+			cfg = m.MarketConfig
+		}
+
+		if err := ex.restoreMarket(cfg, snapshot.Books, ms.Engine); err != nil {
+			return fmt.Errorf("market %s: %w", cfg.Name(), err)
+		}
+
+		m, _ := ex.market(cfg.Name())
+		m.halted.Store(ms.Halted)
+	}
+
+	for userId, mode := range snapshot.SelfTradePrevention {
+		if err := ex.setSelfTradePrevention(userId, mode); err != nil {
+			return err
+		}
+	}
+
+	books := snapshot.Books
+
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+
+	ex.historyCursor = snapshot.HistoryCursor
+	for _, record := range snapshot.History {
+		o := books.Order(record.Order)
+		ex.history[o.UserId] = append(ex.history[o.UserId], orderRecord{
+			cursor: record.Cursor,
+			market: record.Market,
+			order:  o,
+		})
+		ex.orderMarkets[o] = record.Market
+	}
+
+	for userId, refs := range snapshot.Orders {
+		for _, ref := range refs {
+			ex.Orders[userId] = append(ex.Orders[userId], books.Order(ref))
+		}
+	}
+	for userId, refs := range snapshot.Expired {
+		for _, ref := range refs {
+			ex.Expired[userId] = append(ex.Expired[userId], books.Order(ref))
+		}
+	}
+	for userId, refs := range snapshot.Groups {
+		for _, ref := range refs {
+			ex.Groups[userId] = append(ex.Groups[userId], books.Group(ref))
+		}
+	}
+
+	ex.snapshotSegment = snapshot.Segment
+
+	return nil
+}
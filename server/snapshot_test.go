@@ -0,0 +1,112 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/PanGan21/crypto-exchange-poc/decimal"
+	"github.com/PanGan21/crypto-exchange-poc/engine"
+	"github.com/PanGan21/crypto-exchange-poc/orderbook"
+	"github.com/labstack/echo/v4"
+)
+
+// newTestExchange runs the default markets for users 1 and 2
+func newTestExchange(t *testing.T) *Exchange {
+	ex, err := NewExchange(testPrivateKey, nil, DefaultMarkets(), nil)
+	assert(t, err, nil)
+	for _, id := range []int64{1, 2} {
+		ex.Users[id] = NewUser(testPrivateKey, id)
+	}
+	return ex
+}
+
+// exchangeState is what the API shows of the exchange
+func exchangeState(t *testing.T, ex *Exchange) map[string]string {
+	state := make(map[string]string)
+	get := func(name string, handler echo.HandlerFunc, params ...string) {
+		rec := serve(handler, httptest.NewRequest(http.MethodGet, "/", nil), params...)
+		assert(t, rec.Code, http.StatusOK)
+		state[name] = rec.Body.String()
+	}
+
+	get("markets", ex.handleListMarkets)
+	for _, m := range DefaultMarkets() {
+		get("book "+string(m.Name()), ex.handleGetBook, "market", string(m.Name()))
+		get("trades "+string(m.Name()), ex.handleGetTrades, "market", string(m.Name()))
+	}
+	for _, userId := range []string{"1", "2"} {
+		get("orders "+userId, ex.handleGetOrders, "userId", userId)
+		get("history "+userId, ex.handleGetOrderHistory, "userId", userId)
+	}
+
+	return state
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	ex := newTestExchange(t)
+
+	// resting, filled and cancelled orders in both markets
+	placeLimit(t, ex, "ETH-USDC", false, 100, 2, 1, 1_000)
+	placeLimit(t, ex, "ETH-USDC", true, 100, 1, 2, 2_000)
+	placeLimit(t, ex, "WBTC-ETH", true, 15, 3, 2, 3_000)
+	cancelled := placeLimit(t, ex, "WBTC-ETH", true, 14, 1, 1, 4_000)
+	assert(t, ex.submit("WBTC-ETH", &engine.Command{Type: engine.CommandCancel, OrderId: cancelled.Id}).Err, nil)
+
+	// a stop and a pending bracket
+	rec := call(ex.handlePlaceOrder, `{"UserId": 2, "Type": "STOP_MARKET", "Bid": true, "Market": "ETH-USDC", "Size": "1", "StopPrice": "110"}`)
+	assert(t, rec.Code, http.StatusOK)
+	rec = call(ex.handlePlaceOrder, `{"UserId": 1, "Type": "LIMIT", "Bid": true, "Market": "ETH-USDC", "Size": "1", "Price": "95",
+		"Group": {"Type": "BRACKET", "TakeProfitPrice": "105", "StopLossPrice": "90"}}`)
+	assert(t, rec.Code, http.StatusOK)
+
+	// an expired GTD order
+	gtd := orderbook.NewOrder(false, decimal.NewFromInt(1), 2)
+	gtd.Price = decimal.NewFromInt(20)
+	gtd.TimeInForce = orderbook.GTD
+	gtd.ExpiresAt = time.Now().Add(time.Millisecond).UnixNano()
+	assert(t, ex.submit("WBTC-ETH", &engine.Command{Type: engine.CommandPlace, Kind: engine.KindLimit, Order: gtd}).Err, nil)
+	time.Sleep(2 * time.Millisecond)
+	res := ex.submit("WBTC-ETH", &engine.Command{Type: engine.CommandExpire})
+	assert(t, res.Expired, []*orderbook.Order{gtd})
+
+	// the settings of the markets and the users
+	rec = call(ex.handleSetSelfTradePrevention, `{"Mode": "CANCEL_BOTH"}`, "id", "1")
+	assert(t, rec.Code, http.StatusOK)
+	ex.adminKey = "secret"
+	rec = adminCall(ex, ex.handleHaltMarket(true), "secret", "", "market", "WBTC-ETH")
+	assert(t, rec.Code, http.StatusOK)
+
+	// the snapshot is saved as JSON
+	data, err := json.Marshal(ex.takeSnapshot(3))
+	assert(t, err, nil)
+	var snapshot *exchangeSnapshot
+	assert(t, json.Unmarshal(data, &snapshot), nil)
+
+	restored := newTestExchange(t)
+	assert(t, restored.restoreSnapshot(snapshot), nil)
+
+	assert(t, exchangeState(t, restored), exchangeState(t, ex))
+	assert(t, restored.historyCursor, ex.historyCursor)
+	assert(t, restored.snapshotSegment, uint64(3))
+	assert(t, restored.Users[1].SelfTradePrevention, orderbook.SelfTradeCancelBoth)
+	assert(t, restored.Users[2].SelfTradePrevention, orderbook.SelfTradePrevention(""))
+	assert(t, len(restored.Expired[2]), 1)
+	assert(t, len(restored.Groups[1]), 1)
+
+	// both go on with the same ids and fill the bracket entry the same way,
+	// only the times of the new trades differ
+	states := []map[string]string{}
+	for _, e := range []*Exchange{ex, restored} {
+		placeLimit(t, e, "ETH-USDC", true, 95, 1, 2, 5_000)
+		placeLimit(t, e, "ETH-USDC", false, 95, 2, 2, 6_000)
+
+		state := exchangeState(t, e)
+		delete(state, "trades ETH-USDC")
+		states = append(states, state)
+	}
+	assert(t, states[1], states[0])
+	assert(t, restored.Groups[1][0].Status, orderbook.GroupActive)
+}